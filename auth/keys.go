@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"MineTracker/database"
+	"MineTracker/util"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleReader = "reader"
+)
+
+const (
+	ScopeRead         = "read"          // REST read endpoints
+	ScopeWebsocket    = "ws"            // /ws upgrade
+	ScopeServersWrite = "servers:write" // admin server management
+	ScopeKeys         = "keys"          // issuing and revoking API keys
+	ScopeDebug        = "debug"         // pprof and other debug endpoints
+)
+
+// roleScopes lists the scopes each role may hold. Keys issued without explicit
+// scopes get all of them.
+var roleScopes = map[string][]string{
+	RoleReader: {ScopeRead, ScopeWebsocket},
+	RoleAdmin:  {ScopeRead, ScopeWebsocket, ScopeServersWrite, ScopeKeys, ScopeDebug},
+}
+
+// tokenPrefix marks MineTracker API keys so they are easy to spot in configs
+// and secret scanners.
+const tokenPrefix = "mt_"
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrKeyExpired  = errors.New("API key expired")
+	ErrKeyRevoked  = errors.New("API key revoked")
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is a stored key. Only the SHA-256 of the token is kept; the token
+// itself is returned once when the key is issued.
+type APIKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Hash       string   `json:"-"`
+	Prefix     string   `json:"prefix"` // first characters of the token, for identification
+	Role       string   `json:"role"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"` // unix seconds, 0 = never
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+	Revoked    bool     `json:"revoked"`
+	RevokedAt  int64    `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key may use scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// check returns why the key can not be used right now, if at all.
+func (k *APIKey) check(now time.Time) error {
+	if k.Revoked {
+		return ErrKeyRevoked
+	}
+	if k.ExpiresAt != 0 && now.Unix() >= k.ExpiresAt {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+// IssueRequest describes a key to create.
+type IssueRequest struct {
+	Name      string        `json:"name"`
+	Role      string        `json:"role"`
+	Scopes    []string      `json:"scopes"`
+	ExpiresIn time.Duration `json:"-"`
+}
+
+// Validate checks the role and that every scope is allowed for it, filling in
+// the role's default scopes when none are given.
+func (r *IssueRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if r.ExpiresIn < 0 {
+		return errors.New("expiry must be in the future")
+	}
+	allowed, ok := roleScopes[r.Role]
+	if !ok {
+		return fmt.Errorf("unknown role %q", r.Role)
+	}
+	if len(r.Scopes) == 0 {
+		r.Scopes = slices.Clone(allowed)
+		return nil
+	}
+	for _, s := range r.Scopes {
+		if !slices.Contains(allowed, s) {
+			return fmt.Errorf("scope %q is not allowed for role %q", s, r.Role)
+		}
+	}
+	return nil
+}
+
+func keysCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("api_keys")
+}
+
+// EnsureKeyIndexes creates the unique indexes used for token lookups.
+func EnsureKeyIndexes(ctx context.Context) error {
+	_, err := keysCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IssueKey creates a key and returns it together with its token. The token is
+// not stored and can not be recovered later.
+func IssueKey(ctx context.Context, req IssueRequest) (APIKey, string, error) {
+	if err := req.Validate(); err != nil {
+		return APIKey{}, "", err
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	token := tokenPrefix + secret
+
+	now := time.Now()
+	key := APIKey{
+		ID:        id,
+		Name:      req.Name,
+		Hash:      hashToken(token),
+		Prefix:    token[:len(tokenPrefix)+6],
+		Role:      req.Role,
+		Scopes:    req.Scopes,
+		CreatedAt: now.Unix(),
+	}
+	if req.ExpiresIn > 0 {
+		key.ExpiresAt = now.Add(req.ExpiresIn).Unix()
+	}
+
+	if _, err := keysCollection().InsertOne(ctx, key); err != nil {
+		return APIKey{}, "", err
+	}
+	return key, token, nil
+}
+
+// ListKeys returns every key, including revoked and expired ones.
+func ListKeys(ctx context.Context) ([]APIKey, error) {
+	cursor, err := keysCollection().Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeKey marks a key as revoked. It stops working immediately.
+func RevokeKey(ctx context.Context, id string) error {
+	var key APIKey
+	err := keysCollection().FindOneAndUpdate(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"revoked": true, "revokedat": time.Now().Unix()}},
+	).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	keyCache.forget(key.Hash)
+	return nil
+}
+
+// keyCacheTTL bounds how long a looked up key is trusted without asking
+// MongoDB again. Revocations through this process apply immediately anyway.
+var keyCacheTTL = time.Minute
+
+// lastUsedResolution limits last-seen writes to one per key per interval.
+const lastUsedResolution = time.Minute
+
+type cachedKey struct {
+	key     APIKey
+	fetched time.Time
+}
+
+type keyCacheMap struct {
+	mu      sync.Mutex
+	entries map[string]*cachedKey
+}
+
+var keyCache = &keyCacheMap{entries: make(map[string]*cachedKey)}
+
+func (c *keyCacheMap) get(hash string, now time.Time) (APIKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || now.Sub(e.fetched) > keyCacheTTL {
+		return APIKey{}, false
+	}
+	return e.key, true
+}
+
+func (c *keyCacheMap) put(key APIKey, now time.Time) {
+	c.mu.Lock()
+	c.entries[key.Hash] = &cachedKey{key: key, fetched: now}
+	c.mu.Unlock()
+}
+
+func (c *keyCacheMap) forget(hash string) {
+	c.mu.Lock()
+	delete(c.entries, hash)
+	c.mu.Unlock()
+}
+
+// touch records now as the key's last use and reports whether the stored
+// timestamp is stale enough to be written back.
+func (c *keyCacheMap) touch(hash string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || now.Unix()-e.key.LastUsedAt < int64(lastUsedResolution/time.Second) {
+		return false
+	}
+	e.key.LastUsedAt = now.Unix()
+	return true
+}
+
+// Lookup resolves a token to its key, rejecting unknown, revoked and expired
+// keys, and records the use.
+func Lookup(ctx context.Context, token string) (APIKey, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	hash := hashToken(token)
+	now := time.Now()
+
+	key, ok := keyCache.get(hash, now)
+	if !ok {
+		err := keysCollection().FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return APIKey{}, ErrInvalidKey
+		}
+		if err != nil {
+			return APIKey{}, err
+		}
+		keyCache.put(key, now)
+	}
+
+	if err := key.check(now); err != nil {
+		return APIKey{}, err
+	}
+
+	if keyCache.touch(hash, now) {
+		go recordLastUsed(hash, now)
+	}
+	return key, nil
+}
+
+func recordLastUsed(hash string, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := keysCollection().UpdateOne(ctx,
+		bson.M{"hash": hash},
+		bson.M{"$max": bson.M{"lastusedat": at.Unix()}},
+	)
+	if err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to record API key usage")
+	}
+}
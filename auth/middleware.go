@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextKey is where Authenticate stores the caller's *APIKey.
+const contextKey = "apiKey"
+
+// bootstrapKey stands in for ADMIN_TOKEN, which authenticates as an admin with
+// every scope so the first real keys can be issued. It is not stored.
+func bootstrapKey() *APIKey {
+	return &APIKey{
+		ID:     "bootstrap",
+		Name:   "ADMIN_TOKEN",
+		Role:   RoleAdmin,
+		Scopes: slices.Clone(roleScopes[RoleAdmin]),
+	}
+}
+
+// readAuthRequired makes read endpoints and /ws reject anonymous callers.
+// Otherwise a key is optional there and only checked when one is sent.
+func readAuthRequired() bool {
+	return os.Getenv("AUTH_REQUIRED") == "true"
+}
+
+// queryTokenKey is where HideQueryToken keeps the token it took from the URL.
+const queryTokenKey = "queryToken"
+
+// HideQueryToken moves the token query parameter out of the URL into the
+// request context, on every path so a token sent by mistake is not logged
+// either. The request logger reads the URL before any later handler
+// runs, so this must be registered ahead of gin.Logger.
+func HideQueryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := stripQueryToken(c); token != "" {
+			c.Set(queryTokenKey, token)
+		}
+		c.Next()
+	}
+}
+
+// stripQueryToken removes the token query parameter from the request URL and
+// returns its value.
+func stripQueryToken(c *gin.Context) string {
+	q := c.Request.URL.Query()
+	token := q.Get("token")
+	if token == "" {
+		return ""
+	}
+	q.Del("token")
+	c.Request.URL.RawQuery = q.Encode()
+	return token
+}
+
+// requestToken extracts the API key from the Authorization or X-API-Key
+// header. Browsers can not set headers on a websocket upgrade, so the token
+// query parameter is accepted there; HideQueryToken has usually taken it out
+// of the URL already so the request logger never prints it.
+func requestToken(c *gin.Context, allowQuery bool) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if h := c.GetHeader("X-API-Key"); h != "" {
+		return strings.TrimSpace(h)
+	}
+	if allowQuery {
+		if token := c.GetString(queryTokenKey); token != "" {
+			return token
+		}
+		return stripQueryToken(c)
+	}
+	return ""
+}
+
+func authenticate(c *gin.Context, token string) (*APIKey, error) {
+	if admin := os.Getenv("ADMIN_TOKEN"); admin != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+		return bootstrapKey(), nil
+	}
+
+	key, err := Lookup(c.Request.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Authenticate resolves the request's API key, if any, and stores it for
+// Require and Allow. A key that is sent but invalid, expired or revoked is
+// rejected even on public routes, so misconfigured clients notice.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c, c.Request.URL.Path == "/ws")
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		key, err := authenticate(c, token)
+		if err != nil {
+			if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrKeyExpired) || errors.Is(err, ErrKeyRevoked) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+			return
+		}
+
+		c.Set(contextKey, key)
+		c.Next()
+	}
+}
+
+// KeyFromContext returns the authenticated key, or nil for anonymous requests.
+func KeyFromContext(c *gin.Context) *APIKey {
+	v, ok := c.Get(contextKey)
+	if !ok {
+		return nil
+	}
+	key, _ := v.(*APIKey)
+	return key
+}
+
+// Require only lets through callers with role and scope.
+func Require(role, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := KeyFromContext(c)
+		if key == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if key.Role != role || !key.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing role or scope " + role + "/" + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow guards public endpoints: anonymous callers pass unless AUTH_REQUIRED
+// is set, but an authenticated key must hold scope.
+func Allow(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := KeyFromContext(c)
+		if key == nil {
+			if readAuthRequired() {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+				return
+			}
+			c.Next()
+			return
+		}
+		if !key.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		name       string
+		url        string
+		header     map[string]string
+		allowQuery bool
+		want       string
+		wantQuery  string
+	}{
+		{name: "none", url: "/api/servers", want: ""},
+		{name: "bearer", url: "/api/servers", header: map[string]string{"Authorization": "Bearer  mt_abc "}, want: "mt_abc"},
+		{name: "basic is ignored", url: "/api/servers", header: map[string]string{"Authorization": "Basic dXNlcg=="}, want: ""},
+		{name: "api key header", url: "/api/servers", header: map[string]string{"X-API-Key": "mt_def"}, want: "mt_def"},
+		{
+			name:   "bearer wins over api key",
+			url:    "/api/servers",
+			header: map[string]string{"Authorization": "Bearer mt_abc", "X-API-Key": "mt_def"},
+			want:   "mt_abc",
+		},
+		{name: "query not allowed", url: "/api/servers?token=mt_q", want: "", wantQuery: "token=mt_q"},
+		{name: "query", url: "/ws?id=a&token=mt_q", allowQuery: true, want: "mt_q", wantQuery: "id=a"},
+		{
+			name:       "header wins over query",
+			url:        "/ws?token=mt_q",
+			header:     map[string]string{"X-API-Key": "mt_def"},
+			allowQuery: true,
+			want:       "mt_def",
+			wantQuery:  "token=mt_q",
+		},
+	}
+
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, tc.url, nil)
+		for k, v := range tc.header {
+			c.Request.Header.Set(k, v)
+		}
+
+		if got := requestToken(c, tc.allowQuery); got != tc.want {
+			t.Errorf("%s: token %q, want %q", tc.name, got, tc.want)
+		}
+		if got := c.Request.URL.RawQuery; got != tc.wantQuery {
+			t.Errorf("%s: query %q, want %q", tc.name, got, tc.wantQuery)
+		}
+	}
+}
+
+func TestQueryTokenNotLogged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var logged bytes.Buffer
+	var seen string
+	r := gin.New()
+	r.Use(HideQueryToken(), gin.LoggerWithWriter(&logged))
+	r.GET("/ws", func(c *gin.Context) {
+		seen = requestToken(c, true)
+		c.Status(http.StatusNoContent)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws?token=mt_secret&id=a", nil))
+
+	if seen != "mt_secret" {
+		t.Errorf("handler saw token %q", seen)
+	}
+	if strings.Contains(logged.String(), "mt_secret") {
+		t.Errorf("token logged: %s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "/ws?id=a") {
+		t.Errorf("request not logged: %s", logged.String())
+	}
+}
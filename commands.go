@@ -0,0 +1,161 @@
+package main
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"MineTracker/task"
+	"MineTracker/util"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCommand runs a maintenance subcommand instead of the tracker, e.g.
+// `MineTracker migrate-ids`. It reports whether args named a command.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "migrate-ids":
+		migrateIDsCommand(args[1:])
+	case "validate":
+		validateCommand(args[1:])
+	case "backfill-rollups":
+		backfillRollupsCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+	return true
+}
+
+// migrateIDsCommand assigns server IDs in MongoDB and moves the InfluxDB
+// history written before IDs existed onto them.
+func migrateIDsCommand(args []string) {
+	fs := flag.NewFlagSet("migrate-ids", flag.ExitOnError)
+	deleteLegacy := fs.Bool("delete-legacy", false, "delete the migrated ip-tagged points afterwards")
+	_ = fs.Parse(args)
+
+	ctx := context.Background()
+
+	database.ConnectMongo(os.Getenv("MONGO_URI"))
+
+	if err := task.MigrateServerIDs(ctx); err != nil {
+		util.Logger.Fatal().Err(err).Msg("Failed to assign server IDs")
+	}
+	if err := task.LoadAliases(ctx); err != nil {
+		util.Logger.Fatal().Err(err).Msg("Failed to load server aliases")
+	}
+
+	// The local backend never stored ip-tagged history, so there is nothing
+	// to move.
+	if data.TimeSeriesBackend() != data.TimeSeriesInflux {
+		util.Logger.Info().Msg("Skipping history migration for the " + data.TimeSeriesBackend() + " time-series backend")
+	} else {
+		if err := database.ConnectInflux(); err != nil {
+			util.Logger.Fatal().Err(err).Msg("Failed to connect to InfluxDB")
+		}
+		if err := task.MigrateInfluxHistory(ctx, *deleteLegacy); err != nil {
+			util.Logger.Fatal().Err(err).Msg("Failed to migrate InfluxDB history")
+		}
+	}
+
+	util.Logger.Info().Msg("Server ID migration finished")
+}
+
+// backfillRollupsCommand rolls up the existing raw history into the rollup
+// tiers. With InfluxDB it can run next to the tracker, which takes over from
+// where the backfill ends; the local backend belongs to one process, so stop
+// the tracker first.
+//
+//	MineTracker backfill-rollups [--tier 1h] [--since 90d]
+func backfillRollupsCommand(args []string) {
+	fs := flag.NewFlagSet("backfill-rollups", flag.ExitOnError)
+	tier := fs.String("tier", "", "only backfill this tier, e.g. 1h")
+	since := fs.String("since", "10y", "how far back to backfill, capped at each tier's retention")
+	_ = fs.Parse(args)
+
+	span, err := data.ParseFluxDuration(*since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --since: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	database.ConnectMongo(os.Getenv("MONGO_URI"))
+	if err := data.OpenTimeSeriesStore(); err != nil {
+		util.Logger.Fatal().Err(err).Msg("Failed to open the time-series store")
+	}
+	defer data.TimeSeries.Close()
+
+	if err := task.EnsureRollupIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create rollup progress indexes")
+	}
+	if err := task.BackfillRollups(ctx, *tier, span.Approx()); err != nil {
+		util.Logger.Fatal().Err(err).Msg("Failed to backfill rollups")
+	}
+
+	util.Logger.Info().Msg("Rollup backfill finished")
+}
+
+// validateCommand checks a servers file without starting the tracker and
+// exits non-zero when it has errors. With --resolve every valid server is
+// pinged once and unreachable ones are reported as well.
+//
+//	MineTracker validate [--resolve] [servers.json]
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	resolve := fs.Bool("resolve", false, "ping every server once and report unreachable ones")
+	_ = fs.Parse(args)
+
+	path := "servers.json"
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	file, issues := data.CheckServersFile(raw)
+
+	var errCount int
+	for _, issue := range issues {
+		if !issue.Warning {
+			errCount++
+		}
+		fmt.Printf("%s:%s\n", path, issue)
+	}
+	if errCount > 0 {
+		fmt.Printf("%s: %d error(s), %d warning(s)\n", path, errCount, len(issues)-errCount)
+		os.Exit(1)
+	}
+
+	var unreachable int
+	if *resolve {
+		for i, perr := range task.ProbeServers(file.Servers) {
+			if perr == nil {
+				continue
+			}
+			unreachable++
+			s := file.Servers[i]
+			fmt.Printf("%s:%d: unreachable: %s (%s): %v\n", path, file.ServerLine(i), s.Name, s.IP, perr)
+		}
+	}
+
+	fmt.Printf("%s: %d server(s), %d group(s), %d warning(s)", path, len(file.Servers), len(file.Groups), len(issues))
+	if *resolve {
+		fmt.Printf(", %d unreachable", unreachable)
+	}
+	fmt.Println()
+
+	if unreachable > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Address is a parsed server address. Port is 0 when the address has none,
+// in which case the edition's default port applies.
+type Address struct {
+	Host string
+	Port uint16
+}
+
+// ParseAddress parses the address formats accepted in servers.json:
+//
+//	hostname, hostname:port
+//	1.2.3.4, 1.2.3.4:port
+//	[2001:db8::1], [2001:db8::1]:port
+//	2001:db8::1 (bare IPv6, no port)
+func ParseAddress(addr string) (Address, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return Address{}, fmt.Errorf("empty address")
+	}
+
+	// Bracketed IPv6, with or without a port.
+	if strings.HasPrefix(addr, "[") {
+		end := strings.IndexByte(addr, ']')
+		if end < 0 {
+			return Address{}, fmt.Errorf("invalid address %q: missing ']'", addr)
+		}
+		ip, err := netip.ParseAddr(addr[1:end])
+		if err != nil || !ip.Is6() {
+			return Address{}, fmt.Errorf("invalid address %q: %q is not an IPv6 address", addr, addr[1:end])
+		}
+
+		rest := addr[end+1:]
+		if rest == "" {
+			return Address{Host: ip.String()}, nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return Address{}, fmt.Errorf("invalid address %q: unexpected %q after ']'", addr, rest)
+		}
+		port, err := parsePort(rest[1:])
+		if err != nil {
+			return Address{}, fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+		return Address{Host: ip.String(), Port: port}, nil
+	}
+
+	// More than one colon without brackets can only be a bare IPv6 address.
+	if strings.Count(addr, ":") > 1 {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return Address{}, fmt.Errorf("invalid address %q: use [ipv6]:port for IPv6 addresses with a port", addr)
+		}
+		return Address{Host: ip.String()}, nil
+	}
+
+	host, portStr, hasPort := strings.Cut(addr, ":")
+	if err := validateHost(host); err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+
+	result := Address{Host: host}
+	if hasPort {
+		port, err := parsePort(portStr)
+		if err != nil {
+			return Address{}, fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+		result.Port = port
+	}
+	return result, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	p, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || p == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(p), nil
+}
+
+// validateHost checks an IPv4 literal or an RFC 1123 hostname.
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(host, ".")
+	if len(name) > 253 {
+		return fmt.Errorf("hostname too long")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid hostname %q", host)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid hostname %q", host)
+		}
+		for _, c := range label {
+			isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+			if !isAlnum && c != '-' && c != '_' {
+				return fmt.Errorf("invalid hostname %q", host)
+			}
+		}
+	}
+	return nil
+}
+
+// String formats the address back into host[:port], bracketing IPv6.
+func (a Address) String() string {
+	host := a.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if a.Port == 0 {
+		return host
+	}
+	return host + ":" + strconv.FormatUint(uint64(a.Port), 10)
+}
@@ -0,0 +1,55 @@
+package data
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    Address
+		str     string
+		wantErr bool
+	}{
+		{in: "play.example.com", want: Address{Host: "play.example.com"}, str: "play.example.com"},
+		{in: " play.example.com:25566 ", want: Address{Host: "play.example.com", Port: 25566}, str: "play.example.com:25566"},
+		{in: "mc_srv.example.com.", want: Address{Host: "mc_srv.example.com."}, str: "mc_srv.example.com."},
+		{in: "192.0.2.1", want: Address{Host: "192.0.2.1"}, str: "192.0.2.1"},
+		{in: "192.0.2.1:19132", want: Address{Host: "192.0.2.1", Port: 19132}, str: "192.0.2.1:19132"},
+		{in: "[2001:db8::1]", want: Address{Host: "2001:db8::1"}, str: "[2001:db8::1]"},
+		{in: "[2001:DB8:0::1]:25565", want: Address{Host: "2001:db8::1", Port: 25565}, str: "[2001:db8::1]:25565"},
+		{in: "2001:db8::1", want: Address{Host: "2001:db8::1"}, str: "[2001:db8::1]"},
+
+		{in: "", wantErr: true},
+		{in: "   ", wantErr: true},
+		{in: ":25565", wantErr: true},
+		{in: "example.com:0", wantErr: true},
+		{in: "example.com:65536", wantErr: true},
+		{in: "example.com:port", wantErr: true},
+		{in: "example..com", wantErr: true},
+		{in: "-example.com", wantErr: true},
+		{in: "exa mple.com", wantErr: true},
+		{in: "[2001:db8::1", wantErr: true},
+		{in: "[192.0.2.1]:25565", wantErr: true},
+		{in: "[2001:db8::1]25565", wantErr: true},
+		{in: "2001:db8::1:25565:x", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := ParseAddress(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseAddress(%q) = %+v, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAddress(%q): %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+		if s := got.String(); s != tc.str {
+			t.Errorf("ParseAddress(%q).String() = %q, want %q", tc.in, s, tc.str)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryAggregates are the per-window aggregates the history endpoints
+// accept in their agg parameter.
+var HistoryAggregates = []string{"mean", "min", "max", "median", "last", "p95"}
+
+// ParseAggregates parses a comma-separated agg parameter like "min,mean,max".
+// Duplicates are dropped and the order is kept; an empty parameter returns
+// nil.
+func ParseAggregates(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var aggs []string
+	for _, agg := range strings.Split(raw, ",") {
+		agg = strings.ToLower(strings.TrimSpace(agg))
+		if !slices.Contains(HistoryAggregates, agg) {
+			return nil, fmt.Errorf("%w: unknown aggregate %q, use one or more of %s", ErrInvalidQuery, agg, strings.Join(HistoryAggregates, ", "))
+		}
+		if !slices.Contains(aggs, agg) {
+			aggs = append(aggs, agg)
+		}
+	}
+	return aggs, nil
+}
+
+// aggregatedWindow holds every requested aggregate of one window.
+type aggregatedWindow struct {
+	Time   time.Time
+	Series string
+	Values map[string]float64
+}
+
+// queryWindowAggregates runs the raw window query built by build once per
+// aggregate and merges the results per series and window, ordered by
+// series, then time. Either every aggregate reads the same rollup tier or
+// all of them read the raw points, see withCommonRollupTier.
+func queryWindowAggregates(ctx context.Context, build func(fn string) (WindowQuery, error), aggs []string) ([]aggregatedWindow, error) {
+	type windowKey struct {
+		series string
+		time   int64
+	}
+
+	queries := make([]WindowQuery, len(aggs))
+	for i, agg := range aggs {
+		q, err := build(agg)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = q
+	}
+	queries = withCommonRollupTier(queries, time.Now())
+
+	index := make(map[windowKey]int)
+	var windows []aggregatedWindow
+
+	for i, agg := range aggs {
+		values, err := queryWindows(ctx, queries[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range values {
+			key := windowKey{series: v.Series, time: v.Time.UnixNano()}
+			i, ok := index[key]
+			if !ok {
+				i = len(windows)
+				index[key] = i
+				windows = append(windows, aggregatedWindow{
+					Time:   v.Time,
+					Series: v.Series,
+					Values: make(map[string]float64, len(aggs)),
+				})
+			}
+			windows[i].Values[agg] = v.Value
+		}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		if windows[i].Series != windows[j].Series {
+			return windows[i].Series < windows[j].Series
+		}
+		return windows[i].Time.Before(windows[j].Time)
+	})
+	return windows, nil
+}
+
+// newDataPoint converts an aggregated window of field into a data point.
+// PlayerCount or Latency carries the first of aggs; windows without it are
+// skipped rather than reported as 0. With explicit aggs, every aggregate is
+// also returned unrounded in Aggregates.
+func newDataPoint(id, field string, w aggregatedWindow, aggs []string, explicit bool) (ServerDataPoint, bool) {
+	main, ok := w.Values[aggs[0]]
+	if !ok {
+		return ServerDataPoint{}, false
+	}
+
+	dataPoint := ServerDataPoint{
+		ID:        id,
+		Timestamp: w.Time.Unix(),
+	}
+	if field == FieldLatency {
+		dataPoint.Latency = int(math.Round(main))
+	} else {
+		dataPoint.PlayerCount = int(math.Round(main))
+	}
+	if explicit {
+		dataPoint.Aggregates = w.Values
+	}
+	return dataPoint, true
+}
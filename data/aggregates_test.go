@@ -0,0 +1,153 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAggregates(t *testing.T) {
+	aggs, err := ParseAggregates(" min, MEAN,max,min ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(aggs, []string{"min", "mean", "max"}) {
+		t.Errorf("got %v", aggs)
+	}
+	if aggs, err := ParseAggregates(""); aggs != nil || err != nil {
+		t.Errorf("empty agg = %v, %v", aggs, err)
+	}
+	for _, bad := range []string{"sum", "p99", "mean,", "mean) |> drop()"} {
+		if _, err := ParseAggregates(bad); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: got %v, want ErrInvalidQuery", bad, err)
+		}
+	}
+}
+
+func TestQueryDataPointsAggregates(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	previous := TimeSeries
+	TimeSeries = store
+	defer func() { TimeSeries = previous }()
+	setCoverage(t, map[string]RollupCoverage{})
+
+	start := time.Now().Add(-30 * time.Minute)
+	for i := 1; i <= 20; i++ {
+		players := 10
+		if i == 7 {
+			players = 500 // a spike the mean hides
+		}
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": "a", "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": players + i},
+			Time:        start.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	points, _, err := QueryDataPoints("a", RelativeRange("-1h"), FieldPlayerCount, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) == 0 || points[0].Aggregates != nil {
+		t.Fatalf("default query = %+v, want plain means", points)
+	}
+
+	points, step, err := QueryDataPoints("a", RelativeRange("-1h"), FieldPlayerCount, []string{"max", "mean", "min", "median", "last", "p95"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merge the windows back to check the aggregates across them.
+	var maxSeen, minSeen float64 = 0, 1e9
+	total := 0
+	for _, p := range points {
+		if len(p.Aggregates) != 6 {
+			t.Fatalf("step %s: point without every aggregate: %+v", step, p)
+		}
+		if p.PlayerCount != int(p.Aggregates["max"]) {
+			t.Errorf("PlayerCount %d is not the first aggregate %v", p.PlayerCount, p.Aggregates["max"])
+		}
+		if p.Aggregates["min"] > p.Aggregates["median"] || p.Aggregates["median"] > p.Aggregates["p95"] || p.Aggregates["p95"] > p.Aggregates["max"] {
+			t.Errorf("aggregates out of order: %v", p.Aggregates)
+		}
+		maxSeen = max(maxSeen, p.Aggregates["max"])
+		minSeen = min(minSeen, p.Aggregates["min"])
+		total++
+	}
+	if maxSeen != 507 || minSeen != 11 {
+		t.Errorf("max %v min %v over %d windows, want the spike 507 and 11", maxSeen, minSeen, total)
+	}
+}
+
+func TestAggregateWindowP95(t *testing.T) {
+	if v := aggregateValues("p95", []float64{5, 1, 4, 2, 3}); v != 5 {
+		t.Errorf("p95 of 1..5 = %v, want 5", v)
+	}
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(100 - i)
+	}
+	if v := aggregateValues("p95", values); v != 95 {
+		t.Errorf("p95 of 1..100 = %v, want 95", v)
+	}
+
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+	query, _, _, err := BuildInfluxQueryFromParams(QueryParams{Start: "-1d", ServerFilter: "a", Aggregate: "p95"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, `quantile(q: 0.95, method: "exact_selector", column: column)`) {
+		t.Errorf("p95 query:\n%s", query)
+	}
+	lexFlux(t, query)
+
+	if _, _, _, err := BuildInfluxQueryFromParams(QueryParams{Start: "-1d", Aggregate: "spread"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("unknown aggregate: got %v, want ErrInvalidQuery", err)
+	}
+}
+
+func TestAggregatesShareOneSource(t *testing.T) {
+	now := time.Now()
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: now.Truncate(time.Hour)},
+	})
+
+	cases := []struct {
+		aggs     []string
+		wantTier string
+	}{
+		{[]string{"mean", "min", "max", "last"}, "1h"},
+		{[]string{"mean", "p95"}, ""}, // p95 has no rollup statistic
+		{[]string{"median"}, ""},
+	}
+	for _, tc := range cases {
+		queries := make([]WindowQuery, len(tc.aggs))
+		for i, agg := range tc.aggs {
+			queries[i] = rawServerRangeQuery(RelativeRange("-30d"), "2h", "a", FieldPlayerCount, agg)
+		}
+		for i, q := range withCommonRollupTier(queries, now) {
+			if q.Tier != tc.wantTier || q.Fn != tc.aggs[i] {
+				t.Errorf("%v: %s reads tier %q with %s, want %q", tc.aggs, tc.aggs[i], q.Tier, q.Fn, tc.wantTier)
+			}
+		}
+	}
+}
+
+func TestNewDataPointSkipsWindowsWithoutMainAggregate(t *testing.T) {
+	aggs := []string{"mean", "p95"}
+	w := aggregatedWindow{Time: time.Unix(60, 0), Series: "a", Values: map[string]float64{"p95": 12}}
+	if p, ok := newDataPoint("a", FieldPlayerCount, w, aggs, true); ok {
+		t.Errorf("window without a mean = %+v", p)
+	}
+
+	w.Values["mean"] = 9.6
+	p, ok := newDataPoint("a", FieldPlayerCount, w, aggs, true)
+	if !ok || p.PlayerCount != 10 || p.Timestamp != 60 || len(p.Aggregates) != 2 {
+		t.Errorf("window = %+v, %v", p, ok)
+	}
+}
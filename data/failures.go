@@ -0,0 +1,41 @@
+package data
+
+import (
+	"MineTracker/database"
+	"context"
+	"math"
+	"time"
+)
+
+// failureQuery counts ping_failure events per reason for one server.
+func failureQuery(start, id string) AggregateQuery {
+	return withRollupTierAggregate(AggregateQuery{
+		Measurement: "ping_failure",
+		Field:       "count",
+		Tag:         "id",
+		Value:       id,
+		Start:       start,
+		GroupBy:     "reason",
+		Fn:          "sum",
+	}, time.Now())
+}
+
+// BuildFailureQuery builds a Flux query that counts ping_failure events per
+// reason for one server over a relative time range like "-1d".
+func BuildFailureQuery(start, serverFilter string) (string, error) {
+	return buildAggregateFlux(database.GetInfluxBucket(), failureQuery(start, serverFilter))
+}
+
+// QueryFailureBreakdown returns the number of failed pings per reason.
+func QueryFailureBreakdown(id string, duration string) (map[string]int64, error) {
+	sums, err := queryAggregate(context.Background(), failureQuery(duration, id))
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := make(map[string]int64, len(sums))
+	for reason, n := range sums {
+		breakdown[reason] = int64(math.Round(n))
+	}
+	return breakdown, nil
+}
@@ -0,0 +1,396 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FluxQuery builds a Flux pipeline from typed parts. Values that may come
+// from a request (IDs, tags, field names, durations) only enter the query
+// through methods that validate them or write them as escaped string
+// literals, so they can not change the structure of the query. The first
+// invalid part is kept and returned by Build.
+type FluxQuery struct {
+	bucket   string
+	location string
+	imports  []string
+	stages   []string
+	err      error
+}
+
+// ErrInvalidQuery wraps every error caused by an invalid query part, so
+// handlers can answer 400 instead of 500.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Bounds checked by Range and AggregateWindow.
+const (
+	MaxQueryRange = 10 * 365 * 24 * time.Hour
+	MinQueryStep  = time.Second
+)
+
+var (
+	fluxDurationPattern   = regexp.MustCompile(`^(\d{1,9})(s|m|h|d|w|mo|M|y)$`)
+	fluxIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// fluxAggregates are the aggregate functions AggregateWindow and Aggregate
+// accept.
+var fluxAggregates = map[string]bool{
+	"mean": true, "median": true, "sum": true, "min": true,
+	"max": true, "count": true, "first": true, "last": true,
+}
+
+// fluxWindowFunctions are the functions AggregateWindow accepts besides
+// fluxAggregates. Quantiles use the exact_selector method, so they are
+// always one of the aggregated values.
+var fluxWindowFunctions = map[string]string{
+	"p95": `(column, tables=<-) => tables |> quantile(q: 0.95, method: "exact_selector", column: column)`,
+}
+
+// isWindowFunction reports whether AggregateWindow accepts fn.
+func isWindowFunction(fn string) bool {
+	_, ok := fluxWindowFunctions[fn]
+	return ok || fluxAggregates[fn]
+}
+
+// FluxDuration is a validated, positive Flux duration literal like "4m".
+type FluxDuration struct {
+	value int64
+	unit  string
+}
+
+// ParseFluxDuration parses durations in the API format: an integer followed
+// by s, m, h, d, w, M (or mo) or y. A leading "-" is ignored, ranges
+// always point into the past.
+func ParseFluxDuration(s string) (FluxDuration, error) {
+	m := fluxDurationPattern.FindStringSubmatch(strings.TrimPrefix(s, "-"))
+	if m == nil {
+		return FluxDuration{}, fmt.Errorf("invalid duration %q", s)
+	}
+	value, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || value <= 0 {
+		return FluxDuration{}, fmt.Errorf("invalid duration %q", s)
+	}
+	unit := m[2]
+	if unit == "M" {
+		unit = "mo"
+	}
+	return FluxDuration{value: value, unit: unit}, nil
+}
+
+// Approx returns the duration with months as 30 and years as 365 days. Use
+// Before or After for calendar months and years.
+func (d FluxDuration) Approx() time.Duration {
+	units := map[string]time.Duration{
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"mo": 30 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+	return time.Duration(d.value) * units[d.unit]
+}
+
+func (d FluxDuration) String() string {
+	return strconv.FormatInt(d.value, 10) + d.unit
+}
+
+// QuoteFlux returns s as a Flux string literal. Backslashes, quotes and the
+// "${" that would start string interpolation are escaped.
+func QuoteFlux(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '$':
+			if strings.HasPrefix(s[i+1:], "{") {
+				b.WriteByte('\\')
+			}
+			b.WriteByte('$')
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// column returns a row accessor like r["id"] for a column name.
+func column(name string) string {
+	return "r[" + QuoteFlux(name) + "]"
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = QuoteFlux(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// NewFluxQuery starts a query on bucket.
+func NewFluxQuery(bucket string) *FluxQuery {
+	q := &FluxQuery{bucket: bucket}
+	if bucket == "" {
+		q.fail(fmt.Errorf("missing bucket"))
+	}
+	return q
+}
+
+// use imports a Flux package for the query.
+func (q *FluxQuery) use(pkg string) {
+	q.imports = append(q.imports, pkg)
+}
+
+func (q *FluxQuery) fail(err error) *FluxQuery {
+	if q.err == nil {
+		q.err = fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return q
+}
+
+func (q *FluxQuery) pipe(stage string) *FluxQuery {
+	q.stages = append(q.stages, stage)
+	return q
+}
+
+// Range limits the query to a relative range like "-1d", at most
+// MaxQueryRange long.
+func (q *FluxQuery) Range(start string) *FluxQuery {
+	d, err := ParseFluxDuration(start)
+	if err != nil {
+		return q.fail(fmt.Errorf("invalid range: %w", err))
+	}
+	if d.Approx() > MaxQueryRange {
+		return q.fail(fmt.Errorf("range %s is longer than %s", d, MaxQueryRange))
+	}
+	return q.pipe("range(start: -" + d.String() + ")")
+}
+
+// RangeSince limits the query to everything from t on.
+func (q *FluxQuery) RangeSince(t time.Time) *FluxQuery {
+	return q.pipe("range(start: " + t.UTC().Format(time.RFC3339Nano) + ")")
+}
+
+// RangeBetween limits the query to [from, to).
+func (q *FluxQuery) RangeBetween(from, to time.Time) *FluxQuery {
+	if !from.Before(to) {
+		return q.fail(fmt.Errorf("empty range %s to %s", from, to))
+	}
+	return q.pipe("range(start: " + from.UTC().Format(time.RFC3339Nano) + ", stop: " + to.UTC().Format(time.RFC3339Nano) + ")")
+}
+
+// Location aligns windows to the local time of an IANA zone like
+// "Europe/Berlin", so daily windows start at local midnight. An empty name
+// keeps UTC.
+func (q *FluxQuery) Location(name string) *FluxQuery {
+	q.location = name
+	return q
+}
+
+// Measurement keeps rows of one measurement.
+func (q *FluxQuery) Measurement(names ...string) *FluxQuery {
+	return q.ColumnIn("_measurement", names)
+}
+
+// Field keeps rows of one field.
+func (q *FluxQuery) Field(name string) *FluxQuery {
+	return q.ColumnIn("_field", []string{name})
+}
+
+// Where keeps rows whose column equals value.
+func (q *FluxQuery) Where(name, value string) *FluxQuery {
+	return q.ColumnIn(name, []string{value})
+}
+
+// WhereFold keeps rows whose column equals value, ignoring case.
+func (q *FluxQuery) WhereFold(name, value string) *FluxQuery {
+	q.use("strings")
+	return q.pipe("filter(fn: (r) => exists " + column(name) + " and strings.toLower(v: " + column(name) + ") == " + QuoteFlux(strings.ToLower(value)) + ")")
+}
+
+// ColumnIn keeps rows whose column equals any of values.
+func (q *FluxQuery) ColumnIn(name string, values []string) *FluxQuery {
+	if len(values) == 0 {
+		return q.fail(fmt.Errorf("no values to filter %s on", name))
+	}
+	conds := make([]string, len(values))
+	for i, v := range values {
+		conds[i] = column(name) + " == " + QuoteFlux(v)
+	}
+	return q.pipe("filter(fn: (r) => " + strings.Join(conds, " or ") + ")")
+}
+
+// Missing keeps rows without the column, e.g. points written before a tag
+// existed.
+func (q *FluxQuery) Missing(name string) *FluxQuery {
+	return q.pipe("filter(fn: (r) => not exists " + column(name) + ")")
+}
+
+// AggregateWindow aggregates every series into windows of every with fn,
+// leaving out empty windows. Windows are stamped with their stop. Weekly
+// windows start on Monday instead of Flux's Thursday.
+func (q *FluxQuery) AggregateWindow(every string, fn string) *FluxQuery {
+	return q.aggregateWindow(every, fn, "_stop")
+}
+
+// RollupWindow is AggregateWindow with windows stamped with their start.
+func (q *FluxQuery) RollupWindow(every string, fn string) *FluxQuery {
+	return q.aggregateWindow(every, fn, "_start")
+}
+
+// weightedMeanWindow sums the weighted values and weights of a window and
+// divides them.
+const weightedMeanWindow = `(column, tables=<-) => tables
+      |> reduce(identity: {sum: 0.0, weight: 0.0}, fn: (r, accumulator) => ({sum: accumulator.sum + r._value * r._weight, weight: accumulator.weight + r._weight}))
+      |> map(fn: (r) => ({r with _value: r.sum / r.weight}))`
+
+// WeightedMeanWindow is AggregateWindow with fn mean for rows holding a mean
+// in field and the number of values behind it in weight, like rollups. Each
+// window is sum(field * weight) / sum(weight).
+func (q *FluxQuery) WeightedMeanWindow(every, field, weight string) *FluxQuery {
+	q.pipe(`pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`)
+	q.pipe("map(fn: (r) => ({r with _value: float(v: " + column(field) + "), _weight: float(v: " + column(weight) + ")}))")
+	return q.window(every, weightedMeanWindow, "_stop")
+}
+
+func (q *FluxQuery) aggregateWindow(every, fn, timeSrc string) *FluxQuery {
+	if !isWindowFunction(fn) {
+		return q.fail(fmt.Errorf("unsupported aggregate %q", fn))
+	}
+	if expr, ok := fluxWindowFunctions[fn]; ok {
+		fn = expr
+	}
+	return q.window(every, fn, timeSrc)
+}
+
+// window aggregates into windows of every with the Flux function expr.
+func (q *FluxQuery) window(every, expr, timeSrc string) *FluxQuery {
+	d, err := ParseFluxDuration(every)
+	if err != nil {
+		return q.fail(fmt.Errorf("invalid step: %w", err))
+	}
+	if d.Approx() < MinQueryStep || d.Approx() > MaxQueryRange {
+		return q.fail(fmt.Errorf("step %s out of range", d))
+	}
+	window := "aggregateWindow(every: " + d.String() + ", fn: " + expr
+	if d.unit == "w" {
+		// The epoch is a Thursday.
+		window += ", offset: 4d"
+	}
+	if timeSrc != "_stop" {
+		window += ", timeSrc: " + QuoteFlux(timeSrc)
+	}
+	return q.pipe(window + ", createEmpty: false)")
+}
+
+// Aggregate applies an aggregate function to every table, e.g. sum().
+func (q *FluxQuery) Aggregate(fn string) *FluxQuery {
+	if !fluxAggregates[fn] {
+		return q.fail(fmt.Errorf("unsupported aggregate %q", fn))
+	}
+	return q.pipe(fn + "()")
+}
+
+// Group regroups the rows by columns; no columns merges all tables.
+func (q *FluxQuery) Group(columns ...string) *FluxQuery {
+	if len(columns) == 0 {
+		return q.pipe("group()")
+	}
+	return q.pipe("group(columns: " + quoteList(columns) + ")")
+}
+
+// Sort sorts rows by columns.
+func (q *FluxQuery) Sort(columns ...string) *FluxQuery {
+	return q.pipe("sort(columns: " + quoteList(columns) + ")")
+}
+
+// Drop removes columns.
+func (q *FluxQuery) Drop(columns ...string) *FluxQuery {
+	return q.pipe("drop(columns: " + quoteList(columns) + ")")
+}
+
+// Keep removes every column but columns.
+func (q *FluxQuery) Keep(columns ...string) *FluxQuery {
+	return q.pipe("keep(columns: " + quoteList(columns) + ")")
+}
+
+// Distinct returns the distinct values of a column of every table as _value.
+func (q *FluxQuery) Distinct(name string) *FluxQuery {
+	return q.pipe("distinct(column: " + QuoteFlux(name) + ")")
+}
+
+// Set sets a column to a constant string.
+func (q *FluxQuery) Set(key, value string) *FluxQuery {
+	return q.pipe("set(key: " + QuoteFlux(key) + ", value: " + QuoteFlux(value) + ")")
+}
+
+// SuffixField appends suffix to the _field of every row.
+func (q *FluxQuery) SuffixField(suffix string) *FluxQuery {
+	return q.pipe(`map(fn: (r) => ({r with _field: r._field + ` + QuoteFlux(suffix) + `}))`)
+}
+
+// To writes the rows to a bucket.
+func (q *FluxQuery) To(bucket, org string) *FluxQuery {
+	return q.pipe("to(bucket: " + QuoteFlux(bucket) + ", org: " + QuoteFlux(org) + ")")
+}
+
+// Yield names the result.
+func (q *FluxQuery) Yield(name string) *FluxQuery {
+	if !fluxIdentifierPattern.MatchString(name) {
+		return q.fail(fmt.Errorf("invalid result name %q", name))
+	}
+	return q.pipe("yield(name: " + QuoteFlux(name) + ")")
+}
+
+// Build returns the query, or the first error of the parts it was built
+// from. A query needs a range.
+func (q *FluxQuery) Build() (string, error) {
+	if q.err != nil {
+		return "", q.err
+	}
+	if len(q.stages) == 0 || !strings.HasPrefix(q.stages[0], "range(") {
+		return "", fmt.Errorf("%w: query needs a range first", ErrInvalidQuery)
+	}
+
+	imports := slices.Clone(q.imports)
+	if q.location != "" {
+		imports = append(imports, "timezone")
+	}
+	slices.Sort(imports)
+
+	var b strings.Builder
+	for _, pkg := range slices.Compact(imports) {
+		b.WriteString("import " + QuoteFlux(pkg) + "\n")
+	}
+	if len(imports) > 0 {
+		b.WriteString("\n")
+	}
+	if q.location != "" {
+		b.WriteString("option location = timezone.location(name: " + QuoteFlux(q.location) + ")\n\n")
+	}
+	b.WriteString("from(bucket: " + QuoteFlux(q.bucket) + ")")
+	for _, stage := range q.stages {
+		b.WriteString("\n  |> ")
+		b.WriteString(stage)
+	}
+	return b.String(), nil
+}
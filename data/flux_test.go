@@ -0,0 +1,356 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hostileInputs try to break out of a string literal, start string
+// interpolation or comment out the rest of the query.
+var hostileInputs = []string{
+	`x" or r["_measurement"] != "`,
+	`x") |> drop(columns: ["_value"]) //`,
+	`x")
+  |> yield(name: "leak")
+//`,
+	`${string(v: 1)}`,
+	`\${x}`,
+	`a\`,
+	`a\"`,
+	`"`,
+	`$`,
+	`$$`,
+	"tab\tand\rreturn",
+	`r["id"] == "other"`,
+	"ünïcödé ✓",
+	"",
+}
+
+// lexFlux splits a query into its code, with every string literal replaced
+// by "_", and the decoded literals. It fails on unterminated literals,
+// unknown escapes and unescaped interpolation.
+func lexFlux(t *testing.T, query string) (string, []string) {
+	t.Helper()
+
+	var code strings.Builder
+	var literals []string
+	for i := 0; i < len(query); i++ {
+		if query[i] != '"' {
+			code.WriteByte(query[i])
+			continue
+		}
+
+		var lit strings.Builder
+		i++
+		for ; ; i++ {
+			if i >= len(query) {
+				t.Fatalf("unterminated string literal in\n%s", query)
+			}
+			c := query[i]
+			if c == '"' {
+				break
+			}
+			if c == '$' && i+1 < len(query) && query[i+1] == '{' {
+				t.Fatalf("unescaped interpolation in\n%s", query)
+			}
+			if c != '\\' {
+				lit.WriteByte(c)
+				continue
+			}
+			i++
+			if i >= len(query) {
+				t.Fatalf("dangling escape in\n%s", query)
+			}
+			switch query[i] {
+			case '\\', '"', '$':
+				lit.WriteByte(query[i])
+			case 'n':
+				lit.WriteByte('\n')
+			case 'r':
+				lit.WriteByte('\r')
+			case 't':
+				lit.WriteByte('\t')
+			default:
+				t.Fatalf("unknown escape \\%c in\n%s", query[i], query)
+			}
+		}
+		code.WriteString(`"_"`)
+		literals = append(literals, lit.String())
+	}
+	return code.String(), literals
+}
+
+func TestQuoteFluxRoundTrip(t *testing.T) {
+	for _, in := range hostileInputs {
+		code, literals := lexFlux(t, QuoteFlux(in))
+		if code != `"_"` || len(literals) != 1 || literals[0] != in {
+			t.Errorf("QuoteFlux(%q) = %s, decodes to %q", in, QuoteFlux(in), literals)
+		}
+	}
+}
+
+// assertSameStructure checks that a query built from a hostile value has the
+// same code as the baseline and carries the value as one intact literal.
+func assertSameStructure(t *testing.T, baseline, query, value string) {
+	t.Helper()
+
+	baseCode, _ := lexFlux(t, baseline)
+	code, literals := lexFlux(t, query)
+	if code != baseCode {
+		t.Fatalf("query structure changed for %q:\n%s\nwant:\n%s", value, query, baseline)
+	}
+	for _, lit := range literals {
+		if lit == value {
+			return
+		}
+	}
+	t.Fatalf("value %q not found as a literal in\n%s", value, query)
+}
+
+func TestServerFilterCannotChangeQuery(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+
+	baseline, err := BuildInfluxQueryForField("-1d", "4m", "benign", FieldPlayerCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range hostileInputs {
+		if in == "" {
+			continue // no filter at all
+		}
+		query, err := BuildInfluxQueryForField("-1d", "4m", in, FieldPlayerCount)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
+
+func TestGroupMembersCannotChangeQuery(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+
+	baseline, err := BuildGroupInfluxQuery("-7d", "30m", []string{"a", "b"}, FieldPlayerCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range hostileInputs {
+		query, err := BuildGroupInfluxQuery("-7d", "30m", []string{"a", in}, FieldPlayerCount)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
+
+func TestFailureFilterCannotChangeQuery(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+
+	baseline, err := BuildFailureQuery("-1d", "benign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range hostileInputs {
+		query, err := BuildFailureQuery("-1d", in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
+
+func TestBucketComesFromConfig(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "")
+	query, err := BuildInfluxQuery("-1h", "1m", "hypixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(query, `from(bucket: "minetracker_data")`) {
+		t.Errorf("default bucket not used:\n%s", query)
+	}
+
+	t.Setenv("INFLUXDB_BUCKET", "custom")
+	baseline, err := BuildInfluxQuery("-1h", "1m", "hypixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(baseline, `from(bucket: "custom")`) {
+		t.Errorf("INFLUXDB_BUCKET not used:\n%s", baseline)
+	}
+
+	for _, in := range hostileInputs {
+		if in == "" {
+			continue // falls back to the default bucket
+		}
+		t.Setenv("INFLUXDB_BUCKET", in)
+		query, err := BuildInfluxQuery("-1h", "1m", "hypixel")
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
+
+func TestRejectsInvalidRanges(t *testing.T) {
+	bad := []string{
+		"",
+		"-",
+		"1d) |> drop(columns: [\"_value\"]",
+		"1d\n  |> yield()",
+		"-Infd",
+		"NaNh",
+		"1.5h",
+		"1e3d",
+		"0d",
+		"-0s",
+		"--1d",
+		"+1d",
+		"1",
+		"d",
+		"1x",
+		"1 d",
+		"11y",
+		"9999999999d",
+		"1d${x}",
+	}
+	for _, start := range bad {
+		_, err := BuildInfluxQueryForField(start, "1m", "id", FieldPlayerCount)
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("range %q: got %v, want ErrInvalidQuery", start, err)
+		}
+		_, _, _, err = BuildInfluxQueryFromParams(QueryParams{Start: start})
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("params range %q: got %v, want ErrInvalidQuery", start, err)
+		}
+		_, err = BuildFailureQuery(start, "id")
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("failure range %q: got %v, want ErrInvalidQuery", start, err)
+		}
+	}
+}
+
+func TestRejectsInvalidSteps(t *testing.T) {
+	bad := []string{
+		"",
+		"0s",
+		"1m, fn: sum",
+		"1m) |> drop(columns: [\"_value\"]",
+		"1.5m",
+		"-",
+		"1ms",
+		"11y",
+	}
+	for _, step := range bad {
+		_, err := BuildInfluxQueryForField("-1d", step, "id", FieldPlayerCount)
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("step %q: got %v, want ErrInvalidQuery", step, err)
+		}
+		if step == "" {
+			continue // QueryParams calculates a missing step
+		}
+		_, _, _, err = BuildInfluxQueryFromParams(QueryParams{Start: "-1d", Step: step})
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("params step %q: got %v, want ErrInvalidQuery", step, err)
+		}
+	}
+}
+
+func TestAcceptsValidRangesAndSteps(t *testing.T) {
+	cases := []struct {
+		start, step string
+		wantRange   string
+		wantEvery   string
+	}{
+		{"-1h", "10s", "range(start: -1h)", "every: 10s"},
+		{"1d", "4m", "range(start: -1d)", "every: 4m"},
+		{"-3M", "1d", "range(start: -3mo)", "every: 1d"},
+		{"-1y", "1M", "range(start: -1y)", "every: 1mo"},
+		{"-2w", "1h", "range(start: -2w)", "every: 1h"},
+	}
+	for _, tc := range cases {
+		query, err := BuildInfluxQueryForField(tc.start, tc.step, "id", FieldLatency)
+		if err != nil {
+			t.Errorf("%s/%s: %v", tc.start, tc.step, err)
+			continue
+		}
+		if !strings.Contains(query, tc.wantRange) || !strings.Contains(query, tc.wantEvery) {
+			t.Errorf("%s/%s: unexpected query\n%s", tc.start, tc.step, query)
+		}
+	}
+}
+
+func TestRejectsUnknownFields(t *testing.T) {
+	for _, field := range []string{"", "_value", `player_count") |> drop(columns: ["_value"]`, "dns_time"} {
+		if _, err := BuildInfluxQueryForField("-1d", "1m", "id", field); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("field %q: got %v, want ErrInvalidQuery", field, err)
+		}
+	}
+}
+
+func TestBuilderRejectsUnsafeParts(t *testing.T) {
+	cases := map[string]*FluxQuery{
+		"aggregate":        NewFluxQuery("b").Range("-1d").Aggregate("sum() |> drop(columns: [\"x\"])"),
+		"window aggregate": NewFluxQuery("b").Range("-1d").AggregateWindow("1m", "mean, createEmpty: true"),
+		"yield name":       NewFluxQuery("b").Range("-1d").Yield(`x") |> drop(columns: ["y"]`),
+		"no values":        NewFluxQuery("b").Range("-1d").ColumnIn("id", nil),
+		"no range":         NewFluxQuery("b").Measurement("server_data"),
+		"no bucket":        NewFluxQuery("").Range("-1d"),
+	}
+	for name, q := range cases {
+		if _, err := q.Build(); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: got %v, want ErrInvalidQuery", name, err)
+		}
+	}
+}
+
+func TestColumnNamesAreQuoted(t *testing.T) {
+	baseline, err := NewFluxQuery("b").Range("-1d").Where("id", "v").Group("reason").Drop("ip").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range hostileInputs {
+		query, err := NewFluxQuery("b").Range("-1d").Where(in, "v").Group(in).Drop(in).Build()
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
+
+func TestWhereFoldImportsStrings(t *testing.T) {
+	baseline, err := NewFluxQuery("b").RangeSince(time.Unix(0, 0)).WhereFold("ip", "v").Keep("ip").Group().Distinct("ip").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"import \"strings\"\n\nfrom(",
+		`filter(fn: (r) => exists r["ip"] and strings.toLower(v: r["ip"]) == "v")`,
+		`keep(columns: ["ip"])`,
+		`distinct(column: "ip")`,
+	} {
+		if !strings.Contains(baseline, want) {
+			t.Errorf("query lacks %s:\n%s", want, baseline)
+		}
+	}
+	if q, _ := NewFluxQuery("b").Range("-1d").WhereFold("ip", "MC.Example.com").Build(); !strings.Contains(q, `== "mc.example.com"`) {
+		t.Errorf("value not lowered:\n%s", q)
+	}
+
+	// Packages are imported once, before the location option.
+	q, err := NewFluxQuery("b").Location("Europe/Berlin").Range("-1d").WhereFold("ip", "a").WhereFold("name", "b").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(q, "import \"strings\"\nimport \"timezone\"\n\noption location = ") {
+		t.Errorf("imports:\n%s", q)
+	}
+
+	for _, in := range hostileInputs {
+		query, err := NewFluxQuery("b").RangeSince(time.Unix(0, 0)).WhereFold("ip", in).Keep("ip").Group().Distinct("ip").Build()
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ServerGroup combines several tracked servers, e.g. the public hostnames of
+// one network, whose player counts are reported together.
+type ServerGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Members are server IDs. servers.json and the admin API also accept
+	// addresses, which are resolved to IDs when the group is stored.
+	Members []string `json:"members"`
+
+	// Source is ServerSourceFile or ServerSourceAPI, as for servers.
+	Source string `json:"source,omitempty"`
+}
+
+// Validate checks a group definition before it is stored.
+func (g ServerGroup) Validate() error {
+	if err := ValidateServerID(g.ID); err != nil {
+		return err
+	}
+	if strings.TrimSpace(g.Name) == "" {
+		return fmt.Errorf("missing name")
+	}
+	if len(g.Members) == 0 {
+		return fmt.Errorf("a group needs at least one member")
+	}
+	seen := make(map[string]bool, len(g.Members))
+	for _, m := range g.Members {
+		if strings.TrimSpace(m) == "" {
+			return fmt.Errorf("empty member")
+		}
+		if seen[m] {
+			return fmt.Errorf("duplicate member %q", m)
+		}
+		seen[m] = true
+	}
+	return nil
+}
+
+// QueryGroupDataPoints returns the combined windowed history of field for the
+// members of a group, see BuildGroupInfluxQuery. Each of aggs is applied per
+// member and window before the members are combined; see QueryDataPoints
+// for how aggs end up in the points. The points carry the group ID.
+func QueryGroupDataPoints(groupID string, memberIDs []string, r HistoryRange, field string, aggs []string) ([]ServerDataPoint, string, error) {
+	if field == "" {
+		field = FieldPlayerCount
+	}
+
+	step, _, err := ResolveStep(QueryParams{
+		Start:         r.Start,
+		From:          r.From,
+		To:            r.To,
+		Location:      r.Location,
+		MaxDataPoints: 500,
+		MinDataPoints: 10,
+		UseAdaptive:   false,
+	})
+	if err != nil {
+		return nil, "0m", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	explicit := len(aggs) > 0
+	if !explicit {
+		aggs = []string{"mean"}
+	}
+
+	windows, err := queryWindowAggregates(context.Background(), func(fn string) (WindowQuery, error) {
+		q, err := rawGroupRangeQuery(r, step, memberIDs, field, fn)
+		if err != nil {
+			return WindowQuery{}, fmt.Errorf("failed to build query: %w", err)
+		}
+		return q, nil
+	}, aggs)
+	if err != nil {
+		return nil, "0m", err
+	}
+
+	var dataPoints []ServerDataPoint
+	for _, w := range windows {
+		if p, ok := newDataPoint(groupID, field, w, aggs, explicit); ok {
+			dataPoints = append(dataPoints, p)
+		}
+	}
+
+	return dataPoints, step, nil
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryGroupDataPoints(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	previous := TimeSeries
+	TimeSeries = store
+	defer func() { TimeSeries = previous }()
+	setCoverage(t, map[string]RollupCoverage{})
+
+	// Five hours resolve to one minute windows.
+	to := time.Now().UTC().Truncate(time.Hour)
+	from := to.Add(-5 * time.Hour)
+	write := func(id string, at time.Duration, players int, latency float64) {
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": id, "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": players, "latency": latency},
+			Time:        from.Add(at),
+		})
+	}
+	// a and c are pinged at different seconds of the same minute, then in
+	// different minutes; b has no points at all.
+	write("a", 10*time.Minute+5*time.Second, 10, 20)
+	write("c", 10*time.Minute+40*time.Second, 30, 60)
+	write("a", 20*time.Minute+15*time.Second, 12, 30)
+	write("c", 21*time.Minute+50*time.Second, 7, 90)
+	write("z", 10*time.Minute, 1000, 1) // not a member
+
+	r := HistoryRange{From: from, To: to}
+	members := []string{"a", "b", "c"}
+
+	cases := []struct {
+		field string
+		want  map[time.Duration]int // window end after from: value
+	}{
+		// Player counts are summed over the members reporting a window.
+		{FieldPlayerCount, map[time.Duration]int{11 * time.Minute: 40, 21 * time.Minute: 12, 22 * time.Minute: 7}},
+		// Latencies are averaged instead.
+		{FieldLatency, map[time.Duration]int{11 * time.Minute: 40, 21 * time.Minute: 30, 22 * time.Minute: 90}},
+	}
+
+	for _, tc := range cases {
+		points, step, err := QueryGroupDataPoints("network", members, r, tc.field, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.field, err)
+		}
+		if step != "1m" {
+			t.Fatalf("%s: step %s, want 1m", tc.field, step)
+		}
+		if len(points) != len(tc.want) {
+			t.Fatalf("%s: got %d points, want %d: %+v", tc.field, len(points), len(tc.want), points)
+		}
+		for _, p := range points {
+			at := time.Unix(p.Timestamp, 0).Sub(from)
+			want, ok := tc.want[at]
+			got := p.PlayerCount
+			if tc.field == FieldLatency {
+				got = p.Latency
+			}
+			if !ok || got != want || p.ID != "network" {
+				t.Errorf("%s: point at +%s = %+v, want %d for network", tc.field, at, p, want)
+			}
+		}
+	}
+
+	// A group whose only member has no points has no history.
+	points, _, err := QueryGroupDataPoints("empty", []string{"b"}, r, FieldPlayerCount, nil)
+	if err != nil || len(points) != 0 {
+		t.Errorf("group without points = %+v, %v", points, err)
+	}
+}
@@ -0,0 +1,268 @@
+package data
+
+import (
+	"MineTracker/database"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// influxStore is the TimeSeriesStore backed by InfluxDB. Writes go through
+// the client's asynchronous, batching write API. Every rollup tier lives in
+// its own bucket, "<bucket>_<tier>", so it can have its own retention.
+type influxStore struct {
+	writeAPI api.WriteAPI
+}
+
+func newInfluxStore() *influxStore {
+	return &influxStore{
+		writeAPI: database.InfluxClient.WriteAPI(database.GetInfluxOrg(), database.GetInfluxBucket()),
+	}
+}
+
+func (s *influxStore) Write(p SeriesPoint) {
+	s.writeAPI.WritePoint(write.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time))
+}
+
+func (s *influxStore) Close() error {
+	s.writeAPI.Flush()
+	return nil
+}
+
+// influxTierBucket returns the bucket of a rollup tier, or bucket itself for
+// the raw points.
+func influxTierBucket(bucket, tier string) string {
+	if tier == "" {
+		return bucket
+	}
+	return bucket + "_" + tier
+}
+
+// buildWindowFlux turns a WindowQuery into Flux.
+func buildWindowFlux(bucket string, q WindowQuery) (string, error) {
+	fq := NewFluxQuery(influxTierBucket(bucket, q.Tier)).
+		Location(locationName(q.Location))
+	if q.From.IsZero() {
+		fq.Range(q.Start)
+	} else {
+		fq.RangeBetween(q.From, q.To)
+	}
+	fq.Measurement(q.Measurement)
+	if q.Weight == "" {
+		fq.Field(q.Field)
+	} else {
+		fq.ColumnIn("_field", []string{q.Field, q.Weight})
+	}
+
+	if len(q.Values) > 0 {
+		fq.ColumnIn(q.Tag, q.Values)
+	}
+
+	// createEmpty: false ensures only windows with actual data are returned
+	// This is crucial for handling sparse data scenarios
+	if q.Weight == "" {
+		fq.AggregateWindow(q.Step, q.Fn)
+	} else if q.Fn != "mean" {
+		fq.fail(fmt.Errorf("only means can be weighted, not %q", q.Fn))
+	} else {
+		fq.WeightedMeanWindow(q.Step, q.Field, q.Weight)
+	}
+
+	if q.Combine == "" {
+		return fq.Yield(q.Fn).Build()
+	}
+	return fq.Group("_time").
+		Aggregate(q.Combine).
+		Group().
+		Sort("_time").
+		Yield(q.Combine).
+		Build()
+}
+
+// buildAggregateFlux turns an AggregateQuery into Flux.
+func buildAggregateFlux(bucket string, q AggregateQuery) (string, error) {
+	fq := NewFluxQuery(influxTierBucket(bucket, q.Tier))
+	if q.From.IsZero() {
+		fq.Range(q.Start)
+	} else {
+		fq.RangeBetween(q.From, q.To)
+	}
+	return fq.Measurement(q.Measurement).
+		Field(q.Field).
+		Where(q.Tag, q.Value).
+		Group(q.GroupBy).
+		Aggregate(q.Fn).
+		Build()
+}
+
+// recordFloat reads a numeric record value.
+func recordFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func (s *influxStore) QueryWindows(ctx context.Context, q WindowQuery) ([]WindowValue, error) {
+	query, err := buildWindowFlux(database.GetInfluxBucket(), q)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.InfluxClient.QueryAPI(database.GetInfluxOrg()).Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	defer result.Close()
+
+	var values []WindowValue
+	for result.Next() {
+		record := result.Record()
+		if record == nil {
+			continue
+		}
+		value, ok := recordFloat(record.Value())
+		if !ok {
+			continue
+		}
+
+		v := WindowValue{Time: record.Time(), Value: value}
+		if q.Combine == "" {
+			v.Series, _ = record.ValueByKey(q.Tag).(string)
+		}
+		values = append(values, v)
+	}
+
+	if result.Err() != nil {
+		return nil, fmt.Errorf("result error: %w", result.Err())
+	}
+	return values, nil
+}
+
+func (s *influxStore) QueryAggregate(ctx context.Context, q AggregateQuery) (map[string]float64, error) {
+	query, err := buildAggregateFlux(database.GetInfluxBucket(), q)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.InfluxClient.QueryAPI(database.GetInfluxOrg()).Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	defer result.Close()
+
+	aggregates := make(map[string]float64)
+	for result.Next() {
+		record := result.Record()
+		key, _ := record.ValueByKey(q.GroupBy).(string)
+		if value, ok := recordFloat(record.Value()); ok {
+			aggregates[key] += value
+		}
+	}
+
+	if result.Err() != nil {
+		return nil, fmt.Errorf("result error: %w", result.Err())
+	}
+	return aggregates, nil
+}
+
+// buildRollupFlux builds the query that writes stat of every rolled up field
+// of measurement in [from, to) to the bucket of tier.
+func buildRollupFlux(bucket, org string, tier RollupTier, measurement, stat string, from, to time.Time) (string, error) {
+	return NewFluxQuery(bucket).
+		RangeBetween(from, to).
+		Measurement(measurement).
+		ColumnIn("_field", rollupFields[measurement]).
+		RollupWindow(tier.Name, stat).
+		SuffixField("_"+stat).
+		To(influxTierBucket(bucket, tier.Name), org).
+		Build()
+}
+
+// Rollup runs one query per measurement and statistic. InfluxDB replaces
+// points with the same series and time, so rolling up a window again
+// overwrites it.
+func (s *influxStore) Rollup(ctx context.Context, tier RollupTier, from, to time.Time) error {
+	queryAPI := database.InfluxClient.QueryAPI(database.GetInfluxOrg())
+
+	for measurement := range rollupFields {
+		for _, stat := range rollupStats {
+			query, err := buildRollupFlux(database.GetInfluxBucket(), database.GetInfluxOrg(), tier, measurement, stat, from, to)
+			if err != nil {
+				return err
+			}
+
+			result, err := queryAPI.Query(ctx, query)
+			if err != nil {
+				return fmt.Errorf("rollup %s %s of %s failed: %w", tier.Name, stat, measurement, err)
+			}
+			for result.Next() {
+			}
+			err = result.Err()
+			result.Close()
+			if err != nil {
+				return fmt.Errorf("rollup %s %s of %s failed: %w", tier.Name, stat, measurement, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyRetention creates missing tier buckets and sets the retention of the
+// tier buckets, and of the raw bucket if RETENTION_RAW is set. InfluxDB then
+// expires old points itself.
+func (s *influxStore) ApplyRetention(ctx context.Context) error {
+	org, err := database.InfluxClient.OrganizationsAPI().FindOrganizationByName(ctx, database.GetInfluxOrg())
+	if err != nil {
+		return fmt.Errorf("failed to find organization: %w", err)
+	}
+
+	bucket := database.GetInfluxBucket()
+	if manageRawRetention {
+		if err := ensureInfluxBucket(ctx, org, bucket, rawRetention); err != nil {
+			return err
+		}
+	}
+	for _, tier := range RollupTiers {
+		if err := ensureInfluxBucket(ctx, org, influxTierBucket(bucket, tier.Name), tier.Retention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureInfluxBucket creates the bucket name or updates its retention.
+func ensureInfluxBucket(ctx context.Context, org *domain.Organization, name string, retention time.Duration) error {
+	expire := domain.RetentionRuleTypeExpire
+	rule := domain.RetentionRule{EverySeconds: int64(retention / time.Second), Type: &expire}
+
+	buckets, err := database.InfluxClient.APIClient().GetBuckets(ctx, &domain.GetBucketsParams{Name: &name, OrgID: org.Id})
+	if err != nil {
+		return fmt.Errorf("failed to look up bucket %s: %w", name, err)
+	}
+	if buckets.Buckets == nil || len(*buckets.Buckets) == 0 {
+		if _, err := database.InfluxClient.BucketsAPI().CreateBucketWithName(ctx, org, name, rule); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", name, err)
+		}
+		return nil
+	}
+
+	existing := (*buckets.Buckets)[0]
+	if len(existing.RetentionRules) == 1 && existing.RetentionRules[0].EverySeconds == rule.EverySeconds {
+		return nil
+	}
+	existing.RetentionRules = domain.RetentionRules{rule}
+	if _, err := database.InfluxClient.BucketsAPI().UpdateBucket(ctx, &existing); err != nil {
+		return fmt.Errorf("failed to set retention of bucket %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,687 @@
+package data
+
+import (
+	"MineTracker/util"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentDateLayout = "2006-01-02"
+	rollupsDir        = "rollups"
+)
+
+// LocalStore is an embedded TimeSeriesStore for single-node deployments and
+// tests. Points are buffered in memory and flushed periodically into
+// compressed segment files, one per series and UTC day:
+//
+//	<dir>/<measurement>/<tags>/<yyyy-mm-dd>.seg
+//	<dir>/rollups/<tier>/<measurement>/<tags>/<yyyy-mm-dd>.seg
+//
+// where <tags> is the URL-encoded tag set. A rollup written again for the
+// same window replaces the earlier one when read. Query windows follow the
+// time zone of the range like Flux windows: shorter than a day they are
+// aligned to the Unix epoch, days start at local midnight, weeks on Monday,
+// months on the 1st and years on January 1. Points still in the buffer are
+// included in queries, but are lost if the process dies before the next
+// flush.
+type LocalStore struct {
+	dir string
+
+	mu     sync.Mutex
+	series map[string]*localSeries // keyed by tier/measurement/tags
+
+	// ioMu keeps readers from seeing a block that is being appended, or a
+	// point both in the buffer and on disk. It is taken before mu.
+	ioMu sync.RWMutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type localSeries struct {
+	tier        string // empty for raw points
+	measurement string
+	tags        map[string]string
+	path        string
+	pending     []segmentPoint
+}
+
+// OpenLocalStore opens or creates a store in dir and flushes it every
+// flushEvery until Close.
+func OpenLocalStore(dir string, flushEvery time.Duration) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &LocalStore{
+		dir:    dir,
+		series: make(map[string]*localSeries),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := s.loadSeries(dir, ""); err != nil {
+		return nil, err
+	}
+	for _, tier := range RollupTiers {
+		if err := s.loadSeries(filepath.Join(dir, rollupsDir, tier.Name), tier.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	go s.flushLoop(flushEvery)
+	return s, nil
+}
+
+// seriesDirName encodes a tag set into a directory name.
+func seriesDirName(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	if len(values) == 0 {
+		return "_"
+	}
+	return url.PathEscape(values.Encode())
+}
+
+func parseSeriesDirName(name string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if name == "_" {
+		return tags, nil
+	}
+	decoded, err := url.PathUnescape(name)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(decoded)
+	if err != nil {
+		return nil, err
+	}
+	for k := range values {
+		tags[k] = values.Get(k)
+	}
+	return tags, nil
+}
+
+// loadSeries registers the series of a tier already on disk under root.
+func (s *LocalStore) loadSeries(root, tier string) error {
+	measurements, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range measurements {
+		if !m.IsDir() || (tier == "" && m.Name() == rollupsDir) {
+			continue
+		}
+		measurement, err := url.PathUnescape(m.Name())
+		if err != nil {
+			continue
+		}
+		dirs, err := os.ReadDir(filepath.Join(root, m.Name()))
+		if err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			if !d.IsDir() {
+				continue
+			}
+			tags, err := parseSeriesDirName(d.Name())
+			if err != nil {
+				continue
+			}
+			s.series[tier+"/"+measurement+"/"+d.Name()] = &localSeries{
+				tier:        tier,
+				measurement: measurement,
+				tags:        tags,
+				path:        filepath.Join(root, m.Name(), d.Name()),
+			}
+		}
+	}
+	return nil
+}
+
+// fieldValue converts a numeric field to float64. Other types are not
+// stored by the local backend.
+func fieldValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (s *LocalStore) Write(p SeriesPoint) {
+	fields := make(map[string]float64, len(p.Fields))
+	for name, v := range p.Fields {
+		if f, ok := fieldValue(v); ok {
+			fields[name] = f
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series := s.seriesFor("", p.Measurement, p.Tags)
+	series.pending = append(series.pending, segmentPoint{Time: p.Time.UnixNano(), Fields: fields})
+}
+
+// seriesFor returns the series of a tier, registering it if it is new.
+// s.mu must be held.
+func (s *LocalStore) seriesFor(tier, measurement string, tags map[string]string) *localSeries {
+	dirName := seriesDirName(tags)
+	key := tier + "/" + measurement + "/" + dirName
+
+	series, ok := s.series[key]
+	if !ok {
+		root := s.dir
+		if tier != "" {
+			root = filepath.Join(s.dir, rollupsDir, tier)
+		}
+		copied := make(map[string]string, len(tags))
+		for k, v := range tags {
+			copied[k] = v
+		}
+		series = &localSeries{
+			tier:        tier,
+			measurement: measurement,
+			tags:        copied,
+			path:        filepath.Join(root, url.PathEscape(measurement), dirName),
+		}
+		s.series[key] = series
+	}
+	return series
+}
+
+func (s *LocalStore) flushLoop(every time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				util.Logger.Error().Err(err).Msg("Failed to flush local time-series store")
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Flush writes all buffered points to their segment files. Points that
+// could not be written stay buffered for the next flush.
+func (s *LocalStore) Flush() error {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+
+	s.mu.Lock()
+	batches := make(map[*localSeries][]segmentPoint)
+	for _, series := range s.series {
+		if len(series.pending) > 0 {
+			batches[series] = series.pending
+			series.pending = nil
+		}
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+	for series, points := range batches {
+		if err := s.writeSeries(series, points); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			s.mu.Lock()
+			series.pending = append(points, series.pending...)
+			s.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
+// writeSeries appends points to the segment files of their days.
+func (s *LocalStore) writeSeries(series *localSeries, points []segmentPoint) error {
+	if err := os.MkdirAll(series.path, 0o755); err != nil {
+		return err
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+
+	for len(points) > 0 {
+		day := time.Unix(0, points[0].Time).UTC().Format(segmentDateLayout)
+		n := 1
+		for n < len(points) && time.Unix(0, points[n].Time).UTC().Format(segmentDateLayout) == day {
+			n++
+		}
+		if err := appendSegmentBlock(filepath.Join(series.path, day+".seg"), points[:n]); err != nil {
+			return err
+		}
+		points = points[n:]
+	}
+	return nil
+}
+
+func (s *LocalStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// matchingSeries returns the series of measurement in tier that pass
+// filter, sorted by their directory so results are stable.
+func (s *LocalStore) matchingSeries(tier, measurement string, filter func(tags map[string]string) bool) []*localSeries {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []*localSeries
+	for _, series := range s.series {
+		if series.tier == tier && series.measurement == measurement && filter(series.tags) {
+			matched = append(matched, series)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].path < matched[j].path })
+	return matched
+}
+
+// readField returns the points with field in [from, to] in time order.
+func (s *LocalStore) readField(series *localSeries, field string, from, to time.Time) ([]segmentPoint, error) {
+	points, err := s.readPoints(series, from, to)
+	if err != nil {
+		return nil, err
+	}
+	kept := points[:0]
+	for _, p := range points {
+		if _, ok := p.Fields[field]; ok {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+// readPoints returns the points in [from, to] in time order. Of rollups
+// with the same time only the last written is kept.
+func (s *LocalStore) readPoints(series *localSeries, from, to time.Time) ([]segmentPoint, error) {
+	firstDay := from.UTC().Format(segmentDateLayout)
+
+	var points []segmentPoint
+
+	s.ioMu.RLock()
+	defer s.ioMu.RUnlock()
+
+	files, err := os.ReadDir(series.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		day, ok := strings.CutSuffix(f.Name(), ".seg")
+		if !ok || day < firstDay {
+			continue
+		}
+		filePoints, err := readSegmentFile(filepath.Join(series.path, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, filePoints...)
+	}
+
+	s.mu.Lock()
+	points = append(points, series.pending...)
+	s.mu.Unlock()
+
+	fromNs, toNs := from.UnixNano(), to.UnixNano()
+	kept := points[:0]
+	for _, p := range points {
+		if p.Time >= fromNs && p.Time <= toNs {
+			kept = append(kept, p)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Time < kept[j].Time })
+
+	if series.tier != "" {
+		deduped := kept[:0]
+		for i, p := range kept {
+			if i+1 < len(kept) && kept[i+1].Time == p.Time {
+				continue
+			}
+			deduped = append(deduped, p)
+		}
+		kept = deduped
+	}
+	return kept, nil
+}
+
+// weightedMean returns sum(values[i] * weights[i]) / sum(weights).
+func weightedMean(values, weights []float64) float64 {
+	var sum, total float64
+	for i, v := range values {
+		sum += v * weights[i]
+		total += weights[i]
+	}
+	return sum / total
+}
+
+// aggregateValues applies one of the fluxAggregates or fluxWindowFunctions
+// to values in time order.
+func aggregateValues(fn string, values []float64) float64 {
+	switch fn {
+	case "sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	case "mean":
+		return aggregateValues("sum", values) / float64(len(values))
+	case "min":
+		return slices.Min(values)
+	case "max":
+		return slices.Max(values)
+	case "count":
+		return float64(len(values))
+	case "first":
+		return values[0]
+	case "last":
+		return values[len(values)-1]
+	case "median":
+		sorted := slices.Clone(values)
+		slices.Sort(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	case "p95":
+		// Nearest rank, like Flux's exact_selector.
+		sorted := slices.Clone(values)
+		slices.Sort(sorted)
+		rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+		return sorted[max(rank, 0)]
+	}
+	return math.NaN()
+}
+
+func (s *LocalStore) QueryWindows(ctx context.Context, q WindowQuery) ([]WindowValue, error) {
+	from, to, step, err := validateWindowQuery(q, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	loc := q.historyRange().location()
+
+	matched := s.matchingSeries(q.Tier, q.Measurement, func(tags map[string]string) bool {
+		return len(q.Values) == 0 || slices.Contains(q.Values, tags[q.Tag])
+	})
+
+	type window struct {
+		stop    int64
+		values  []float64
+		weights []float64
+	}
+
+	var values []WindowValue
+	combined := make(map[int64][]float64)
+
+	for _, series := range matched {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		points, err := s.readField(series, q.Field, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		var windows []window
+		var stop int64
+		for _, p := range points {
+			// Points are sorted, so the window only changes past its stop.
+			if len(windows) == 0 || p.Time >= stop && stop < to.UnixNano() {
+				_, end := windowBounds(time.Unix(0, p.Time), step, loc)
+				stop = min(end.UnixNano(), to.UnixNano())
+				windows = append(windows, window{stop: stop})
+			}
+			w := &windows[len(windows)-1]
+			w.values = append(w.values, p.Fields[q.Field])
+			if q.Weight != "" {
+				w.weights = append(w.weights, p.Fields[q.Weight])
+			}
+		}
+
+		for _, w := range windows {
+			var v float64
+			if q.Weight == "" {
+				v = aggregateValues(q.Fn, w.values)
+			} else {
+				v = weightedMean(w.values, w.weights)
+			}
+			if q.Combine != "" {
+				combined[w.stop] = append(combined[w.stop], v)
+				continue
+			}
+			values = append(values, WindowValue{
+				Time:   time.Unix(0, w.stop),
+				Series: series.tags[q.Tag],
+				Value:  v,
+			})
+		}
+	}
+
+	if q.Combine == "" {
+		sort.SliceStable(values, func(i, j int) bool {
+			if values[i].Series != values[j].Series {
+				return values[i].Series < values[j].Series
+			}
+			return values[i].Time.Before(values[j].Time)
+		})
+		return values, nil
+	}
+
+	for stop, vs := range combined {
+		values = append(values, WindowValue{Time: time.Unix(0, stop), Value: aggregateValues(q.Combine, vs)})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Time.Before(values[j].Time) })
+	return values, nil
+}
+
+func (s *LocalStore) QueryAggregate(ctx context.Context, q AggregateQuery) (map[string]float64, error) {
+	from, to := q.From, q.To
+	if from.IsZero() {
+		start, err := ParseFluxDuration(q.Start)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid range: %v", ErrInvalidQuery, err)
+		}
+		if start.Approx() > MaxQueryRange {
+			return nil, fmt.Errorf("%w: range %s is longer than %s", ErrInvalidQuery, start, MaxQueryRange)
+		}
+		to = time.Now()
+		from = to.Add(-start.Approx())
+	} else if !from.Before(to) {
+		return nil, fmt.Errorf("%w: empty range %s to %s", ErrInvalidQuery, from, to)
+	}
+	if !fluxAggregates[q.Fn] {
+		return nil, fmt.Errorf("%w: unsupported aggregate %q", ErrInvalidQuery, q.Fn)
+	}
+
+	matched := s.matchingSeries(q.Tier, q.Measurement, func(tags map[string]string) bool {
+		return tags[q.Tag] == q.Value
+	})
+
+	groups := make(map[string][]float64)
+	for _, series := range matched {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		points, err := s.readField(series, q.Field, from, to)
+		if err != nil {
+			return nil, err
+		}
+		key := series.tags[q.GroupBy]
+		for _, p := range points {
+			groups[key] = append(groups[key], p.Fields[q.Field])
+		}
+	}
+
+	aggregates := make(map[string]float64, len(groups))
+	for key, vs := range groups {
+		aggregates[key] = aggregateValues(q.Fn, vs)
+	}
+	return aggregates, nil
+}
+
+// Rollup aggregates the raw points of every series into windows of the tier
+// and writes them to the tier's segment files.
+func (s *LocalStore) Rollup(ctx context.Context, tier RollupTier, from, to time.Time) error {
+	for measurement, fields := range rollupFields {
+		for _, series := range s.matchingSeries("", measurement, func(map[string]string) bool { return true }) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			points, err := s.readPoints(series, from, to)
+			if err != nil {
+				return err
+			}
+
+			var rollups []segmentPoint
+			windowValues := make(map[string][]float64)
+			flush := func(start int64) {
+				rollup := segmentPoint{Time: start, Fields: make(map[string]float64)}
+				for _, field := range fields {
+					values := windowValues[field]
+					if len(values) == 0 {
+						continue
+					}
+					for _, stat := range rollupStats {
+						rollup.Fields[RollupField(field, stat)] = aggregateValues(stat, values)
+					}
+					windowValues[field] = values[:0]
+				}
+				if len(rollup.Fields) > 0 {
+					rollups = append(rollups, rollup)
+				}
+			}
+
+			var start int64
+			for i, p := range points {
+				if p.Time >= to.UnixNano() {
+					break
+				}
+				if window := rollupWindowStart(p.Time, tier.Every); i == 0 || window != start {
+					if i > 0 {
+						flush(start)
+					}
+					start = window
+				}
+				for _, field := range fields {
+					if v, ok := p.Fields[field]; ok {
+						windowValues[field] = append(windowValues[field], v)
+					}
+				}
+			}
+			if len(points) > 0 {
+				flush(start)
+			}
+
+			if len(rollups) == 0 {
+				continue
+			}
+			if err := s.writeRollups(tier.Name, measurement, series.tags, rollups); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeRollups writes rollups straight to their segment files, so the
+// progress recorded after Rollup returns never runs ahead of the disk.
+func (s *LocalStore) writeRollups(tier, measurement string, tags map[string]string, rollups []segmentPoint) error {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+
+	s.mu.Lock()
+	series := s.seriesFor(tier, measurement, tags)
+	s.mu.Unlock()
+
+	return s.writeSeries(series, rollups)
+}
+
+// ApplyRetention deletes the segment files of days that ended before the
+// retention of their tier.
+func (s *LocalStore) ApplyRetention(ctx context.Context) error {
+	now := time.Now()
+	retention := map[string]time.Duration{"": rawRetention}
+	for _, tier := range RollupTiers {
+		retention[tier.Name] = tier.Retention
+	}
+
+	s.mu.Lock()
+	all := make([]*localSeries, 0, len(s.series))
+	for _, series := range s.series {
+		all = append(all, series)
+	}
+	s.mu.Unlock()
+
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+
+	for _, series := range all {
+		keep := retention[series.tier]
+		if keep <= 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// A day is deleted once all of it is older than the cutoff.
+		lastExpired := now.Add(-keep).UTC().AddDate(0, 0, -1).Format(segmentDateLayout)
+
+		files, err := os.ReadDir(series.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			day, ok := strings.CutSuffix(f.Name(), ".seg")
+			if !ok || day > lastExpired {
+				continue
+			}
+			if err := os.Remove(filepath.Join(series.path, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, dir string) *LocalStore {
+	t.Helper()
+
+	store, err := OpenLocalStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "day.seg")
+
+	first := []segmentPoint{
+		{Time: 1_000, Fields: map[string]float64{"player_count": 10, "latency": 42.5}},
+		{Time: 2_000, Fields: map[string]float64{"player_count": 12}},
+	}
+	second := []segmentPoint{
+		{Time: 900, Fields: map[string]float64{"latency": -1}},
+	}
+	if err := appendSegmentBlock(path, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendSegmentBlock(path, second); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block cut short by a crash is ignored.
+	torn, err := encodeSegmentBlock(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write(torn[:len(torn)/2])
+	_ = f.Close()
+
+	points, err := readSegmentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]segmentPoint{}, first...), second...)
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d: %v", len(points), len(want), points)
+	}
+	for i, p := range points {
+		if p.Time != want[i].Time || len(p.Fields) != len(want[i].Fields) {
+			t.Fatalf("point %d = %v, want %v", i, p, want[i])
+		}
+		for name, v := range want[i].Fields {
+			if p.Fields[name] != v {
+				t.Fatalf("point %d field %s = %v, want %v", i, name, p.Fields[name], v)
+			}
+		}
+	}
+}
+
+func TestLocalStoreWindows(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	hour := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	write := func(id string, at time.Duration, players int) {
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": id, "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": players, "latency": 20.0},
+			Time:        hour.Add(at),
+		})
+	}
+	write("a", 10*time.Minute, 10)
+	write("a", 20*time.Minute, 20)
+	write("a", 70*time.Minute, 30)
+	write("b", 15*time.Minute, 5)
+	write("c", 15*time.Minute, 1000)
+
+	// Flushed points must survive a restart, buffered ones are queried too.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store = openTestStore(t, dir)
+	defer store.Close()
+	write("b", 80*time.Minute, 7)
+
+	q := serverWindowQuery("-1d", "1h", "", FieldPlayerCount, "mean")
+	q.Values = []string{"a", "b"}
+	values, err := store.QueryWindows(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WindowValue{
+		{Series: "a", Time: hour.Add(time.Hour), Value: 15},
+		{Series: "a", Time: hour.Add(2 * time.Hour), Value: 30},
+		{Series: "b", Time: hour.Add(time.Hour), Value: 5},
+		{Series: "b", Time: hour.Add(2 * time.Hour), Value: 7},
+	}
+	assertWindows(t, values, want)
+
+	q, err = groupWindowQuery("-1d", "1h", []string{"a", "b"}, FieldPlayerCount, "mean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = store.QueryWindows(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []WindowValue{
+		{Time: hour.Add(time.Hour), Value: 20},
+		{Time: hour.Add(2 * time.Hour), Value: 37},
+	}
+	assertWindows(t, values, want)
+}
+
+func assertWindows(t *testing.T, got, want []WindowValue) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d windows, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Series != want[i].Series || !got[i].Time.Equal(want[i].Time) || got[i].Value != want[i].Value {
+			t.Errorf("window %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLocalStoreFailureBreakdown(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	previous := TimeSeries
+	TimeSeries = store
+	defer func() { TimeSeries = previous }()
+
+	now := time.Now()
+	fail := func(id, reason string, ago time.Duration) {
+		store.Write(SeriesPoint{
+			Measurement: "ping_failure",
+			Tags:        map[string]string{"id": id, "type": "PC", "reason": reason},
+			Fields:      map[string]interface{}{"count": 1, "message": "ignored"},
+			Time:        now.Add(-ago),
+		})
+	}
+	fail("a", "timeout", time.Minute)
+	fail("a", "timeout", time.Hour)
+	fail("a", "dns", 2*time.Hour)
+	fail("a", "dns", 48*time.Hour) // outside the range
+	fail("b", "timeout", time.Minute)
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	breakdown, err := QueryFailureBreakdown("a", "-1d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breakdown) != 2 || breakdown["timeout"] != 2 || breakdown["dns"] != 1 {
+		t.Errorf("breakdown = %v", breakdown)
+	}
+}
+
+func TestLocalStoreRejectsInvalidQueries(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	for _, q := range []WindowQuery{
+		serverWindowQuery("1d) |> drop()", "1m", "a", FieldPlayerCount, "mean"),
+		serverWindowQuery("-1d", "0s", "a", FieldPlayerCount, "mean"),
+		serverWindowQuery("-11y", "1h", "a", FieldPlayerCount, "mean"),
+		{Measurement: "server_data", Field: FieldPlayerCount, Start: "-1d", Step: "1m", Fn: "spread"},
+	} {
+		if _, err := store.QueryWindows(context.Background(), q); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%+v: got %v, want ErrInvalidQuery", q, err)
+		}
+	}
+	if _, err := store.QueryAggregate(context.Background(), failureQuery("-", "a")); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("aggregate: got %v, want ErrInvalidQuery", err)
+	}
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ServerMetadata is descriptive information shown alongside a server. It is
+// set in servers.json or the admin API, and verified owners may edit it.
+type ServerMetadata struct {
+	// Tags are free-form categories such as "PvP", "Survival" or "Network".
+	Tags        []string `json:"tags,omitempty"`
+	Language    string   `json:"language,omitempty"` // ISO 639-1, e.g. "en"
+	Country     string   `json:"country,omitempty"`  // ISO 3166-1 alpha-2, e.g. "DE"
+	Website     string   `json:"website,omitempty"`
+	Discord     string   `json:"discord,omitempty"` // invite URL
+	Description string   `json:"description,omitempty"`
+}
+
+const (
+	maxDescriptionLength = 500
+	maxTags              = 10
+	maxTagLength         = 32
+)
+
+var (
+	languagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+var discordInviteHosts = map[string]bool{
+	"discord.gg":      true,
+	"discord.com":     true,
+	"www.discord.com": true,
+	"discordapp.com":  true,
+}
+
+// HasTag reports whether the server is tagged with tag, ignoring case.
+func (m ServerMetadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether both hold the same metadata.
+func (m ServerMetadata) Equal(o ServerMetadata) bool {
+	return slices.Equal(m.Tags, o.Tags) &&
+		m.Language == o.Language &&
+		m.Country == o.Country &&
+		m.Website == o.Website &&
+		m.Discord == o.Discord &&
+		m.Description == o.Description
+}
+
+func isHTTPURL(raw string) (*url.URL, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// Validate checks metadata lengths, code formats and that links are http(s)
+// URLs.
+func (m ServerMetadata) Validate() error {
+	if len(m.Tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, t := range m.Tags {
+		if strings.TrimSpace(t) == "" || len(t) > maxTagLength {
+			return fmt.Errorf("invalid tag %q", t)
+		}
+	}
+	if m.Language != "" && !languagePattern.MatchString(m.Language) {
+		return fmt.Errorf("language must be a lowercase ISO 639-1 code")
+	}
+	if m.Country != "" && !countryPattern.MatchString(m.Country) {
+		return fmt.Errorf("country must be an uppercase ISO 3166-1 alpha-2 code")
+	}
+	if len(m.Description) > maxDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", maxDescriptionLength)
+	}
+	if m.Website != "" {
+		if _, ok := isHTTPURL(m.Website); !ok {
+			return fmt.Errorf("website must be an http(s) URL")
+		}
+	}
+	if m.Discord != "" {
+		u, ok := isHTTPURL(m.Discord)
+		if !ok || !discordInviteHosts[strings.ToLower(u.Host)] {
+			return fmt.Errorf("discord must be a Discord invite URL")
+		}
+	}
+	return nil
+}
@@ -1,14 +1,19 @@
 package data
 
 import (
+	"MineTracker/database"
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// timeToMinutes converts a time string like "-14d" to minutes
-func timeToMinutes(time string) (float64, error) {
+// timeToMinutes converts a time string like "-14d" to minutes for sizing
+// steps. Months and years are a fixed 30 and 365 days, so the step does not
+// depend on the date; HistoryRange.Bounds places calendar ranges.
+func timeToMinutes(s string) (float64, error) {
 	timeUnits := map[string]float64{
 		"s": 1.0 / 60.0,
 		"m": 1.0,
@@ -20,14 +25,19 @@ func timeToMinutes(time string) (float64, error) {
 	}
 
 	// Remove the "-" sign if present
-	time = strings.TrimPrefix(time, "-")
+	s = strings.TrimPrefix(s, "-")
 
-	if len(time) < 2 {
-		return 0, fmt.Errorf("invalid time format:  %s", time)
+	// "mo" is the Flux spelling of "M"
+	if strings.HasSuffix(s, "mo") {
+		s = strings.TrimSuffix(s, "mo") + "M"
 	}
 
-	unit := string(time[len(time)-1])
-	valueStr := time[:len(time)-1]
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid time format:  %s", s)
+	}
+
+	unit := string(s[len(s)-1])
+	valueStr := s[:len(s)-1]
 
 	value, err := strconv.ParseFloat(valueStr, 64)
 	if err != nil {
@@ -129,8 +139,11 @@ func CalculateOptimalStepWithMin(start string, maxDataPoints, minDataPoints int)
 		return "", err
 	}
 
-	rangeInMinutes = math.Abs(rangeInMinutes)
+	return optimalStep(math.Abs(rangeInMinutes), maxDataPoints, minDataPoints), nil
+}
 
+// optimalStep is CalculateOptimalStepWithMin for a range in minutes
+func optimalStep(rangeInMinutes float64, maxDataPoints, minDataPoints int) string {
 	// Calculate step based on maxDataPoints
 	stepForMaxPoints := rangeInMinutes / float64(maxDataPoints)
 
@@ -168,7 +181,7 @@ func CalculateOptimalStepWithMin(start string, maxDataPoints, minDataPoints int)
 		stepInMinutes = minStepMinutes
 	}
 
-	return minutesToTime(stepInMinutes), nil
+	return minutesToTime(stepInMinutes)
 }
 
 // CalculateDataPoints calculates the number of data points
@@ -178,12 +191,16 @@ func CalculateDataPoints(start, step string) (int, error) {
 		return 0, err
 	}
 
+	return dataPointsFor(math.Abs(rangeInMinutes), step)
+}
+
+// dataPointsFor is CalculateDataPoints for a range in minutes
+func dataPointsFor(rangeInMinutes float64, step string) (int, error) {
 	stepInMinutes, err := timeToMinutes(step)
 	if err != nil {
 		return 0, err
 	}
 
-	rangeInMinutes = math.Abs(rangeInMinutes)
 	dataPoints := rangeInMinutes / stepInMinutes
 
 	return int(math.Ceil(dataPoints)), nil
@@ -238,17 +255,21 @@ func getAdaptiveStep(start string, maxDataPoints int) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	rangeInMinutes = math.Abs(rangeInMinutes)
 
+	return adaptiveStep(math.Abs(rangeInMinutes), maxDataPoints), nil
+}
+
+// adaptiveStep is getAdaptiveStep for a range in minutes
+func adaptiveStep(rangeInMinutes float64, maxDataPoints int) string {
 	// For short ranges, use fine-grained steps
 	if rangeInMinutes <= 1440 { // <= 1 day
 		switch {
 		case rangeInMinutes <= 60: // <= 1 hour
-			return "10s", nil
+			return "10s"
 		case rangeInMinutes <= 360: // <= 6 hours
-			return "1m", nil
+			return "1m"
 		default: // <= 1 day
-			return "4m", nil
+			return "4m"
 		}
 	}
 
@@ -261,23 +282,23 @@ func getAdaptiveStep(start string, maxDataPoints int) (string, error) {
 	// Round up to nice intervals
 	switch {
 	case stepInMinutes <= 1:
-		return "1m", nil
+		return "1m"
 	case stepInMinutes <= 5:
-		return "5m", nil
+		return "5m"
 	case stepInMinutes <= 15:
-		return "15m", nil
+		return "15m"
 	case stepInMinutes <= 30:
-		return "30m", nil
+		return "30m"
 	case stepInMinutes <= 60:
-		return "1h", nil
+		return "1h"
 	case stepInMinutes <= 120:
-		return "2h", nil
+		return "2h"
 	case stepInMinutes <= 360:
-		return "4h", nil
+		return "4h"
 	case stepInMinutes <= 720:
-		return "6h", nil
+		return "6h"
 	default:
-		return "12h", nil
+		return "12h"
 	}
 }
 
@@ -285,34 +306,98 @@ func getAdaptiveStep(start string, maxDataPoints int) (string, error) {
 // Parameters:
 //   - start: time range like "-1d", "-7d", etc.
 //   - step: aggregation window like "4m", "1h", etc.
-//   - serverFilter: optional server name filter (empty string for all servers)
+//   - serverFilter: optional server ID filter (empty string for all servers)
 func BuildInfluxQuery(start, step, serverFilter string) (string, error) {
-	// Convert step to InfluxDB duration format
-	windowDuration, err := convertToInfluxDuration(step)
+	return BuildInfluxQueryForField(start, step, serverFilter, FieldPlayerCount)
+}
+
+// BuildInfluxQueryForField builds the same query as BuildInfluxQuery for any
+// server_data field, e.g. "latency"
+func BuildInfluxQueryForField(start, step, serverFilter, field string) (string, error) {
+	if !IsHistoryField(field) {
+		return "", fmt.Errorf("%w: invalid field: %s", ErrInvalidQuery, field)
+	}
+	return buildWindowFlux(database.GetInfluxBucket(), serverWindowQuery(start, step, serverFilter, field, "mean"))
+}
+
+// BuildGroupInfluxQuery builds the query for the combined history of a group.
+// Every member series is windowed like in BuildInfluxQueryForField and the
+// members are then combined per window: player counts are summed, latencies
+// averaged.
+func BuildGroupInfluxQuery(start, step string, memberIDs []string, field string) (string, error) {
+	q, err := groupWindowQuery(start, step, memberIDs, field, "mean")
 	if err != nil {
 		return "", err
 	}
+	return buildWindowFlux(database.GetInfluxBucket(), q)
+}
 
-	// Build the base query
-	query := fmt.Sprintf(`from(bucket: "minetracker_data")
-  |> range(start:  %s)
-  |> filter(fn: (r) => r["_measurement"] == "server_data")
-  |> filter(fn: (r) => r["_field"] == "player_count")`, start)
+// serverWindowQuery selects the history of one server, or of all servers
+// when id is empty, aggregated per window with fn, from the coarsest rollup
+// tier that can answer it.
+func serverWindowQuery(start, step, id, field, fn string) WindowQuery {
+	return serverRangeQuery(RelativeRange(start), step, id, field, fn)
+}
 
-	// Add server filter if specified
-	if serverFilter != "" {
-		query += fmt.Sprintf(`
-  |> filter(fn:  (r) => r["ip"] == "%s")`, serverFilter)
+// serverRangeQuery is serverWindowQuery for any HistoryRange.
+func serverRangeQuery(r HistoryRange, step, id, field, fn string) WindowQuery {
+	return withRollupTier(rawServerRangeQuery(r, step, id, field, fn), time.Now())
+}
+
+// rawServerRangeQuery is serverRangeQuery on the raw points.
+func rawServerRangeQuery(r HistoryRange, step, id, field, fn string) WindowQuery {
+	q := WindowQuery{
+		Measurement: "server_data",
+		Field:       field,
+		Tag:         "id",
+		Start:       r.Start,
+		From:        r.From,
+		To:          r.To,
+		Location:    r.Location,
+		Step:        step,
+		Fn:          fn,
+	}
+	if id != "" {
+		q.Values = []string{id}
+	}
+	return q
+}
+
+// groupWindowQuery selects the combined history of a group, aggregating
+// every member per window with fn before combining them.
+func groupWindowQuery(start, step string, memberIDs []string, field, fn string) (WindowQuery, error) {
+	return groupRangeQuery(RelativeRange(start), step, memberIDs, field, fn)
+}
+
+// groupRangeQuery is groupWindowQuery for any HistoryRange.
+func groupRangeQuery(r HistoryRange, step string, memberIDs []string, field, fn string) (WindowQuery, error) {
+	q, err := rawGroupRangeQuery(r, step, memberIDs, field, fn)
+	if err != nil {
+		return WindowQuery{}, err
+	}
+	return withRollupTier(q, time.Now()), nil
+}
+
+// rawGroupRangeQuery is groupRangeQuery on the raw points.
+func rawGroupRangeQuery(r HistoryRange, step string, memberIDs []string, field, fn string) (WindowQuery, error) {
+	if !IsHistoryField(field) {
+		return WindowQuery{}, fmt.Errorf("%w: invalid field: %s", ErrInvalidQuery, field)
+	}
+	if len(memberIDs) == 0 {
+		return WindowQuery{}, fmt.Errorf("%w: group has no members", ErrInvalidQuery)
 	}
 
-	// Add aggregation window
-	// createEmpty: false ensures only windows with actual data are returned
-	// This is crucial for handling sparse data scenarios
-	query += fmt.Sprintf(`
-  |> aggregateWindow(every: %s, fn:  mean, createEmpty: false)
-  |> yield(name: "mean")`, windowDuration)
+	combine := "sum"
+	if field == FieldLatency {
+		combine = "mean"
+	}
 
-	return query, nil
+	// The tier is picked last, so that the raw query of the windows past
+	// its coverage selects and combines the members too.
+	q := rawServerRangeQuery(r, step, "", field, fn)
+	q.Values = memberIDs
+	q.Combine = combine
+	return q, nil
 }
 
 // BuildInfluxQueryWithOptimalStep builds an InfluxDB Flux query with automatically calculated optimal step
@@ -342,16 +427,27 @@ func BuildInfluxQueryWithOptimalStepAndMin(start, serverFilter string, maxDataPo
 
 // QueryParams holds the parameters for building an InfluxDB query
 type QueryParams struct {
-	Start         string // Time range like "-1d", "-7d"
-	Step          string // Aggregation window like "4m", "1h" (optional, will be calculated if empty)
-	ServerFilter  string // Server name filter (optional)
-	MaxDataPoints int    // Maximum number of data points (default: 360)
-	MinDataPoints int    // Minimum number of data points (default: 10)
-	UseAdaptive   bool   // Use adaptive step calculation (recommended for sparse data)
+	Start         string         // Time range like "-1d", "-7d"
+	From, To      time.Time      // Absolute range (optional, replaces Start when From is set)
+	Location      *time.Location // Time zone daily and longer windows are aligned to (optional, default: UTC)
+	Step          string         // Aggregation window like "4m", "1h" (optional, will be calculated if empty)
+	ServerFilter  string         // Server ID filter (optional)
+	MaxDataPoints int            // Maximum number of data points (default: 360)
+	MinDataPoints int            // Minimum number of data points (default: 10)
+	UseAdaptive   bool           // Use adaptive step calculation (recommended for sparse data)
+	Field         string         // server_data field to query (default: "player_count")
+	GroupMembers  []string       // Server IDs to combine into one series (optional, replaces ServerFilter)
+	Aggregate     string         // Per-window aggregate, one of HistoryAggregates (default: "mean")
 }
 
-// BuildInfluxQueryFromParams builds an InfluxDB Flux query from QueryParams
-func BuildInfluxQueryFromParams(params QueryParams) (string, int, string, error) {
+// historyRange returns the range of params
+func (params QueryParams) historyRange() HistoryRange {
+	return HistoryRange{Start: params.Start, From: params.From, To: params.To, Location: params.Location}
+}
+
+// ResolveStep validates the range and step of params, calculating the step
+// when it is empty, and estimates the number of data points
+func ResolveStep(params QueryParams) (string, int, error) {
 	// Set default max data points if not specified
 	if params.MaxDataPoints <= 0 {
 		params.MaxDataPoints = 360
@@ -362,6 +458,33 @@ func BuildInfluxQueryFromParams(params QueryParams) (string, int, string, error)
 		params.MinDataPoints = 10
 	}
 
+	// Reject malformed ranges and steps before deriving anything from them
+	r := params.historyRange()
+	if r.Absolute() {
+		if err := r.validate(); err != nil {
+			return "", 0, err
+		}
+	} else if _, err := ParseFluxDuration(params.Start); err != nil {
+		return "", 0, fmt.Errorf("%w: invalid range: %v", ErrInvalidQuery, err)
+	}
+	if params.Step != "" {
+		if _, err := ParseFluxDuration(params.Step); err != nil {
+			return "", 0, fmt.Errorf("%w: invalid step: %v", ErrInvalidQuery, err)
+		}
+	}
+
+	// Absolute ranges are measured from their bounds
+	var rangeInMinutes float64
+	if r.Absolute() {
+		rangeInMinutes = params.To.Sub(params.From).Minutes()
+	} else {
+		minutes, err := timeToMinutes(params.Start)
+		if err != nil {
+			return "", 0, err
+		}
+		rangeInMinutes = math.Abs(minutes)
+	}
+
 	var step string
 	var err error
 
@@ -370,21 +493,15 @@ func BuildInfluxQueryFromParams(params QueryParams) (string, int, string, error)
 		if params.UseAdaptive {
 			// Use adaptive mode:  choose step based on time range
 			// This is better for handling sparse data scenarios
-			step, err = getAdaptiveStep(params.Start, params.MaxDataPoints)
-			if err != nil {
-				return "", 0, step, err
-			}
+			step = adaptiveStep(rangeInMinutes, params.MaxDataPoints)
 		} else {
 			// Use calculated mode: balance between min and max data points
-			step, err = CalculateOptimalStepWithMin(params.Start, params.MaxDataPoints, params.MinDataPoints)
-			if err != nil {
-				return "", 0, step, err
-			}
+			step = optimalStep(rangeInMinutes, params.MaxDataPoints, params.MinDataPoints)
 
 			// Round to nice step
 			step, err = RoundToNiceStep(step)
 			if err != nil {
-				return "", 0, step, err
+				return step, 0, err
 			}
 		}
 	} else {
@@ -392,13 +509,45 @@ func BuildInfluxQueryFromParams(params QueryParams) (string, int, string, error)
 	}
 
 	// Calculate actual data points (estimated based on requested range)
-	dataPoints, err := CalculateDataPoints(params.Start, step)
+	dataPoints, err := dataPointsFor(rangeInMinutes, step)
+	if err != nil {
+		return step, 0, err
+	}
+
+	return step, dataPoints, nil
+}
+
+// BuildInfluxQueryFromParams builds an InfluxDB Flux query from QueryParams
+func BuildInfluxQueryFromParams(params QueryParams) (string, int, string, error) {
+	// Set default field if not specified
+	if params.Field == "" {
+		params.Field = FieldPlayerCount
+	}
+
+	step, dataPoints, err := ResolveStep(params)
 	if err != nil {
 		return "", 0, step, err
 	}
 
+	if params.Aggregate == "" {
+		params.Aggregate = "mean"
+	}
+	if !slices.Contains(HistoryAggregates, params.Aggregate) {
+		return "", 0, step, fmt.Errorf("%w: unknown aggregate %q", ErrInvalidQuery, params.Aggregate)
+	}
+	if !IsHistoryField(params.Field) {
+		return "", 0, step, fmt.Errorf("%w: invalid field: %s", ErrInvalidQuery, params.Field)
+	}
+
 	// Build the query
-	query, err := BuildInfluxQuery(params.Start, step, params.ServerFilter)
+	q := serverRangeQuery(params.historyRange(), step, params.ServerFilter, params.Field, params.Aggregate)
+	if len(params.GroupMembers) > 0 {
+		q, err = groupRangeQuery(params.historyRange(), step, params.GroupMembers, params.Field, params.Aggregate)
+		if err != nil {
+			return "", 0, step, err
+		}
+	}
+	query, err := buildWindowFlux(database.GetInfluxBucket(), q)
 	if err != nil {
 		return "", 0, step, err
 	}
@@ -0,0 +1,306 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RollupTier is a downsampled copy of the raw history. Every window of the
+// tier holds the min, max, mean, last and count of each raw field, stored
+// as "<field>_<stat>" and stamped with the start of the window.
+type RollupTier struct {
+	Name      string        // window size as a Flux duration, e.g. "1m"
+	Every     time.Duration // window size
+	Retention time.Duration // 0 keeps the rollups forever
+}
+
+// rollupStats are the statistics stored per field and window.
+var rollupStats = []string{"min", "max", "mean", "last", "count"}
+
+// rollupFields are the numeric fields rolled up per measurement.
+var rollupFields = map[string][]string{
+	"server_data":  {FieldPlayerCount, FieldLatency, "dns_time", "connect_time", "total_time"},
+	"ping_failure": {"count"},
+}
+
+// RollupField returns the name of the rollup field holding stat of field.
+func RollupField(field, stat string) string {
+	return field + "_" + stat
+}
+
+// RollupTiers are the configured tiers from fine to coarse. The retention
+// of each comes from RETENTION_<NAME>, e.g. RETENTION_1H=1y, where "0" keeps
+// the rollups forever.
+var RollupTiers = []RollupTier{
+	{Name: "1m", Every: time.Minute, Retention: 30 * 24 * time.Hour},
+	{Name: "1h", Every: time.Hour, Retention: 365 * 24 * time.Hour},
+	{Name: "1d", Every: 24 * time.Hour},
+}
+
+// rawRetention is the retention of the raw points from RETENTION_RAW. When
+// the variable is unset, raw points are kept and an existing InfluxDB
+// bucket retention is left alone.
+var (
+	rawRetention       time.Duration
+	manageRawRetention bool
+)
+
+// rollupLag is how long the rollups may trail a tier's last window before
+// queries stop using the tier.
+const rollupLag = 5 * time.Minute
+
+// rollupAggregateWindows is the minimum number of tier windows a range
+// needs before aggregates over the whole range use the tier.
+const rollupAggregateWindows = 100
+
+// DownsamplingEnabled reports whether DOWNSAMPLING_ENABLED allows rolling up
+// and querying tiers; it is on by default.
+func DownsamplingEnabled() bool {
+	return os.Getenv("DOWNSAMPLING_ENABLED") != "false"
+}
+
+// parseRetention parses a retention period: "0" means forever.
+func parseRetention(name, raw string) (time.Duration, error) {
+	if raw == "0" {
+		return 0, nil
+	}
+	d, err := ParseFluxDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d.Approx(), nil
+}
+
+// loadRetentionConfig reads RETENTION_RAW and the retention of every tier.
+func loadRetentionConfig() error {
+	if raw := os.Getenv("RETENTION_RAW"); raw != "" {
+		d, err := parseRetention("RETENTION_RAW", raw)
+		if err != nil {
+			return err
+		}
+		rawRetention, manageRawRetention = d, true
+	}
+
+	for i, tier := range RollupTiers {
+		name := "RETENTION_" + strings.ToUpper(tier.Name)
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		d, err := parseRetention(name, raw)
+		if err != nil {
+			return err
+		}
+		if d != 0 && d < tier.Every {
+			return fmt.Errorf("%s is shorter than one %s window", name, tier.Name)
+		}
+		RollupTiers[i].Retention = d
+	}
+	return nil
+}
+
+// RollupCoverage is the range of windows a tier has been rolled up for.
+type RollupCoverage struct {
+	Since time.Time
+	Until time.Time
+}
+
+var (
+	rollupCoverageMu sync.RWMutex
+	rollupCoverage   = make(map[string]RollupCoverage)
+)
+
+// SetRollupCoverage records the range a tier has been rolled up for.
+// Queries only use a tier that covers their whole range.
+func SetRollupCoverage(tier string, c RollupCoverage) {
+	rollupCoverageMu.Lock()
+	defer rollupCoverageMu.Unlock()
+	rollupCoverage[tier] = c
+}
+
+// tierCoverage returns the end of the windows tier holds from from on at
+// now. ok is false when the tier does not reach back to from or trails to
+// by more than a window and rollupLag, so that few raw points are read for
+// the windows past its end.
+func tierCoverage(tier RollupTier, from, to, now time.Time) (until time.Time, ok bool) {
+	rollupCoverageMu.RLock()
+	c, ok := rollupCoverage[tier.Name]
+	rollupCoverageMu.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	since := c.Since
+	if tier.Retention > 0 && since.Before(now.Add(-tier.Retention)) {
+		since = now.Add(-tier.Retention)
+	}
+	return c.Until, !since.After(from) && to.Sub(c.Until) <= tier.Every+rollupLag
+}
+
+// tierFitsLocation reports whether windows aligned to loc between from and
+// to are made of whole tier windows, which are aligned to UTC. That holds
+// for tiers no longer than an hour in zones offset by whole tier windows.
+func tierFitsLocation(every time.Duration, loc *time.Location, from, to time.Time) bool {
+	if locationName(loc) == "" {
+		return true
+	}
+	if every > time.Hour {
+		return false
+	}
+	for _, t := range []time.Time{from, to} {
+		_, offset := t.In(loc).Zone()
+		if (time.Duration(offset)*time.Second)%every != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stepFitsTier reports whether windows of step are made of whole tier
+// windows. Months and years are made of whole days.
+func stepFitsTier(step FluxDuration, every time.Duration) bool {
+	if step.unit == "mo" || step.unit == "y" {
+		return every <= 24*time.Hour && (24*time.Hour)%every == 0
+	}
+	return step.Approx()%every == 0
+}
+
+// tierStat maps an aggregate to the rollup statistic it can be computed
+// from and the aggregate to apply to that statistic.
+func tierStat(fn string) (string, string, bool) {
+	switch fn {
+	case "mean", "min", "max", "last":
+		return fn, fn, true
+	case "count":
+		return "count", "sum", true
+	}
+	return "", "", false
+}
+
+// withRollupTier moves q to the coarsest tier whose windows fit the step and
+// its time zone and that covers the range. Means are weighted by the count
+// of each tier window, so they equal the mean of the raw points. Windows
+// the tier has not been rolled up for yet move to q.Rest, which reads them
+// from the raw points. Queries that no tier can answer, including invalid
+// ones, are returned unchanged and read the raw points.
+func withRollupTier(q WindowQuery, now time.Time) WindowQuery {
+	if !DownsamplingEnabled() || q.Tier != "" {
+		return q
+	}
+	stat, fn, ok := tierStat(q.Fn)
+	if !ok {
+		return q
+	}
+	r := q.historyRange()
+	if r.validate() != nil {
+		return q
+	}
+	step, err := ParseFluxDuration(q.Step)
+	if err != nil {
+		return q
+	}
+
+	from, to := r.Bounds(now)
+	for i := len(RollupTiers) - 1; i >= 0; i-- {
+		tier := RollupTiers[i]
+		if !stepFitsTier(step, tier.Every) || !tierFitsLocation(tier.Every, q.Location, from, to) {
+			continue
+		}
+		until, ok := tierCoverage(tier, from, to, now)
+		if !ok {
+			continue
+		}
+
+		tiered := q
+		if until.Before(to) {
+			// The window holding until is read from the raw points as a
+			// whole, so every window comes from one source.
+			split, _ := windowBounds(until, step, r.location())
+			if !split.After(from) {
+				continue
+			}
+			rest := q
+			rest.Start, rest.From, rest.To = "", split, to
+			tiered.Start, tiered.From, tiered.To = "", from, split
+			tiered.Rest = &rest
+		}
+		tiered.Tier = tier.Name
+		if fn == "mean" {
+			tiered.Weight = RollupField(q.Field, "count")
+		}
+		tiered.Field = RollupField(q.Field, stat)
+		tiered.Fn = fn
+		return tiered
+	}
+	return q
+}
+
+// withCommonRollupTier moves queries to the tier withRollupTier picks when it
+// picks the same tier and coverage for every one of them. Otherwise all of
+// them read the raw points, so that they answer the same windows.
+func withCommonRollupTier(queries []WindowQuery, now time.Time) []WindowQuery {
+	tiered := make([]WindowQuery, len(queries))
+	for i, q := range queries {
+		tiered[i] = withRollupTier(q, now)
+		if tiered[i].Tier == "" || tiered[i].Tier != tiered[0].Tier || !sameRest(tiered[i].Rest, tiered[0].Rest) {
+			return queries
+		}
+	}
+	return tiered
+}
+
+// sameRest reports whether two tier queries leave the same range to the raw
+// points.
+func sameRest(a, b *WindowQuery) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.From.Equal(b.From) && a.To.Equal(b.To)
+}
+
+// withRollupTierAggregate moves q to the coarsest tier that covers the range
+// with at least rollupAggregateWindows windows. Only sums of counters, whose
+// raw points are all 1 like ping_failure's "count", can be moved. The range
+// the tier has not been rolled up for yet moves to q.Rest.
+func withRollupTierAggregate(q AggregateQuery, now time.Time) AggregateQuery {
+	if !DownsamplingEnabled() || q.Tier != "" || q.Fn != "sum" || !q.From.IsZero() {
+		return q
+	}
+	start, err := ParseFluxDuration(q.Start)
+	if err != nil {
+		return q
+	}
+
+	from := now.Add(-start.Approx())
+	for i := len(RollupTiers) - 1; i >= 0; i-- {
+		tier := RollupTiers[i]
+		if start.Approx() < rollupAggregateWindows*tier.Every {
+			continue
+		}
+		until, ok := tierCoverage(tier, from, now, now)
+		if !ok {
+			continue
+		}
+
+		tiered := q
+		if until.Before(now) {
+			rest := q
+			rest.Start, rest.From, rest.To = "", until, now
+			tiered.Start, tiered.From, tiered.To = "", from, until
+			tiered.Rest = &rest
+		}
+		tiered.Tier = tier.Name
+		tiered.Field = RollupField(q.Field, "count")
+		return tiered
+	}
+	return q
+}
+
+// rollupWindowStart returns the start of the tier window containing t.
+// Windows are aligned to the Unix epoch, like Flux windows.
+func rollupWindowStart(t int64, every time.Duration) int64 {
+	return t - t%every.Nanoseconds()
+}
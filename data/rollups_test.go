@@ -0,0 +1,419 @@
+package data
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setCoverage replaces the rollup coverage for the duration of a test.
+func setCoverage(t *testing.T, coverage map[string]RollupCoverage) {
+	t.Helper()
+
+	rollupCoverageMu.Lock()
+	previous := rollupCoverage
+	rollupCoverage = coverage
+	rollupCoverageMu.Unlock()
+
+	t.Cleanup(func() {
+		rollupCoverageMu.Lock()
+		rollupCoverage = previous
+		rollupCoverageMu.Unlock()
+	})
+}
+
+func TestLocalRollup(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	minute := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	write := func(id string, at time.Duration, players int, latency float64) {
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": id, "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": players, "latency": latency},
+			Time:        minute.Add(at),
+		})
+	}
+	write("a", 5*time.Second, 10, 30)
+	write("a", 25*time.Second, 30, 10)
+	write("a", 45*time.Second, 20, 20)
+	write("a", 65*time.Second, 40, 50)
+	write("b", 10*time.Second, 5, 100)
+	write("a", 125*time.Second, 99, 99) // outside the rolled up range
+
+	tier := RollupTiers[0]
+	from, to := minute, minute.Add(2*time.Minute)
+	// Rolling up twice must not count the windows twice.
+	for i := 0; i < 2; i++ {
+		if err := store.Rollup(context.Background(), tier, from, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(id string) []segmentPoint {
+		series := store.matchingSeries(tier.Name, "server_data", func(tags map[string]string) bool {
+			return tags["id"] == id && tags["type"] == "PC"
+		})
+		if len(series) != 1 {
+			t.Fatalf("got %d rollup series for %s, want 1", len(series), id)
+		}
+		points, err := store.readPoints(series[0], from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return points
+	}
+
+	a := read("a")
+	if len(a) != 2 {
+		t.Fatalf("got %d rollups for a, want 2: %v", len(a), a)
+	}
+	if a[0].Time != minute.UnixNano() || a[1].Time != minute.Add(time.Minute).UnixNano() {
+		t.Errorf("rollups not stamped with their window start: %v", a)
+	}
+	want := map[string]float64{
+		"player_count_min": 10, "player_count_max": 30, "player_count_mean": 20,
+		"player_count_last": 20, "player_count_count": 3,
+		"latency_min": 10, "latency_max": 30, "latency_mean": 20, "latency_last": 20, "latency_count": 3,
+	}
+	for field, v := range want {
+		if a[0].Fields[field] != v {
+			t.Errorf("%s = %v, want %v", field, a[0].Fields[field], v)
+		}
+	}
+	if a[1].Fields["player_count_count"] != 1 || a[1].Fields["player_count_last"] != 40 {
+		t.Errorf("second window = %v", a[1].Fields)
+	}
+	if b := read("b"); len(b) != 1 || b[0].Fields["latency_max"] != 100 {
+		t.Errorf("rollups for b = %v", b)
+	}
+
+	// A tier query reads the rollups like raw points.
+	q := WindowQuery{
+		Measurement: "server_data",
+		Field:       RollupField(FieldPlayerCount, "max"),
+		Tag:         "id",
+		Values:      []string{"a"},
+		Start:       "-1d",
+		Step:        "1h",
+		Fn:          "max",
+		Tier:        tier.Name,
+	}
+	values, err := store.QueryWindows(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWindows(t, values, []WindowValue{{Series: "a", Time: minute.Add(time.Hour), Value: 40}})
+
+	// Means are weighted by the count of each rollup, (3*20 + 1*40) / 4,
+	// like the mean of the raw points.
+	q.Field = RollupField(FieldPlayerCount, "mean")
+	q.Weight = RollupField(FieldPlayerCount, "count")
+	q.Fn = "mean"
+	values, err = store.QueryWindows(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWindows(t, values, []WindowValue{{Series: "a", Time: minute.Add(time.Hour), Value: 25}})
+
+	q.Fn = "max"
+	if _, err := store.QueryWindows(context.Background(), q); err == nil {
+		t.Error("weighted max accepted")
+	}
+}
+
+func TestQueriesUseCoarsestCoveredTier(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+	now := time.Now()
+
+	setCoverage(t, map[string]RollupCoverage{})
+	query, err := BuildInfluxQueryForField("-30d", "2h", "a", FieldPlayerCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(query, `from(bucket: "minetracker_data")`) {
+		t.Errorf("query without rollups does not read the raw points:\n%s", query)
+	}
+
+	setCoverage(t, map[string]RollupCoverage{
+		"1m": {Since: now.Add(-60 * 24 * time.Hour), Until: now.Add(-time.Minute)},
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: now.Add(-time.Hour)},
+		"1d": {Since: now.Add(-24 * time.Hour), Until: now.Add(-time.Hour)},
+	})
+
+	cases := []struct {
+		start, step string
+		fn          string
+		wantTier    string
+		wantField   string
+	}{
+		{"-30d", "2h", "mean", "1h", "player_count_mean"},
+		{"-30d", "1d", "max", "1h", "player_count_max"}, // 1d is not covered back far enough
+		{"-1d", "1h", "count", "1h", "player_count_count"},
+		{"-1d", "30m", "mean", "1m", "player_count_mean"},
+		{"-1d", "90m", "mean", "1m", "player_count_mean"},   // not whole hours
+		{"-1d", "30s", "mean", "", "player_count"},          // finer than every tier
+		{"-1d", "1h", "median", "", "player_count"},         // no rollup statistic
+		{"-40d", "30m", "mean", "", "player_count"},         // beyond the 1m retention
+		{"-1y", "1M", "mean", "", "player_count"},           // not covered at all
+		{"1d) |> drop()", "1h", "mean", "", "player_count"}, // invalid, left to validation
+	}
+	for _, tc := range cases {
+		q := withRollupTier(WindowQuery{
+			Measurement: "server_data",
+			Field:       FieldPlayerCount,
+			Tag:         "id",
+			Values:      []string{"a"},
+			Start:       tc.start,
+			Step:        tc.step,
+			Fn:          tc.fn,
+		}, now)
+		if q.Tier != tc.wantTier || q.Field != tc.wantField {
+			t.Errorf("%s/%s %s: tier %q field %q, want %q %q", tc.start, tc.step, tc.fn, q.Tier, q.Field, tc.wantTier, tc.wantField)
+		}
+		if tc.fn == "count" && q.Fn != "sum" {
+			t.Errorf("counts are not summed on a tier: %q", q.Fn)
+		}
+		if wantWeight := tc.fn == "mean" && tc.wantTier != ""; wantWeight != (q.Weight == "player_count_count") {
+			t.Errorf("%s/%s %s: weight %q", tc.start, tc.step, tc.fn, q.Weight)
+		}
+	}
+
+	query, _, _, err = BuildInfluxQueryFromParams(QueryParams{Start: "-30d", ServerFilter: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(query, `from(bucket: "minetracker_data_1h")`) || !strings.Contains(query, `"player_count_mean"`) {
+		t.Errorf("params query does not read the 1h tier:\n%s", query)
+	}
+	for _, want := range []string{
+		`filter(fn: (r) => r["_field"] == "player_count_mean" or r["_field"] == "player_count_count")`,
+		`_value: float(v: r["player_count_mean"]), _weight: float(v: r["player_count_count"])`,
+		`sum: accumulator.sum + r._value * r._weight`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("tier mean is not weighted by the count, lacks %s:\n%s", want, query)
+		}
+	}
+
+	// A tier that stopped being rolled up is not used.
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: now.Add(-3 * time.Hour)},
+	})
+	if q := serverWindowQuery("-30d", "2h", "a", FieldPlayerCount, "mean"); q.Tier != "" {
+		t.Errorf("stale tier %q used", q.Tier)
+	}
+
+	t.Setenv("DOWNSAMPLING_ENABLED", "false")
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: now},
+	})
+	if q := serverWindowQuery("-30d", "2h", "a", FieldPlayerCount, "mean"); q.Tier != "" {
+		t.Errorf("tier %q used with downsampling disabled", q.Tier)
+	}
+}
+
+func TestTierGapReadsRawPoints(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	previous := TimeSeries
+	TimeSeries = store
+	defer func() { TimeSeries = previous }()
+
+	// The 1h tier is rolled up to until; now is half an hour later.
+	until := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	now := until.Add(30 * time.Minute)
+	write := func(measurement string, at time.Duration, fields map[string]interface{}) {
+		store.Write(SeriesPoint{
+			Measurement: measurement,
+			Tags:        map[string]string{"id": "a", "type": "PC", "reason": "timeout"},
+			Fields:      fields,
+			Time:        until.Add(at),
+		})
+	}
+	players := func(at time.Duration, n int) {
+		write("server_data", at, map[string]interface{}{"player_count": n})
+		write("ping_failure", at, map[string]interface{}{"count": 1})
+	}
+	players(-2*time.Hour+10*time.Minute, 10)
+	players(-time.Hour+10*time.Minute, 20)
+	if err := store.Rollup(context.Background(), RollupTiers[1], until.Add(-3*time.Hour), until); err != nil {
+		t.Fatal(err)
+	}
+	// Written behind the rollup, so only the raw points know it.
+	players(-time.Hour+20*time.Minute, 1000)
+	players(10*time.Minute, 40)
+	players(20*time.Minute, 60)
+
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: until},
+	})
+
+	q := withRollupTier(WindowQuery{
+		Measurement: "server_data",
+		Field:       FieldPlayerCount,
+		Tag:         "id",
+		Values:      []string{"a"},
+		From:        now.Add(-6 * time.Hour),
+		To:          now,
+		Step:        "1h",
+		Fn:          "mean",
+	}, now)
+	if q.Tier != "1h" || q.Rest == nil || !q.Rest.From.Equal(until) || q.Rest.Tier != "" || q.Rest.Field != FieldPlayerCount {
+		t.Fatalf("tier query %+v, rest %+v", q, q.Rest)
+	}
+	values, err := queryWindows(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWindows(t, values, []WindowValue{
+		{Series: "a", Time: until.Add(-time.Hour), Value: 10},
+		{Series: "a", Time: until, Value: 20},
+		{Series: "a", Time: now, Value: 50},
+	})
+
+	// Failures after until are counted from the raw points.
+	fq := withRollupTierAggregate(AggregateQuery{
+		Measurement: "ping_failure",
+		Field:       "count",
+		Tag:         "id",
+		Value:       "a",
+		Start:       "-30d",
+		GroupBy:     "reason",
+		Fn:          "sum",
+	}, now)
+	if fq.Tier != "1h" || fq.Rest == nil || !fq.Rest.From.Equal(until) || !fq.Rest.To.Equal(now) {
+		t.Fatalf("failure query %+v, rest %+v", fq, fq.Rest)
+	}
+	sums, err := queryAggregate(context.Background(), fq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums["timeout"] != 4 {
+		t.Errorf("failures = %v, want 4 timeouts", sums)
+	}
+
+	// The rest of a group query combines the members like the tier part.
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: time.Now().Add(-time.Minute).Truncate(time.Hour)},
+	})
+	gq, err := groupRangeQuery(HistoryRange{Start: "-1d"}, "1h", []string{"a", "b"}, FieldPlayerCount, "mean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gq.Tier != "1h" || gq.Rest == nil || len(gq.Rest.Values) != 2 || gq.Rest.Combine != "sum" {
+		t.Errorf("group tier query %+v, rest %+v", gq, gq.Rest)
+	}
+}
+
+func TestFailureBreakdownUsesTier(t *testing.T) {
+	now := time.Now()
+	setCoverage(t, map[string]RollupCoverage{
+		"1h": {Since: now.Add(-365 * 24 * time.Hour), Until: now},
+	})
+
+	if q := failureQuery("-1d", "a"); q.Tier != "" {
+		t.Errorf("1d range uses tier %q", q.Tier)
+	}
+	q := failureQuery("-30d", "a")
+	if q.Tier != "1h" || q.Field != "count_count" || q.Fn != "sum" {
+		t.Errorf("30d failure query = %+v", q)
+	}
+}
+
+func TestRollupFluxIsSafe(t *testing.T) {
+	from := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	baseline, err := buildRollupFlux("b", "org", RollupTiers[1], "server_data", "mean", from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`range(start: 2024-01-02T03:00:00Z, stop: 2024-01-02T04:00:00Z)`,
+		`aggregateWindow(every: 1h, fn: mean, timeSrc: "_start", createEmpty: false)`,
+		`r._field + "_mean"`,
+		`to(bucket: "b_1h", org: "org")`,
+	} {
+		if !strings.Contains(baseline, want) {
+			t.Errorf("rollup query lacks %s:\n%s", want, baseline)
+		}
+	}
+
+	for _, in := range hostileInputs {
+		if in == "" {
+			continue
+		}
+		query, err := buildRollupFlux(in, "org", RollupTiers[1], "server_data", "mean", from, from.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		assertSameStructure(t, baseline, query, in)
+	}
+
+	if _, err := buildRollupFlux("b", "org", RollupTiers[1], "server_data", "mean", from, from); err == nil {
+		t.Error("empty rollup range accepted")
+	}
+}
+
+func TestLocalRetention(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+
+	now := time.Now().UTC()
+	for _, ago := range []time.Duration{0, 40 * 24 * time.Hour} {
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": "a", "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": 1},
+			Time:        now.Add(-ago),
+		})
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tier := RollupTiers[0]
+	if err := store.Rollup(context.Background(), tier, now.Add(-41*24*time.Hour), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	countFiles := func(pattern string) int {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(matches)
+	}
+	if countFiles("server_data/*/*.seg") != 2 || countFiles("rollups/1m/server_data/*/*.seg") != 2 {
+		t.Fatal("segments were not written")
+	}
+
+	previous := rawRetention
+	rawRetention = 0
+	defer func() { rawRetention = previous }()
+
+	if err := store.ApplyRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := countFiles("server_data/*/*.seg"); got != 2 {
+		t.Errorf("raw points without retention were removed, %d segments left", got)
+	}
+	if got := countFiles("rollups/1m/server_data/*/*.seg"); got != 1 {
+		t.Errorf("got %d 1m segments, want the expired one removed", got)
+	}
+
+	rawRetention = 7 * 24 * time.Hour
+	if err := store.ApplyRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := countFiles("server_data/*/*.seg"); got != 1 {
+		t.Errorf("got %d raw segments, want the expired one removed", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "server_data")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Segment files hold the points of one series for one UTC day. Every flush
+// appends one gzip member with a block of points; gzip readers decode the
+// concatenated members as one stream. A block is:
+//
+//	uvarint  number of field names, then per name: uvarint length, bytes
+//	uvarint  number of points
+//	per point:
+//	  varint   nanoseconds since the previous point (the first: since 1970)
+//	  uvarint  bitmask of the fields present, in name order
+//	  float64  little endian value of every present field
+//
+// Only numeric fields are stored and a block holds at most 64 field names.
+const maxSegmentFields = 64
+
+// segmentPoint is one point of a series.
+type segmentPoint struct {
+	Time   int64 // Unix nanoseconds
+	Fields map[string]float64
+}
+
+// encodeSegmentBlock returns points as one compressed block.
+func encodeSegmentBlock(points []segmentPoint) ([]byte, error) {
+	nameSet := make(map[string]bool)
+	for _, p := range points {
+		for name := range p.Fields {
+			nameSet[name] = true
+		}
+	}
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxSegmentFields {
+		return nil, fmt.Errorf("series has %d fields, at most %d are supported", len(names), maxSegmentFields)
+	}
+
+	var raw bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) { raw.Write(buf[:binary.PutUvarint(buf, v)]) }
+	putVarint := func(v int64) { raw.Write(buf[:binary.PutVarint(buf, v)]) }
+
+	putUvarint(uint64(len(names)))
+	for _, name := range names {
+		putUvarint(uint64(len(name)))
+		raw.WriteString(name)
+	}
+
+	putUvarint(uint64(len(points)))
+	var prev int64
+	for _, p := range points {
+		putVarint(p.Time - prev)
+		prev = p.Time
+
+		var mask uint64
+		for i, name := range names {
+			if _, ok := p.Fields[name]; ok {
+				mask |= 1 << i
+			}
+		}
+		putUvarint(mask)
+		for i, name := range names {
+			if mask&(1<<i) != 0 {
+				binary.LittleEndian.PutUint64(buf, math.Float64bits(p.Fields[name]))
+				raw.Write(buf[:8])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// appendSegmentBlock appends points to a segment file in a single write.
+func appendSegmentBlock(path string, points []segmentPoint) error {
+	block, err := encodeSegmentBlock(points)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(block); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readSegmentFile decodes every complete block of a segment file. A block
+// cut short by a crash during a flush is ignored.
+func readSegmentFile(path string) ([]segmentPoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	r := bufio.NewReader(zr)
+	var points []segmentPoint
+	for {
+		block, err := readSegmentBlock(r)
+		if errors.Is(err, io.EOF) {
+			return points, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return points, nil
+		}
+		if err != nil {
+			return points, fmt.Errorf("%s: %w", path, err)
+		}
+		points = append(points, block...)
+	}
+}
+
+// readSegmentBlock decodes one block. It returns io.EOF when r is exhausted
+// at a block boundary.
+func readSegmentBlock(r *bufio.Reader) ([]segmentPoint, error) {
+	nNames, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if nNames > maxSegmentFields {
+		return nil, fmt.Errorf("corrupt block: %d fields", nNames)
+	}
+
+	unexpected := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	names := make([]string, nNames)
+	for i := range names {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if n > 1024 {
+			return nil, fmt.Errorf("corrupt block: field name of %d bytes", n)
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, unexpected(err)
+		}
+		names[i] = string(name)
+	}
+
+	nPoints, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	points := make([]segmentPoint, 0, min(nPoints, 1<<16))
+	var prev int64
+	var value [8]byte
+	for i := uint64(0); i < nPoints; i++ {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		mask, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+
+		p := segmentPoint{Time: prev + delta, Fields: make(map[string]float64, len(names))}
+		prev = p.Time
+		for j, name := range names {
+			if mask&(1<<j) == 0 {
+				continue
+			}
+			if _, err := io.ReadFull(r, value[:]); err != nil {
+				return nil, unexpected(err)
+			}
+			p.Fields[name] = math.Float64frombits(binary.LittleEndian.Uint64(value[:]))
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Server IDs are the stable primary key of a tracked server. They never
+// change when a server is renamed or moves to a new address, so its history,
+// cache entry and websocket subscriptions follow it. IDs may be chosen in
+// servers.json (e.g. "hypixel"); otherwise a random one is generated.
+var serverIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,63}$`)
+
+// NewServerID returns a random server ID.
+func NewServerID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "s" + hex.EncodeToString(b)
+}
+
+// reservedServerIDs are path segments of the API that an ID in the same
+// position would be mistaken for, e.g. /api/groups/... for /api/:server/:time.
+var reservedServerIDs = map[string]bool{
+	"admin": true, "api": true, "bulk": true, "debug": true, "errors": true,
+	"groups": true, "health": true, "keys": true, "metrics": true, "owner": true,
+	"servers": true, "status": true, "submissions": true, "version": true, "ws": true,
+}
+
+// ValidateServerID checks that id is a usable server ID.
+func ValidateServerID(id string) error {
+	if !serverIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid id %q: use 2-64 lowercase letters, digits, '-' or '_'", id)
+	}
+	if reservedServerIDs[id] {
+		return fmt.Errorf("invalid id %q: reserved for the API", id)
+	}
+	return nil
+}
+
+// NormalizeAlias turns an address into the form stored in the alias table.
+func NormalizeAlias(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
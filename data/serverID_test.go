@@ -0,0 +1,28 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateServerID(t *testing.T) {
+	for _, id := range []string{"hypixel", "s0a1b2c3d4e5f", "my-server_2", "ab", "groups-eu", "apis"} {
+		if err := ValidateServerID(id); err != nil {
+			t.Errorf("%q: %v", id, err)
+		}
+	}
+	for _, id := range []string{
+		"", "a", "Hypixel", "-lead", "_lead", "has space", "dot.ted", strings.Repeat("a", 65),
+		"groups", "errors", "bulk", "admin", "submissions", "keys", "servers", "ws", "version",
+	} {
+		if err := ValidateServerID(id); err == nil {
+			t.Errorf("%q accepted", id)
+		}
+	}
+}
+
+func TestNormalizeAlias(t *testing.T) {
+	if got := NormalizeAlias("  Play.Example.COM:25565 "); got != "play.example.com:25565" {
+		t.Errorf("NormalizeAlias = %q", got)
+	}
+}
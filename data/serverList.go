@@ -0,0 +1,201 @@
+package data
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sort keys accepted by ServerListQuery. A leading "-" sorts descending.
+const (
+	SortPlayers = "players"
+	SortPeak    = "peak"
+	SortName    = "name"
+)
+
+const maxServerListLimit = 500
+
+// ServerListQuery filters, sorts, pages and projects the server list served
+// by /api/servers.
+type ServerListQuery struct {
+	Tags   []string // every tag must be present
+	Type   string
+	Online *bool // nil matches both
+	Active *bool
+	Sort   string
+	Desc   bool
+	Limit  int // 0 = no limit
+	Offset int
+	Fields []string // JSON field names to keep, empty = all
+}
+
+// parseTriState parses "true"/"false", with "any" (or "all") matching both.
+func parseTriState(name, raw string, def *bool) (*bool, error) {
+	switch strings.ToLower(raw) {
+	case "":
+		return def, nil
+	case "any", "all":
+		return nil, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", name, raw)
+	}
+	return &v, nil
+}
+
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// ParseServerListQuery reads the /api/servers query parameters:
+//
+//	tag=pvp,survival  type=PC  online=true|false|any  active=true|false|any
+//	sort=players|-players|peak|-peak|name|-name  limit=50  offset=100
+//	fields=name,ip,player_count
+//
+// Without online/active only active, online servers are listed, as before.
+func ParseServerListQuery(values url.Values) (ServerListQuery, error) {
+	yes := true
+	q := ServerListQuery{
+		Tags:   splitList(values["tag"]),
+		Type:   values.Get("type"),
+		Sort:   SortName,
+		Fields: splitList(values["fields"]),
+	}
+
+	var err error
+	if q.Online, err = parseTriState("online", values.Get("online"), &yes); err != nil {
+		return q, err
+	}
+	if q.Active, err = parseTriState("active", values.Get("active"), &yes); err != nil {
+		return q, err
+	}
+
+	if q.Type != "" && !IsKnownServerType(q.Type) {
+		return q, fmt.Errorf("unknown type %q", q.Type)
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		q.Desc = strings.HasPrefix(sort, "-")
+		q.Sort = strings.TrimPrefix(sort, "-")
+		switch q.Sort {
+		case SortPlayers, SortPeak, SortName:
+		default:
+			return q, fmt.Errorf("invalid sort %q", sort)
+		}
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		q.Limit, err = strconv.Atoi(raw)
+		if err != nil || q.Limit < 1 || q.Limit > maxServerListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxServerListLimit)
+		}
+	}
+	if raw := values.Get("offset"); raw != "" {
+		q.Offset, err = strconv.Atoi(raw)
+		if err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("offset must not be negative")
+		}
+	}
+
+	return q, nil
+}
+
+func (q ServerListQuery) matches(s Server) bool {
+	if q.Online != nil && s.Online != *q.Online {
+		return false
+	}
+	if q.Active != nil && s.Active != *q.Active {
+		return false
+	}
+	if q.Type != "" && !strings.EqualFold(s.Type, q.Type) &&
+		!(IsBedrock(q.Type) && IsBedrock(s.Type)) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !s.Metadata.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q ServerListQuery) compare(a, b Server) int {
+	var c int
+	switch q.Sort {
+	case SortPlayers:
+		c = cmp.Compare(a.PlayerCount, b.PlayerCount)
+	case SortPeak:
+		c = cmp.Compare(a.Peak, b.Peak)
+	}
+	if c == 0 {
+		c = cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+	if c == 0 {
+		c = cmp.Compare(a.IP, b.IP)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// Apply filters and sorts servers and returns the requested page together
+// with the number of matching servers before paging.
+func (q ServerListQuery) Apply(servers []Server) ([]Server, int) {
+	matched := make([]Server, 0, len(servers))
+	for _, s := range servers {
+		if q.matches(s) {
+			matched = append(matched, s)
+		}
+	}
+	slices.SortFunc(matched, q.compare)
+
+	total := len(matched)
+	if q.Offset >= total {
+		return []Server{}, total
+	}
+	matched = matched[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total
+}
+
+// Project reduces each server to the requested JSON fields. Unknown field
+// names are ignored.
+func (q ServerListQuery) Project(servers []Server) ([]map[string]json.RawMessage, error) {
+	out := make([]map[string]json.RawMessage, 0, len(servers))
+	for _, s := range servers {
+		raw, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return nil, err
+		}
+
+		picked := make(map[string]json.RawMessage, len(q.Fields))
+		for _, f := range q.Fields {
+			if v, ok := all[f]; ok {
+				picked[f] = v
+			}
+		}
+		out = append(out, picked)
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestServerListQueryApply(t *testing.T) {
+	servers := []Server{
+		{Name: "Alpha", IP: "a.example", Type: ServerTypeJava, Online: true, Active: true, PlayerCount: 10, Peak: 50,
+			Metadata: ServerMetadata{Tags: []string{"PvP", "Survival"}}},
+		{Name: "bravo", IP: "b.example", Type: ServerTypeBedrock, Online: true, Active: true, PlayerCount: 30, Peak: 40,
+			Metadata: ServerMetadata{Tags: []string{"survival"}}},
+		{Name: "Charlie", IP: "c.example", Type: "BEDROCK", Online: false, Active: true, PlayerCount: 0, Peak: 90},
+		{Name: "Delta", IP: "d.example", Type: ServerTypeJava, Online: true, Active: false, PlayerCount: 20, Peak: 20},
+		{Name: "alpha", IP: "a2.example", Type: ServerTypeJava, Online: true, Active: true, PlayerCount: 10, Peak: 10},
+	}
+	addresses := func(list []Server) []string {
+		out := make([]string, len(list))
+		for i, s := range list {
+			out[i] = s.IP
+		}
+		return out
+	}
+
+	cases := []struct {
+		query     string
+		want      []string
+		wantTotal int
+	}{
+		// Only active, online servers by default, sorted by name then address.
+		{"", []string{"a.example", "a2.example", "b.example"}, 3},
+		{"online=any&active=any", []string{"a.example", "a2.example", "b.example", "c.example", "d.example"}, 5},
+		{"online=false", []string{"c.example"}, 1},
+		{"active=false", []string{"d.example"}, 1},
+		{"tag=survival", []string{"a.example", "b.example"}, 2},
+		{"tag=pvp,survival", []string{"a.example"}, 1},
+		{"tag=pvp&tag=creative", []string{}, 0},
+		{"type=PE&online=any", []string{"b.example", "c.example"}, 2},
+		{"type=PC", []string{"a.example", "a2.example"}, 2},
+		// Descending order reverses the name tie-break as well.
+		{"sort=-players", []string{"b.example", "a2.example", "a.example"}, 3},
+		{"sort=players", []string{"a.example", "a2.example", "b.example"}, 3},
+		{"sort=-peak&online=any&active=any", []string{"c.example", "a.example", "b.example", "d.example", "a2.example"}, 5},
+		{"sort=-name", []string{"b.example", "a2.example", "a.example"}, 3},
+		{"limit=2", []string{"a.example", "a2.example"}, 3},
+		{"limit=2&offset=2", []string{"b.example"}, 3},
+		{"offset=3", []string{}, 3},
+	}
+
+	for _, tc := range cases {
+		values, _ := url.ParseQuery(tc.query)
+		q, err := ParseServerListQuery(values)
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+		got, total := q.Apply(servers)
+		if !slices.Equal(addresses(got), tc.want) || total != tc.wantTotal {
+			t.Errorf("%q: got %v (total %d), want %v (total %d)", tc.query, addresses(got), total, tc.want, tc.wantTotal)
+		}
+	}
+}
+
+func TestParseServerListQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"online=maybe",
+		"active=2",
+		"type=console",
+		"sort=latency",
+		"sort=--name",
+		"limit=0",
+		"limit=501",
+		"limit=ten",
+		"offset=-1",
+	} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseServerListQuery(values); err == nil {
+			t.Errorf("%q accepted", query)
+		}
+	}
+}
+
+func TestServerListQueryProject(t *testing.T) {
+	q := ServerListQuery{Fields: []string{"name", "player_count", "unknown"}}
+	out, err := q.Project([]Server{{Name: "Alpha", IP: "a.example", PlayerCount: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || len(out[0]) != 2 || string(out[0]["name"]) != `"Alpha"` || string(out[0]["player_count"]) != "3" {
+		t.Errorf("projected %v", out)
+	}
+}
@@ -1,30 +1,206 @@
 package data
 
 import (
-	"MineTracker/database"
 	"context"
-	"encoding/json"
 	"fmt"
-	"math"
-	"os"
+	"reflect"
+	"strings"
 )
 
+// Server types accepted in servers.json. Java Edition servers keep the
+// historical "PC" value; Bedrock servers may use either "PE" or "BEDROCK".
+const (
+	ServerTypeJava    = "PC"
+	ServerTypeBedrock = "PE"
+)
+
+// IsBedrock reports whether a server type refers to a Bedrock Edition server.
+func IsBedrock(serverType string) bool {
+	return strings.EqualFold(serverType, ServerTypeBedrock) || strings.EqualFold(serverType, "BEDROCK")
+}
+
 type PingableServer struct {
+	// ID is the stable identifier, assigned on import when left out.
+	ID       string `json:"id,omitempty"`
 	Name     string `json:"name"`
 	IP       string `json:"ip"`
 	Type     string `json:"type"`
 	Interval int    `json:"interval,omitempty"`
+
+	// Tier selects a priority tier from the ping policy (e.g. "featured"),
+	// which sets this server's default interval.
+	Tier string `json:"tier,omitempty"`
+
+	// Query enables the GameSpy4 UDP query (server.properties enable-query)
+	// for the full player list and plugins. QueryPort defaults to the game port.
+	Query     bool `json:"query,omitempty"`
+	QueryPort int  `json:"query_port,omitempty"`
+
+	// Optional ping overrides. ConnectAddress replaces the address (and skips
+	// SRV) used to open the connection, HandshakeHost replaces the hostname
+	// sent in the handshake, TimeoutMs replaces the 2s ping timeout and
+	// Protocol the protocol version announced in the handshake.
+	ConnectAddress string `json:"connect_address,omitempty"`
+	HandshakeHost  string `json:"handshake_host,omitempty"`
+	TimeoutMs      int    `json:"timeout_ms,omitempty"`
+	Protocol       int    `json:"protocol,omitempty"`
+
+	// Source records where a tracked server came from: ServerSourceFile for
+	// entries imported from servers.json, ServerSourceAPI for admin created
+	// ones and ServerSourceSubmission for approved public submissions.
+	Source string `json:"source,omitempty"`
+
+	Metadata ServerMetadata `json:"metadata"`
+}
+
+const (
+	ServerSourceFile       = "file"
+	ServerSourceAPI        = "api"
+	ServerSourceSubmission = "submission"
+)
+
+// Equal reports whether both definitions are identical.
+func (s PingableServer) Equal(o PingableServer) bool {
+	if !s.Metadata.Equal(o.Metadata) {
+		return false
+	}
+	// Tags already compared; an empty list decoded from MongoDB must not
+	// differ from a missing one in servers.json.
+	s.Metadata.Tags, o.Metadata.Tags = nil, nil
+	return reflect.DeepEqual(s, o)
+}
+
+// IsKnownServerType reports whether serverType is a supported edition.
+func IsKnownServerType(serverType string) bool {
+	return serverType == ServerTypeJava || IsBedrock(serverType)
+}
+
+// Bounds of a per-server interval in seconds and of timeout_ms. Zero leaves
+// both to the defaults.
+const (
+	MinServerInterval = 1
+	MaxServerInterval = 24 * 60 * 60
+	MaxTimeoutMs      = 60000
+)
+
+// DefaultPort returns the port used when a server address has none.
+func DefaultPort(serverType string) uint16 {
+	if IsBedrock(serverType) {
+		return 19132
+	}
+	return 25565
+}
+
+// fieldError is a validation problem of one JSON field of a definition.
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+// Validate checks a server definition before it is tracked.
+func (s PingableServer) Validate() error {
+	if errs := s.fieldErrors(); len(errs) > 0 {
+		return errs[0].Err
+	}
+	return nil
+}
+
+// fieldErrors returns every problem of the definition, in field order.
+func (s PingableServer) fieldErrors() []fieldError {
+	var errs []fieldError
+	add := func(field string, err error) {
+		errs = append(errs, fieldError{Field: field, Err: err})
+	}
+
+	if s.ID != "" {
+		if err := ValidateServerID(s.ID); err != nil {
+			add("id", err)
+		}
+	}
+	if strings.TrimSpace(s.Name) == "" {
+		add("name", fmt.Errorf("missing name"))
+	}
+	if _, err := ParseAddress(s.IP); err != nil {
+		add("ip", err)
+	}
+	if !IsKnownServerType(s.Type) {
+		add("type", fmt.Errorf("unknown type %q, use %q or %q", s.Type, ServerTypeJava, ServerTypeBedrock))
+	}
+	if s.Interval != 0 && (s.Interval < MinServerInterval || s.Interval > MaxServerInterval) {
+		add("interval", fmt.Errorf("interval must be between %d and %d seconds", MinServerInterval, MaxServerInterval))
+	}
+	if s.QueryPort < 0 || s.QueryPort > 65535 {
+		add("query_port", fmt.Errorf("invalid query_port %d", s.QueryPort))
+	}
+	if s.ConnectAddress != "" {
+		if _, err := ParseAddress(s.ConnectAddress); err != nil {
+			add("connect_address", fmt.Errorf("connect_address: %w", err))
+		}
+	}
+	if s.TimeoutMs < 0 || s.TimeoutMs > MaxTimeoutMs {
+		add("timeout_ms", fmt.Errorf("timeout_ms must be between 0 and %d", MaxTimeoutMs))
+	}
+	if s.Protocol < 0 {
+		add("protocol", fmt.Errorf("protocol must not be negative"))
+	}
+	if err := s.Metadata.Validate(); err != nil {
+		add("metadata", err)
+	}
+	return errs
 }
 
 type Server struct {
-	Name        string `json:"name"`
-	IP          string `json:"ip"`
-	Icon        string `json:"icon,omitempty"`
-	Type        string `json:"type"`
-	Online      bool   `json:"online"`
-	PlayerCount int    `json:"player_count"`
-	Peak        int    `json:"peak"`
-	Active      bool   `json:"active"`
+	ID                 string           `json:"id"`
+	Name               string           `json:"name"`
+	IP                 string           `json:"ip"`
+	Icon               string           `json:"icon,omitempty"`
+	Type               string           `json:"type"`
+	Online             bool             `json:"online"`
+	PlayerCount        int              `json:"player_count"`
+	MaxPlayers         int              `json:"max_players"`
+	Peak               int              `json:"peak"`
+	Active             bool             `json:"active"`
+	Version            string           `json:"version,omitempty"`
+	Protocol           int              `json:"protocol,omitempty"`
+	MOTD               string           `json:"motd,omitempty"`
+	GameMode           string           `json:"game_mode,omitempty"`
+	Sample             []PlayerSample   `json:"player_sample,omitempty"`
+	EnforcesSecureChat bool             `json:"enforces_secure_chat"`
+	ModLoader          string           `json:"mod_loader,omitempty"`
+	Mods               []ModInfo        `json:"mods,omitempty"`
+	Latency            int              `json:"latency"`
+	Players            []string         `json:"players,omitempty"`
+	Plugins            []string         `json:"plugins,omitempty"`
+	Map                string           `json:"map,omitempty"`
+	Software           string           `json:"software,omitempty"`
+	ResolvedAddress    string           `json:"resolved_address,omitempty"`
+	ResolvedAddresses  []string         `json:"resolved_addresses,omitempty"`
+	State              string           `json:"state"`
+	LastError          string           `json:"last_error,omitempty"`
+	LastErrorReason    string           `json:"last_error_reason,omitempty"`
+	LastErrorAt        int64            `json:"last_error_at,omitempty"`
+	FailureCounts      map[string]int64 `json:"failure_counts,omitempty"`
+	Metadata           ServerMetadata   `json:"metadata"`
+}
+
+// Health states of a tracked server. Online is true for online and suspect.
+const (
+	ServerStateOnline     = "online"
+	ServerStateSuspect    = "suspect"
+	ServerStateOffline    = "offline"
+	ServerStateRecovering = "recovering"
+)
+
+// PlayerSample is one entry of the player sample a server lists in its status.
+type PlayerSample struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// ModInfo describes a mod advertised by a modded (Forge) server.
+type ModInfo struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
 }
 
 type ExtendedServer struct {
@@ -38,72 +214,87 @@ type ExtendedServer struct {
 }
 
 type ServerDataPoint struct {
+	ID          string `json:"id"`
 	Timestamp   int64  `json:"timestamp"`
 	PlayerCount int    `json:"player_count"`
+	Latency     int    `json:"latency,omitempty"`
 	Ip          string `json:"ip"`
 	Name        string `json:"name"`
+	// Aggregates holds every aggregate requested with agg, e.g.
+	// {"min": 3, "mean": 10.4, "max": 21}.
+	Aggregates map[string]float64 `json:"aggregates,omitempty"`
+}
+
+// History fields that can be queried through the dated data endpoints.
+const (
+	FieldPlayerCount = "player_count"
+	FieldLatency     = "latency"
+)
+
+// IsHistoryField reports whether field is a queryable server_data field.
+func IsHistoryField(field string) bool {
+	return field == FieldPlayerCount || field == FieldLatency
 }
 
+// LoadServers reads the server list from path. See LoadServersFile for the
+// accepted formats.
 func LoadServers(path string) ([]PingableServer, error) {
-	data, err := os.ReadFile(path)
+	file, err := LoadServersFile(path)
 	if err != nil {
 		return nil, err
 	}
-
-	var servers []PingableServer
-	if err := json.Unmarshal(data, &servers); err != nil {
-		return nil, err
-	}
-
-	return servers, nil
+	return file.Servers, nil
 }
 
-func QueryDataPoints(ip string, duration string) ([]ServerDataPoint, string, error) {
-	queryApi := database.InfluxClient.QueryAPI(os.Getenv("INFLUXDB_ORG"))
+// QueryDataPoints returns the windowed history of field for a server ID,
+// aggregated per window with each of aggs, or the mean when aggs is empty,
+// over the range r.
+// The first aggregate of the player_count field ends up in PlayerCount,
+// of latency in Latency, and explicit aggs in Aggregates. Ip and Name are
+// not stored in the series and are left for the caller to fill in from the
+// live server state.
+func QueryDataPoints(id string, r HistoryRange, field string, aggs []string) ([]ServerDataPoint, string, error) {
+	if field == "" {
+		field = FieldPlayerCount
+	}
+	if !IsHistoryField(field) {
+		return nil, "0m", fmt.Errorf("%w: invalid field: %s", ErrInvalidQuery, field)
+	}
 
-	query, _, step, err := BuildInfluxQueryFromParams(QueryParams{
-		Start:         duration,
-		ServerFilter:  ip,
+	step, _, err := ResolveStep(QueryParams{
+		Start:         r.Start,
+		From:          r.From,
+		To:            r.To,
+		Location:      r.Location,
 		MaxDataPoints: 500,
 		MinDataPoints: 10,
 		UseAdaptive:   false,
 	})
-
 	if err != nil {
 		return nil, "0m", fmt.Errorf("failed to build query: %w", err)
 	}
 
-	result, err := queryApi.Query(context.Background(), query)
+	explicit := len(aggs) > 0
+	if !explicit {
+		aggs = []string{"mean"}
+	}
 
+	windows, err := queryWindowAggregates(context.Background(), func(fn string) (WindowQuery, error) {
+		return rawServerRangeQuery(r, step, id, field, fn), nil
+	}, aggs)
 	if err != nil {
-		return nil, "0m", fmt.Errorf("query execution failed: %w", err)
+		return nil, "0m", err
 	}
 
 	var dataPoints []ServerDataPoint
-
-	for result.Next() {
-		record := result.Record()
-		if record == nil {
+	for _, w := range windows {
+		if id != "" && w.Series != id {
 			continue
 		}
-
-		dataPoint := ServerDataPoint{
-			Timestamp:   record.Time().Unix(),
-			PlayerCount: int(math.Round(record.Value().(float64))),
-			Ip:          record.ValueByKey("ip").(string),
-			Name:        record.ValueByKey("name").(string),
-		}
-
-		if ip == "" || dataPoint.Ip == ip {
-			dataPoints = append(dataPoints, dataPoint)
+		if p, ok := newDataPoint(w.Series, field, w, aggs, explicit); ok {
+			dataPoints = append(dataPoints, p)
 		}
 	}
 
-	if result.Err() != nil {
-		return nil, "0m", fmt.Errorf("result error: %w", result.Err())
-	}
-
-	_ = result.Close()
-
 	return dataPoints, step, nil
 }
@@ -0,0 +1,467 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ServersFile is the content of servers.json. The file is either a plain
+// array of servers or an object that may also define groups:
+//
+//	{"servers": [...], "groups": [{"id": "...", "name": "...", "members": [...]}]}
+type ServersFile struct {
+	Servers []PingableServer `json:"servers"`
+	Groups  []ServerGroup    `json:"groups,omitempty"`
+
+	// Warnings are problems that do not stop the file from loading, such as
+	// unknown fields.
+	Warnings []ServersFileIssue `json:"-"`
+
+	serverLines []int
+}
+
+// ServerLine returns the line the i-th server is defined on, or 0.
+func (f ServersFile) ServerLine(i int) int {
+	if i < 0 || i >= len(f.serverLines) {
+		return 0
+	}
+	return f.serverLines[i]
+}
+
+// ServersFileIssue is a problem found in servers.json, located by line and
+// column (both 1-based) of the offending field or entry.
+type ServersFileIssue struct {
+	Line    int
+	Column  int
+	Entry   string // e.g. "servers[3] (Hypixel)", empty for the file itself
+	Warning bool
+	Message string
+}
+
+func (i ServersFileIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.Entry == "" {
+		return fmt.Sprintf("%d:%d: %s: %s", i.Line, i.Column, level, i.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s: %s", i.Line, i.Column, level, i.Entry, i.Message)
+}
+
+// ServersFileError is returned by LoadServersFile when the file has errors.
+type ServersFileError struct {
+	Path   string
+	Issues []ServersFileIssue
+}
+
+func (e *ServersFileError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = e.Path + ":" + issue.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadServersFile reads and validates servers.json in either format. Any
+// error in the file rejects all of it; see CheckServersFile.
+func LoadServersFile(path string) (ServersFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return ServersFile{}, err
+	}
+
+	file, issues := CheckServersFile(raw)
+
+	var errs []ServersFileIssue
+	for _, issue := range issues {
+		if issue.Warning {
+			file.Warnings = append(file.Warnings, issue)
+		} else {
+			errs = append(errs, issue)
+		}
+	}
+	if len(errs) > 0 {
+		return ServersFile{}, &ServersFileError{Path: path, Issues: errs}
+	}
+	return file, nil
+}
+
+// fileEntry is one element of the servers or groups array with the offsets
+// of the entry and of each of its keys.
+type fileEntry struct {
+	raw    json.RawMessage
+	offset int
+	keys   map[string]int
+}
+
+// CheckServersFile parses and validates the content of servers.json. Next to
+// the checks of PingableServer.Validate and ServerGroup.Validate it reports
+// duplicate IDs and addresses, where addresses are compared case-insensitively
+// with the default port filled in, and warns about unknown fields and
+// duplicate names. Issues are sorted by position.
+func CheckServersFile(raw []byte) (ServersFile, []ServersFileIssue) {
+	c := &fileChecker{raw: raw}
+
+	servers, groups, ok := c.split()
+	if !ok {
+		return ServersFile{}, c.issues
+	}
+
+	var file ServersFile
+	c.checkServers(servers, &file)
+	c.checkGroups(groups, &file)
+
+	sort.SliceStable(c.issues, func(i, j int) bool {
+		a, b := c.issues[i], c.issues[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return file, c.issues
+}
+
+type fileChecker struct {
+	raw    []byte
+	issues []ServersFileIssue
+}
+
+// position converts a byte offset into a line and column.
+func (c *fileChecker) position(offset int) (int, int) {
+	if offset > len(c.raw) {
+		offset = len(c.raw)
+	}
+	before := c.raw[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+func (c *fileChecker) report(offset int, entry string, warning bool, format string, args ...interface{}) {
+	line, column := c.position(offset)
+	c.issues = append(c.issues, ServersFileIssue{
+		Line:    line,
+		Column:  column,
+		Entry:   entry,
+		Warning: warning,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// skipSpace returns the offset of the next value after offset, skipping
+// whitespace and the separators the decoder has not consumed yet.
+func skipSpace(raw []byte, offset int) int {
+	for offset < len(raw) {
+		switch raw[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// decodeError reports a JSON decoding error at the position it occurred, or
+// at the key of the offending field when keys of the entry are known.
+func (c *fileChecker) decodeError(dec *json.Decoder, base int, keys map[string]int, entry string, err error) {
+	offset := base + int(dec.InputOffset())
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = base + int(syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		offset = base + int(typeErr.Offset)
+		if at, ok := keys[typeErr.Field]; ok {
+			offset = at
+		}
+		if typeErr.Field != "" {
+			err = fmt.Errorf("%s must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		offset = len(c.raw)
+		err = fmt.Errorf("unexpected end of file")
+	}
+	c.report(offset, entry, false, "%v", err)
+}
+
+// split locates the servers and groups arrays and their entries.
+func (c *fileChecker) split() ([]fileEntry, []fileEntry, bool) {
+	if len(bytes.TrimSpace(c.raw)) == 0 {
+		c.report(0, "", false, "file is empty")
+		return nil, nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(c.raw))
+	tok, err := dec.Token()
+	if err != nil {
+		c.decodeError(dec, 0, nil, "", err)
+		return nil, nil, false
+	}
+
+	var servers, groups []fileEntry
+	switch tok {
+	case json.Delim('['):
+		if servers, err = c.readArray(dec); err != nil {
+			return nil, nil, false
+		}
+
+	case json.Delim('{'):
+		for dec.More() {
+			keyOffset := skipSpace(c.raw, int(dec.InputOffset()))
+			key, err := dec.Token()
+			if err != nil {
+				c.decodeError(dec, 0, nil, "", err)
+				return nil, nil, false
+			}
+
+			switch key {
+			case "servers", "groups":
+				start := skipSpace(c.raw, int(dec.InputOffset()))
+				if tok, err := dec.Token(); err != nil {
+					c.decodeError(dec, 0, nil, "", err)
+					return nil, nil, false
+				} else if tok != json.Delim('[') {
+					c.report(start, "", false, "%s must be an array", key)
+					return nil, nil, false
+				}
+				entries, err := c.readArray(dec)
+				if err != nil {
+					return nil, nil, false
+				}
+				if key == "servers" {
+					servers = entries
+				} else {
+					groups = entries
+				}
+
+			default:
+				c.report(keyOffset, "", true, "unknown field %q", key)
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					c.decodeError(dec, 0, nil, "", err)
+					return nil, nil, false
+				}
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			c.decodeError(dec, 0, nil, "", err)
+			return nil, nil, false
+		}
+
+	default:
+		c.report(0, "", false, "expected an array of servers or an object with a servers array")
+		return nil, nil, false
+	}
+
+	if dec.More() {
+		c.report(skipSpace(c.raw, int(dec.InputOffset())), "", false, "unexpected data after the end of the list")
+		return nil, nil, false
+	}
+	return servers, groups, true
+}
+
+// readArray reads the entries of an array whose '[' was just consumed.
+func (c *fileChecker) readArray(dec *json.Decoder) ([]fileEntry, error) {
+	var entries []fileEntry
+	for dec.More() {
+		offset := skipSpace(c.raw, int(dec.InputOffset()))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			c.decodeError(dec, 0, nil, "", err)
+			return nil, err
+		}
+		entries = append(entries, fileEntry{raw: raw, offset: offset, keys: objectKeys(raw, offset)})
+	}
+	if _, err := dec.Token(); err != nil {
+		c.decodeError(dec, 0, nil, "", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// objectKeys returns the offset of every top-level key of a JSON object.
+func objectKeys(raw json.RawMessage, base int) map[string]int {
+	keys := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return keys
+	}
+	for dec.More() {
+		offset := base + skipSpace(raw, int(dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := tok.(string)
+		keys[key] = offset
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
+
+// at returns the offset of key within e, falling back to the entry itself.
+func (e fileEntry) at(key string) int {
+	if offset, ok := e.keys[key]; ok {
+		return offset
+	}
+	return e.offset
+}
+
+// jsonFields returns the JSON names of the fields of a struct type.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+var (
+	serverFields = jsonFields(reflect.TypeOf(PingableServer{}))
+	groupFields  = jsonFields(reflect.TypeOf(ServerGroup{}))
+)
+
+// serverAddressKey normalises an address for duplicate detection: the host
+// is lowercased without a trailing dot and the default port of the edition
+// is filled in. Java and Bedrock servers on the same host:port are distinct.
+func serverAddressKey(s PingableServer) (string, bool) {
+	addr, err := ParseAddress(s.IP)
+	if err != nil {
+		return "", false
+	}
+	addr.Host = strings.TrimSuffix(strings.ToLower(addr.Host), ".")
+	if addr.Port == 0 {
+		addr.Port = DefaultPort(s.Type)
+	}
+	edition := "java"
+	if IsBedrock(s.Type) {
+		edition = "bedrock"
+	}
+	return edition + "/" + addr.String(), true
+}
+
+func (c *fileChecker) checkServers(entries []fileEntry, file *ServersFile) {
+	ids := make(map[string]int)
+	addresses := make(map[string]int)
+	names := make(map[string]int)
+
+	for i, e := range entries {
+		label := fmt.Sprintf("servers[%d]", i)
+
+		var s PingableServer
+		dec := json.NewDecoder(bytes.NewReader(e.raw))
+		if err := dec.Decode(&s); err != nil {
+			c.decodeError(dec, e.offset, e.keys, label, err)
+			continue
+		}
+		if s.Name != "" {
+			label = fmt.Sprintf("servers[%d] (%s)", i, s.Name)
+		}
+
+		for key := range e.keys {
+			if !serverFields[key] {
+				c.report(e.keys[key], label, true, "unknown field %q", key)
+			}
+		}
+
+		errs := s.fieldErrors()
+		for _, fe := range errs {
+			c.report(e.at(fe.Field), label, false, "%v", fe.Err)
+		}
+
+		line, _ := c.position(e.offset)
+		if s.ID != "" {
+			if first, ok := ids[s.ID]; ok {
+				c.report(e.at("id"), label, false, "duplicate id %q, already used on line %d", s.ID, first)
+			} else {
+				ids[s.ID] = line
+			}
+		}
+		if key, ok := serverAddressKey(s); ok {
+			if first, ok := addresses[key]; ok {
+				c.report(e.at("ip"), label, false, "duplicate address %q, the same server is already defined on line %d", s.IP, first)
+			} else {
+				addresses[key] = line
+			}
+		}
+		if name := strings.ToLower(strings.TrimSpace(s.Name)); name != "" {
+			if first, ok := names[name]; ok {
+				c.report(e.at("name"), label, true, "name %q is already used on line %d", s.Name, first)
+			} else {
+				names[name] = line
+			}
+		}
+
+		if len(errs) == 0 {
+			file.Servers = append(file.Servers, s)
+			file.serverLines = append(file.serverLines, line)
+		}
+	}
+}
+
+func (c *fileChecker) checkGroups(entries []fileEntry, file *ServersFile) {
+	known := make(map[string]bool, len(file.Servers)*2)
+	for _, s := range file.Servers {
+		if s.ID != "" {
+			known[s.ID] = true
+		}
+		known[NormalizeAlias(s.IP)] = true
+	}
+
+	ids := make(map[string]int)
+	for i, e := range entries {
+		label := fmt.Sprintf("groups[%d]", i)
+
+		var g ServerGroup
+		dec := json.NewDecoder(bytes.NewReader(e.raw))
+		if err := dec.Decode(&g); err != nil {
+			c.decodeError(dec, e.offset, e.keys, label, err)
+			continue
+		}
+		if g.Name != "" {
+			label = fmt.Sprintf("groups[%d] (%s)", i, g.Name)
+		}
+
+		for key := range e.keys {
+			if !groupFields[key] {
+				c.report(e.keys[key], label, true, "unknown field %q", key)
+			}
+		}
+
+		if err := g.Validate(); err != nil {
+			c.report(e.offset, label, false, "%v", err)
+			continue
+		}
+
+		line, _ := c.position(e.offset)
+		if first, ok := ids[g.ID]; ok {
+			c.report(e.at("id"), label, false, "duplicate id %q, already used on line %d", g.ID, first)
+			continue
+		}
+		ids[g.ID] = line
+
+		for _, m := range g.Members {
+			if !known[m] && !known[NormalizeAlias(m)] {
+				c.report(e.at("members"), label, true, "member %q is not defined in this file and must already be tracked", m)
+			}
+		}
+		file.Groups = append(file.Groups, g)
+	}
+}
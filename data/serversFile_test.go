@@ -0,0 +1,134 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckServersFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		issues  []string // ServersFileIssue.String() of every issue, in order
+		servers int
+		groups  int
+	}{
+		{
+			name:    "plain array",
+			file:    `[{"name": "A", "ip": "a.example", "type": "PC"}, {"name": "B", "ip": "b.example", "type": "PE"}]`,
+			servers: 2,
+		},
+		{
+			name: "object with groups",
+			file: `{
+  "servers": [
+    {"id": "alpha", "name": "A", "ip": "a.example", "type": "PC"}
+  ],
+  "groups": [
+    {"id": "network", "name": "Network", "members": ["alpha", "A.Example", "b.example"]}
+  ]
+}`,
+			issues:  []string{`6:42: warning: groups[0] (Network): member "b.example" is not defined in this file and must already be tracked`},
+			servers: 1,
+			groups:  1,
+		},
+		{
+			name: "field errors point at the key",
+			file: `[
+  {"name": "A", "ip": "a.example:0", "type": "PC"},
+  {"name": "", "ip": "b.example", "type": "XBOX"}
+]`,
+			issues: []string{
+				`2:17: error: servers[0] (A): invalid address "a.example:0": invalid port "0"`,
+				`3:4: error: servers[1]: missing name`,
+				`3:35: error: servers[1]: unknown type "XBOX", use "PC" or "PE"`,
+			},
+		},
+		{
+			name: "duplicates",
+			file: `[
+  {"id": "alpha", "name": "A", "ip": "Play.Example.com", "type": "PC"},
+  {"id": "alpha", "name": "a", "ip": "play.example.com.:25565", "type": "PC"},
+  {"name": "C", "ip": "play.example.com", "type": "PE"}
+]`,
+			issues: []string{
+				`3:4: error: servers[1] (a): duplicate id "alpha", already used on line 2`,
+				`3:19: warning: servers[1] (a): name "a" is already used on line 2`,
+				`3:32: error: servers[1] (a): duplicate address "play.example.com.:25565", the same server is already defined on line 2`,
+			},
+			servers: 3,
+		},
+		{
+			name:    "unknown fields",
+			file:    `{"servers": [{"name": "A", "ip": "a.example", "type": "PC", "intervall": 5}], "version": 2}`,
+			issues:  []string{`1:61: warning: servers[0] (A): unknown field "intervall"`, `1:79: warning: unknown field "version"`},
+			servers: 1,
+		},
+		{
+			name:   "wrong type",
+			file:   `[{"name": "A", "ip": "a.example", "type": "PC", "interval": "5s"}]`,
+			issues: []string{`1:49: error: servers[0]: interval must be int, not string`},
+		},
+		{
+			name:   "syntax error",
+			file:   "[\n  {\"name\": \"A\",, \"ip\": \"a.example\"}\n]",
+			issues: []string{`2:17: error: invalid character ',' looking for beginning of object key string`},
+		},
+		{name: "empty", file: " \n", issues: []string{`1:1: error: file is empty`}},
+		{name: "truncated", file: `[{"name": "A"`, issues: []string{`1:14: error: unexpected end of file`}},
+		{name: "scalar", file: `"servers"`, issues: []string{`1:1: error: expected an array of servers or an object with a servers array`}},
+		{name: "servers not an array", file: `{"servers": {}}`, issues: []string{`1:13: error: servers must be an array`}},
+		{name: "trailing data", file: `[] []`, issues: []string{`1:4: error: unexpected data after the end of the list`}},
+		{
+			name:   "invalid group",
+			file:   `{"servers": [], "groups": [{"id": "groups", "name": "G", "members": ["a"]}]}`,
+			issues: []string{`1:28: error: groups[0] (G): invalid id "groups": reserved for the API`},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, issues := CheckServersFile([]byte(tc.file))
+
+			got := make([]string, len(issues))
+			for i, issue := range issues {
+				got[i] = issue.String()
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.issues, "\n") {
+				t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.issues, "\n"))
+			}
+			if len(file.Servers) != tc.servers || len(file.Groups) != tc.groups {
+				t.Errorf("%d servers, %d groups, want %d and %d", len(file.Servers), len(file.Groups), tc.servers, tc.groups)
+			}
+		})
+	}
+}
+
+func TestLoadServersFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// Warnings are kept with the file, the server lines with each server.
+	file, err := LoadServersFile(write("ok.json", "[\n  {\"name\": \"A\", \"ip\": \"a.example\", \"type\": \"PC\", \"extra\": 1}\n]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Servers) != 1 || len(file.Warnings) != 1 || file.ServerLine(0) != 2 || file.ServerLine(1) != 0 {
+		t.Errorf("loaded %+v", file)
+	}
+
+	// One error rejects the whole file.
+	path := write("bad.json", `[{"name": "A", "ip": "a.example", "type": "PC"}, {"name": "B", "type": "PC"}]`)
+	_, err = LoadServersFile(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+":1:") {
+		t.Errorf("got %v, want an error located in %s", err, path)
+	}
+}
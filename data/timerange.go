@@ -0,0 +1,232 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryRange is the range of a history query: either relative to now,
+// like "-7d", or absolute from From to To. Location aligns day, week, month
+// and year windows to its local midnight; nil aligns them to UTC.
+type HistoryRange struct {
+	Start    string
+	From, To time.Time
+	Location *time.Location
+}
+
+// RelativeRange returns the range of the last start, e.g. "-1d", in UTC.
+func RelativeRange(start string) HistoryRange {
+	return HistoryRange{Start: start}
+}
+
+// ParseTimestamp parses an RFC 3339 timestamp or Unix seconds.
+func ParseTimestamp(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, use RFC 3339 or Unix seconds", s)
+	}
+	return t, nil
+}
+
+// ParseHistoryRange builds the range of a history request. When from is set
+// the range is [from, to), with to defaulting to now, and start is ignored;
+// otherwise it is the relative start. tz is an IANA zone like
+// "Europe/Berlin", empty for UTC.
+func ParseHistoryRange(start, from, to, tz string) (HistoryRange, error) {
+	r := HistoryRange{Start: start}
+
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return HistoryRange{}, fmt.Errorf("%w: unknown time zone %q", ErrInvalidQuery, tz)
+		}
+		r.Location = loc
+	}
+
+	if from == "" {
+		if to != "" {
+			return HistoryRange{}, fmt.Errorf("%w: to needs from", ErrInvalidQuery)
+		}
+		if _, err := ParseFluxDuration(start); err != nil {
+			return HistoryRange{}, fmt.Errorf("%w: invalid range: %v", ErrInvalidQuery, err)
+		}
+		return r, nil
+	}
+
+	var err error
+	if r.From, err = ParseTimestamp(from); err != nil {
+		return HistoryRange{}, fmt.Errorf("%w: from: %v", ErrInvalidQuery, err)
+	}
+	r.To = time.Now()
+	if to != "" {
+		if r.To, err = ParseTimestamp(to); err != nil {
+			return HistoryRange{}, fmt.Errorf("%w: to: %v", ErrInvalidQuery, err)
+		}
+	}
+	r.Start = ""
+	if err := r.validate(); err != nil {
+		return HistoryRange{}, err
+	}
+	return r, nil
+}
+
+// Absolute reports whether the range has fixed bounds.
+func (r HistoryRange) Absolute() bool {
+	return !r.From.IsZero()
+}
+
+// validate checks the bounds of an absolute range or the duration of a
+// relative one.
+func (r HistoryRange) validate() error {
+	if !r.Absolute() {
+		d, err := ParseFluxDuration(r.Start)
+		if err != nil {
+			return fmt.Errorf("%w: invalid range: %v", ErrInvalidQuery, err)
+		}
+		if d.Approx() > MaxQueryRange {
+			return fmt.Errorf("%w: range %s is longer than %s", ErrInvalidQuery, d, MaxQueryRange)
+		}
+		return nil
+	}
+	if !r.From.Before(r.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+	if r.To.Sub(r.From) > MaxQueryRange {
+		return fmt.Errorf("%w: range is longer than %s", ErrInvalidQuery, MaxQueryRange)
+	}
+	return nil
+}
+
+// Bounds returns the range as [from, to) at now. Relative months and years
+// follow the calendar of the range's location.
+func (r HistoryRange) Bounds(now time.Time) (time.Time, time.Time) {
+	if r.Absolute() {
+		return r.From, r.To
+	}
+	d, err := ParseFluxDuration(r.Start)
+	if err != nil {
+		return now, now
+	}
+	return d.Before(now, r.location()), now
+}
+
+func (r HistoryRange) location() *time.Location {
+	if r.Location == nil {
+		return time.UTC
+	}
+	return r.Location
+}
+
+// Key identifies the range in cache keys. Relative ranges keep their
+// duration, so they are cached as a moving window.
+func (r HistoryRange) Key() string {
+	zone := r.location().String()
+	if !r.Absolute() {
+		return r.Start + "@" + zone
+	}
+	return strconv.FormatInt(r.From.Unix(), 10) + "-" + strconv.FormatInt(r.To.Unix(), 10) + "@" + zone
+}
+
+// Before returns t moved back by d. Days, weeks, months and years follow
+// the calendar of loc, so "-1M" starts on the same date of the previous
+// month, normalized like time.AddDate.
+func (d FluxDuration) Before(t time.Time, loc *time.Location) time.Time {
+	return d.add(t, loc, -1)
+}
+
+// After returns t moved forward by d, see Before.
+func (d FluxDuration) After(t time.Time, loc *time.Location) time.Time {
+	return d.add(t, loc, 1)
+}
+
+func (d FluxDuration) add(t time.Time, loc *time.Location, sign int) time.Time {
+	n := int(d.value) * sign
+	local := t.In(loc)
+	switch d.unit {
+	case "d":
+		return local.AddDate(0, 0, n)
+	case "w":
+		return local.AddDate(0, 0, 7*n)
+	case "mo":
+		return local.AddDate(0, n, 0)
+	case "y":
+		return local.AddDate(n, 0, 0)
+	}
+	return t.Add(time.Duration(sign) * d.Approx())
+}
+
+// calendarUnit reports whether windows of d are aligned to local dates
+// rather than to fixed lengths.
+func (d FluxDuration) calendarUnit() bool {
+	return d.unit == "d" || d.unit == "w" || d.unit == "mo" || d.unit == "y"
+}
+
+// epochMonday is the first Monday after the Unix epoch; week windows start
+// on Mondays.
+var epochMonday = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// windowBounds returns the window of step that contains t. Windows shorter
+// than a day are aligned to the epoch in local time, days to local midnight,
+// weeks to Monday, months and years to the first of the month and January.
+// Windows of several units are aligned to the epoch in that unit, like
+// Flux.
+func windowBounds(t time.Time, step FluxDuration, loc *time.Location) (time.Time, time.Time) {
+	local := t.In(loc)
+	n := int(step.value)
+
+	if !step.calendarUnit() {
+		_, offset := local.Zone()
+		size := step.Approx().Nanoseconds()
+		shifted := t.UnixNano() + int64(offset)*int64(time.Second)
+		start := shifted - floorMod(shifted, size) - int64(offset)*int64(time.Second)
+		return time.Unix(0, start), time.Unix(0, start+size)
+	}
+
+	var start time.Time
+	switch step.unit {
+	case "d", "w":
+		days := n
+		base := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+		if step.unit == "w" {
+			days = 7 * n
+			base = epochMonday
+		}
+		// Count local dates, not elapsed time, so DST days still align.
+		date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		elapsed := int(date.Sub(base).Hours() / 24)
+		first := base.AddDate(0, 0, elapsed-floorModInt(elapsed, days))
+		start = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, days)
+	case "mo":
+		months := (local.Year()-1970)*12 + int(local.Month()) - 1
+		months -= floorModInt(months, n)
+		start = time.Date(1970, time.Month(months+1), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, n, 0)
+	default: // "y"
+		years := local.Year() - 1970
+		years -= floorModInt(years, n)
+		start = time.Date(1970+years, time.January, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(n, 0, 0)
+	}
+}
+
+func floorMod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
+
+func floorModInt(a, b int) int {
+	return ((a % b) + b) % b
+}
+
+// locationName returns the IANA name of loc for Flux, empty for UTC.
+func locationName(loc *time.Location) string {
+	if loc == nil || loc == time.UTC || strings.EqualFold(loc.String(), "UTC") {
+		return ""
+	}
+	return loc.String()
+}
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseHistoryRange(t *testing.T) {
+	r, err := ParseHistoryRange("-7d", "", "", "")
+	if err != nil || r.Absolute() || r.Start != "-7d" || r.Location != nil {
+		t.Fatalf("relative range = %+v, %v", r, err)
+	}
+
+	r, err = ParseHistoryRange("-7d", "1718000000", "2024-06-12T00:00:00+02:00", "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Absolute() || r.From.Unix() != 1718000000 || !r.To.Equal(time.Date(2024, 6, 11, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("absolute range = %+v", r)
+	}
+	if r.Location == nil || r.Location.String() != "Europe/Berlin" {
+		t.Errorf("location = %v", r.Location)
+	}
+	if !strings.HasSuffix(r.Key(), "@Europe/Berlin") {
+		t.Errorf("key %q does not carry the zone", r.Key())
+	}
+
+	if r, err := ParseHistoryRange("-1d", "2024-06-10T00:00:00Z", "", ""); err != nil || r.To.IsZero() {
+		t.Errorf("open range = %+v, %v, want to defaulting to now", r, err)
+	}
+
+	for _, tc := range []struct{ start, from, to, tz string }{
+		{"1d) |> drop()", "", "", ""},
+		{"-1d", "", "2024-06-10T00:00:00Z", ""},
+		{"-1d", "yesterday", "", ""},
+		{"-1d", "2024-06-10T00:00:00Z", "2024-06-10T00:00:00Z", ""},
+		{"-1d", "2024-06-10T00:00:00Z", "2024-06-09T00:00:00Z", ""},
+		{"-1d", "0", "2024-06-10T00:00:00Z", ""},
+		{"-1d", "", "", "Mars/Olympus_Mons"},
+		{"-1d", "", "", "Local"},
+	} {
+		if _, err := ParseHistoryRange(tc.start, tc.from, tc.to, tc.tz); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%+v: got %v, want ErrInvalidQuery", tc, err)
+		}
+	}
+}
+
+func TestWindowBounds(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	kolkata := loadLocation(t, "Asia/Kolkata")
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		at, step    string
+		loc         *time.Location
+		start, stop string
+	}{
+		// Local midnight, not UTC midnight.
+		{"2024-03-10T23:30:00Z", "1d", berlin, "2024-03-10T23:00:00Z", "2024-03-11T23:00:00Z"},
+		{"2024-03-10T23:30:00Z", "1d", time.UTC, "2024-03-10T00:00:00Z", "2024-03-11T00:00:00Z"},
+		// The day clocks go forward has 23 hours.
+		{"2024-03-31T12:00:00Z", "1d", berlin, "2024-03-30T23:00:00Z", "2024-03-31T22:00:00Z"},
+		// Weeks start on Monday.
+		{"2024-03-14T12:00:00Z", "1w", time.UTC, "2024-03-11T00:00:00Z", "2024-03-18T00:00:00Z"},
+		{"2024-03-17T23:30:00Z", "1w", berlin, "2024-03-17T23:00:00Z", "2024-03-24T23:00:00Z"},
+		// Calendar months and years.
+		{"2024-02-15T00:00:00Z", "1mo", time.UTC, "2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+		{"2024-02-15T00:00:00Z", "3mo", time.UTC, "2024-01-01T00:00:00Z", "2024-04-01T00:00:00Z"},
+		{"2024-02-15T00:00:00Z", "1y", berlin, "2023-12-31T23:00:00Z", "2024-12-31T23:00:00Z"},
+		// Hours follow the local offset.
+		{"2024-01-01T00:10:00Z", "1h", kolkata, "2023-12-31T23:30:00Z", "2024-01-01T00:30:00Z"},
+		{"2024-01-01T00:10:00Z", "4m", time.UTC, "2024-01-01T00:08:00Z", "2024-01-01T00:12:00Z"},
+	}
+	for _, tc := range cases {
+		step, err := ParseFluxDuration(tc.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, stop := windowBounds(utc(tc.at), step, tc.loc)
+		if !start.Equal(utc(tc.start)) || !stop.Equal(utc(tc.stop)) {
+			t.Errorf("%s %s in %s: [%s, %s), want [%s, %s)", tc.at, tc.step, tc.loc,
+				start.UTC().Format(time.RFC3339), stop.UTC().Format(time.RFC3339), tc.start, tc.stop)
+		}
+	}
+}
+
+func TestTimeToMinutesFixedMonths(t *testing.T) {
+	// Step sizing must not depend on the date, so months and years are
+	// always 30 and 365 days.
+	for _, tc := range []struct {
+		in   string
+		want float64
+	}{
+		{"-1M", 43200},
+		{"1mo", 43200},
+		{"-1y", 525600},
+		{"1.5M", 1.5 * 43200},
+		{"-14d", 20160},
+	} {
+		got, err := timeToMinutes(tc.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Errorf("timeToMinutes(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAbsoluteRangeFlux(t *testing.T) {
+	t.Setenv("INFLUXDB_BUCKET", "minetracker_data")
+	setCoverage(t, map[string]RollupCoverage{})
+	berlin := loadLocation(t, "Europe/Berlin")
+
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, berlin)
+	query, _, step, err := BuildInfluxQueryFromParams(QueryParams{
+		From:         from,
+		To:           from.AddDate(0, 2, 0),
+		Location:     berlin,
+		Step:         "1w",
+		ServerFilter: "a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"import \"timezone\"\n\noption location = timezone.location(name: \"Europe/Berlin\")\n\nfrom(",
+		`range(start: 2024-05-31T22:00:00Z, stop: 2024-07-31T22:00:00Z)`,
+		`aggregateWindow(every: 1w, fn: mean, offset: 4d, createEmpty: false)`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query lacks %s:\n%s", want, query)
+		}
+	}
+	if step != "1w" {
+		t.Errorf("step = %s", step)
+	}
+	lexFlux(t, query)
+
+	query, _, _, err = BuildInfluxQueryFromParams(QueryParams{Start: "-1d", ServerFilter: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(query, "timezone") || !strings.HasPrefix(query, "from(") {
+		t.Errorf("UTC query sets a location:\n%s", query)
+	}
+
+	if _, _, _, err := BuildInfluxQueryFromParams(QueryParams{From: from, To: from}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("empty range: got %v, want ErrInvalidQuery", err)
+	}
+}
+
+func TestTimeZoneTiers(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	kolkata := loadLocation(t, "Asia/Kolkata")
+	now := time.Now()
+	setCoverage(t, map[string]RollupCoverage{
+		"1m": {Since: now.Add(-20 * 24 * time.Hour), Until: now},
+		"1h": {Since: now.Add(-60 * 24 * time.Hour), Until: now},
+		"1d": {Since: now.Add(-60 * 24 * time.Hour), Until: now},
+	})
+
+	for _, tc := range []struct {
+		loc      *time.Location
+		start    string
+		wantTier string
+	}{
+		{nil, "-30d", "1d"},
+		{berlin, "-30d", "1h"}, // UTC days do not fit local days
+		{kolkata, "-30d", ""},  // nor do hours at +5:30
+		{kolkata, "-7d", "1m"},
+	} {
+		q := serverRangeQuery(HistoryRange{Start: tc.start, Location: tc.loc}, "1d", "a", FieldPlayerCount, "mean")
+		if q.Tier != tc.wantTier {
+			t.Errorf("%s in %v: tier %q, want %q", tc.start, tc.loc, q.Tier, tc.wantTier)
+		}
+	}
+
+	// An absolute range in the past only needs coverage up to its end.
+	q := serverRangeQuery(HistoryRange{From: now.Add(-50 * 24 * time.Hour), To: now.Add(-40 * 24 * time.Hour)}, "1d", "a", FieldPlayerCount, "mean")
+	if q.Tier != "1d" {
+		t.Errorf("absolute range: tier %q, want 1d", q.Tier)
+	}
+}
+
+func TestLocalStoreTimeZoneWindows(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+	setCoverage(t, map[string]RollupCoverage{})
+	berlin := loadLocation(t, "Europe/Berlin")
+
+	// 23:30 and 00:30 in Berlin, both on 10 June in UTC.
+	for _, p := range []struct {
+		at      time.Time
+		players int
+	}{
+		{time.Date(2024, 6, 10, 21, 30, 0, 0, time.UTC), 10},
+		{time.Date(2024, 6, 10, 22, 30, 0, 0, time.UTC), 20},
+	} {
+		store.Write(SeriesPoint{
+			Measurement: "server_data",
+			Tags:        map[string]string{"id": "a", "type": "PC"},
+			Fields:      map[string]interface{}{"player_count": p.players},
+			Time:        p.at,
+		})
+	}
+
+	from := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	query := func(loc *time.Location) []WindowValue {
+		q := serverRangeQuery(HistoryRange{From: from, To: from.AddDate(0, 0, 2), Location: loc}, "1d", "a", FieldPlayerCount, "mean")
+		values, err := store.QueryWindows(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	assertWindows(t, query(nil), []WindowValue{
+		{Series: "a", Time: time.Date(2024, 6, 11, 0, 0, 0, 0, time.UTC), Value: 15},
+	})
+	assertWindows(t, query(berlin), []WindowValue{
+		{Series: "a", Time: time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC), Value: 10},
+		{Series: "a", Time: time.Date(2024, 6, 11, 22, 0, 0, 0, time.UTC), Value: 20},
+	})
+}
@@ -0,0 +1,215 @@
+package data
+
+import (
+	"MineTracker/database"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Time-series backends selectable with TIMESERIES_BACKEND.
+const (
+	TimeSeriesInflux = "influx"
+	TimeSeriesLocal  = "local"
+)
+
+// SeriesPoint is one measurement written to the time-series store. A series
+// is identified by the measurement and its tags.
+type SeriesPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// WindowQuery selects the windowed history of one field.
+type WindowQuery struct {
+	Measurement string
+	Field       string
+	Tag         string         // tag that names a series, e.g. "id"
+	Values      []string       // series whose Tag is one of these, empty for all
+	Start       string         // relative range, e.g. "-1d"
+	From, To    time.Time      // absolute range, replaces Start when From is set
+	Location    *time.Location // aligns windows to local dates, nil for UTC
+	Step        string         // window size, e.g. "4m"
+	Fn          string         // aggregate of each series per window, e.g. "mean" or "p95"
+	Weight      string         // field weighting a "mean" Fn, e.g. the count of a rollup mean
+	Combine     string         // if set, combines all series per window, e.g. "sum"
+	Tier        string         // rollup tier to read, empty for the raw points
+	Rest        *WindowQuery   // raw query for the windows past the tier's coverage, nil if it covers the range
+}
+
+// historyRange returns the range of q.
+func (q WindowQuery) historyRange() HistoryRange {
+	return HistoryRange{Start: q.Start, From: q.From, To: q.To, Location: q.Location}
+}
+
+// WindowValue is the aggregate of one window. Time is the end of the window,
+// or the end of the range for the last, partial one.
+type WindowValue struct {
+	Time   time.Time
+	Series string // value of WindowQuery.Tag, empty for combined results
+	Value  float64
+}
+
+// AggregateQuery aggregates one field over a range per value of a tag.
+type AggregateQuery struct {
+	Measurement string
+	Field       string
+	Tag         string // filter: only series whose Tag equals Value
+	Value       string
+	Start       string          // relative range, e.g. "-1d"
+	From, To    time.Time       // absolute range, replaces Start when From is set
+	GroupBy     string          // tag to group by, e.g. "reason"
+	Fn          string          // e.g. "sum"
+	Tier        string          // rollup tier to read, empty for the raw points
+	Rest        *AggregateQuery // raw query for the range past the tier's coverage, nil if it covers the range
+}
+
+// TimeSeriesStore stores and queries the ping history. Both backends accept
+// the same ranges, steps and aggregate functions as the Flux builder.
+type TimeSeriesStore interface {
+	// Write stores a point. Writes may be buffered until Close.
+	Write(p SeriesPoint)
+	// QueryWindows returns windowed values ordered by series, then time.
+	QueryWindows(ctx context.Context, q WindowQuery) ([]WindowValue, error)
+	// QueryAggregate returns the aggregate per value of q.GroupBy.
+	QueryAggregate(ctx context.Context, q AggregateQuery) (map[string]float64, error)
+	// Rollup writes the rollups of tier for every window starting in
+	// [from, to), replacing rollups written for them before.
+	Rollup(ctx context.Context, tier RollupTier, from, to time.Time) error
+	// ApplyRetention removes raw points and rollups older than their
+	// retention, or makes sure the backend does so itself.
+	ApplyRetention(ctx context.Context) error
+	// Close flushes buffered writes and releases the store.
+	Close() error
+}
+
+// TimeSeries is the store opened by OpenTimeSeriesStore.
+var TimeSeries TimeSeriesStore
+
+// queryWindows runs q on TimeSeries and appends the windows of q.Rest, which
+// the tier of q has not been rolled up for yet.
+func queryWindows(ctx context.Context, q WindowQuery) ([]WindowValue, error) {
+	values, err := TimeSeries.QueryWindows(ctx, q)
+	if err != nil || q.Rest == nil {
+		return values, err
+	}
+	rest, err := TimeSeries.QueryWindows(ctx, *q.Rest)
+	if err != nil {
+		return nil, err
+	}
+	// The rest starts where the tier's last window ends, so only separate
+	// series need to be merged.
+	values = append(values, rest...)
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].Series != values[j].Series {
+			return values[i].Series < values[j].Series
+		}
+		return values[i].Time.Before(values[j].Time)
+	})
+	return values, nil
+}
+
+// queryAggregate runs q on TimeSeries and adds the sums of q.Rest, which the
+// tier of q has not been rolled up for yet.
+func queryAggregate(ctx context.Context, q AggregateQuery) (map[string]float64, error) {
+	aggregates, err := TimeSeries.QueryAggregate(ctx, q)
+	if err != nil || q.Rest == nil {
+		return aggregates, err
+	}
+	rest, err := TimeSeries.QueryAggregate(ctx, *q.Rest)
+	if err != nil {
+		return nil, err
+	}
+	for key, v := range rest {
+		aggregates[key] += v
+	}
+	return aggregates, nil
+}
+
+// TimeSeriesBackend returns the configured TIMESERIES_BACKEND, "influx" by
+// default.
+func TimeSeriesBackend() string {
+	if backend := os.Getenv("TIMESERIES_BACKEND"); backend != "" {
+		return backend
+	}
+	return TimeSeriesInflux
+}
+
+// OpenTimeSeriesStore reads the retention settings, opens the configured
+// backend and makes it the global TimeSeries store:
+//   - influx connects to INFLUXDB_URL and uses INFLUXDB_ORG/INFLUXDB_BUCKET,
+//   - local keeps compressed segment files under TIMESERIES_PATH (default
+//     "timeseries") and flushes every TIMESERIES_FLUSH_INTERVAL (default 10s).
+func OpenTimeSeriesStore() error {
+	if err := loadRetentionConfig(); err != nil {
+		return err
+	}
+
+	var store TimeSeriesStore
+
+	switch backend := TimeSeriesBackend(); backend {
+	case TimeSeriesInflux:
+		if err := database.ConnectInflux(); err != nil {
+			return err
+		}
+		store = newInfluxStore()
+
+	case TimeSeriesLocal:
+		path := os.Getenv("TIMESERIES_PATH")
+		if path == "" {
+			path = "timeseries"
+		}
+		flush := 10 * time.Second
+		if raw := os.Getenv("TIMESERIES_FLUSH_INTERVAL"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid TIMESERIES_FLUSH_INTERVAL %q", raw)
+			}
+			flush = d
+		}
+		local, err := OpenLocalStore(path, flush)
+		if err != nil {
+			return err
+		}
+		store = local
+
+	default:
+		return fmt.Errorf("unknown TIMESERIES_BACKEND %q, use %q or %q", backend, TimeSeriesInflux, TimeSeriesLocal)
+	}
+
+	TimeSeries = store
+	return nil
+}
+
+// validateWindowQuery applies the checks of the Flux builder to a query, so
+// both backends reject the same input. It returns the range at now and the
+// step.
+func validateWindowQuery(q WindowQuery, now time.Time) (time.Time, time.Time, FluxDuration, error) {
+	r := q.historyRange()
+	if err := r.validate(); err != nil {
+		return time.Time{}, time.Time{}, FluxDuration{}, err
+	}
+	from, to := r.Bounds(now)
+
+	step, err := ParseFluxDuration(q.Step)
+	if err != nil {
+		return time.Time{}, time.Time{}, FluxDuration{}, fmt.Errorf("%w: invalid step: %v", ErrInvalidQuery, err)
+	}
+	if step.Approx() < MinQueryStep || step.Approx() > MaxQueryRange {
+		return time.Time{}, time.Time{}, FluxDuration{}, fmt.Errorf("%w: step %s out of range", ErrInvalidQuery, step)
+	}
+	if !isWindowFunction(q.Fn) {
+		return time.Time{}, time.Time{}, FluxDuration{}, fmt.Errorf("%w: unsupported aggregate %q", ErrInvalidQuery, q.Fn)
+	}
+	if q.Weight != "" && q.Fn != "mean" {
+		return time.Time{}, time.Time{}, FluxDuration{}, fmt.Errorf("%w: only means can be weighted, not %q", ErrInvalidQuery, q.Fn)
+	}
+	if q.Combine != "" && !fluxAggregates[q.Combine] {
+		return time.Time{}, time.Time{}, FluxDuration{}, fmt.Errorf("%w: unsupported aggregate %q", ErrInvalidQuery, q.Combine)
+	}
+	return from, to, step, nil
+}
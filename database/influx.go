@@ -14,8 +14,13 @@ func GetInfluxOrg() string {
 	return os.Getenv("INFLUXDB_ORG")
 }
 
+// GetInfluxBucket returns INFLUXDB_BUCKET, defaulting to the bucket name
+// used before it was configurable.
 func GetInfluxBucket() string {
-	return os.Getenv("INFLUXDB_BUCKET")
+	if bucket := os.Getenv("INFLUXDB_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return "minetracker_data"
 }
 
 func ConnectInflux() error {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"MineTracker/auth"
 	"MineTracker/data"
 	"MineTracker/database"
 	"MineTracker/routes"
@@ -8,11 +9,11 @@ import (
 	"MineTracker/util"
 	"MineTracker/websocket"
 	"context"
-	"net/http"
-	_ "net/http/pprof"
+	"errors"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -24,33 +25,87 @@ import (
 func main() {
 	_ = godotenv.Load()
 
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	// servers.json is optional: when present it is imported into MongoDB,
+	// which is the source of truth for the tracked server list. It is checked
+	// before connecting anywhere so a broken file fails fast; see also
+	// `MineTracker validate`.
+	serversFile, err := data.LoadServersFile("servers.json")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		util.Logger.Fatal().Err(err).Msg("Invalid servers.json")
+	}
+	haveServersFile := err == nil
+	for _, w := range serversFile.Warnings {
+		util.Logger.Warn().Msg("servers.json:" + w.String())
+	}
+
 	database.ConnectMongo(os.Getenv("MONGO_URI"))
 
 	ctx, serverJobCancel := context.WithCancel(context.Background())
 
 	util.Logger.Info().Msg("Connected to MongoDB!")
 
-	err := database.ConnectInflux()
+	err = data.OpenTimeSeriesStore()
 	if err != nil {
-		util.Logger.Fatal().Err(err).Msg("Failed to connect to InfluxDB")
+		util.Logger.Fatal().Err(err).Msg("Failed to open the time-series store")
 		panic(err)
 	}
 
-	util.Logger.Info().Msg("Connected to InfluxDB!")
+	util.Logger.Info().Msg("Opened " + data.TimeSeriesBackend() + " time-series store!")
 
-	Servers, err := data.LoadServers("servers.json")
+	if err := task.EnsureTrackedIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create tracked server indexes")
+	}
+	if err := task.EnsureAliasIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create server alias indexes")
+	}
+	if err := task.MigrateServerIDs(ctx); err != nil {
+		util.Logger.Fatal().Err(err).Msg("Failed to assign server IDs")
+	}
+	if err := task.LoadAliases(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to load server aliases")
+	}
+	if err := task.EnsureSubmissionIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create submission indexes")
+	}
+	if err := auth.EnsureKeyIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create API key indexes")
+	}
+	if err := task.EnsureGroupIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create server group indexes")
+	}
+	if err := task.EnsureRollupIndexes(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to create rollup progress indexes")
+	}
 
+	if haveServersFile {
+		if err := task.ImportServers(ctx, serversFile.Servers); err != nil {
+			util.Logger.Fatal().Err(err).Msg("Failed to import servers.json into MongoDB")
+		}
+		if err := task.ImportGroups(ctx, serversFile.Groups); err != nil {
+			util.Logger.Fatal().Err(err).Msg("Failed to import server groups from servers.json")
+		}
+	} else if err := task.LoadGroups(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to load server groups")
+	}
+
+	Servers, err := task.LoadTrackedServers(ctx)
 	if err != nil {
-		util.Logger.Fatal().Err(err).Msg("Failed to load servers.json")
+		util.Logger.Fatal().Err(err).Msg("Failed to load tracked servers from MongoDB")
 	}
 
 	pingJob := task.NewServerJob(0, Servers)
 
-	task.StartInfluxWriter(ctx)
+	historyDone := task.StartHistoryWriter(ctx)
 	task.StartDBWriter(ctx)
 	task.StartActiveStatusSync(ctx)
+	task.StartDownsampler(ctx)
 
 	go pingJob.StartServerJob(ctx)
+	go task.WatchServersFile(ctx, "servers.json", pingJob)
 
 	err = task.LoadServerCache(ctx)
 	if err != nil {
@@ -63,7 +118,7 @@ func main() {
 		return
 	}
 
-	util.Logger.Info().Msg("Loaded " + strconv.Itoa(int(rune(len(Servers)))) + " servers from servers.json")
+	util.Logger.Info().Msg("Loaded " + strconv.Itoa(int(rune(len(Servers)))) + " tracked servers")
 
 	go func() {
 		if os.Getenv("DEPLOYMENT_MODE") == "production" || os.Getenv("DEPLOYMENT_MODE") == "release" {
@@ -72,23 +127,50 @@ func main() {
 			gin.SetMode(gin.DebugMode)
 		}
 
-		r := gin.Default()
+		// The websocket token is taken out of the URL before the request
+		// logger prints it.
+		r := gin.New()
+		r.Use(auth.HideQueryToken(), gin.Logger(), gin.Recovery())
+
+		// X-Forwarded-For is only honoured from TRUSTED_PROXIES, so the
+		// per-IP submission limit can not be dodged with a forged header.
+		var proxies []string
+		for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				proxies = append(proxies, p)
+			}
+		}
+		if err := r.SetTrustedProxies(proxies); err != nil {
+			util.Logger.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+		}
 
 		r.Use(cors.New(cors.Config{
 			AllowOrigins:     []string{os.Getenv("FRONTEND_URL")},
 			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-			ExposeHeaders:    []string{"Content-Length"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Owner-Token"},
+			ExposeHeaders:    []string{"Content-Length", "X-Total-Count"},
 			AllowCredentials: true,
 			MaxAge:           12 * time.Hour,
 		}))
 
-		routes.RegisterGetDatedDataRoute(r)
-		routes.RegisterGetBulkDatedDataRoute(r)
-		routes.RegisterGetServers(r)
+		r.Use(auth.Authenticate())
+
+		public := r.Group("", auth.Allow(auth.ScopeRead))
+		routes.RegisterGetDatedDataRoute(public)
+		routes.RegisterGetBulkDatedDataRoute(public)
+		routes.RegisterGetServers(public)
+		routes.RegisterGetServerErrorsRoute(public)
+		routes.RegisterGroupRoutes(public)
 		routes.RegisterGetVersionRoute(r)
+		routes.RegisterSubmissionRoutes(r)
+
+		routes.RegisterAdminRoutes(r)
+		routes.RegisterAPIKeyRoutes(r)
+		if os.Getenv("PROFILING_ENABLED") == "true" {
+			routes.RegisterDebugRoutes(r)
+		}
 
-		r.GET("/ws", func(c *gin.Context) {
+		r.GET("/ws", auth.Allow(auth.ScopeWebsocket), func(c *gin.Context) {
 			websocket.HandleWebSocket(c.Writer, c.Request)
 		})
 
@@ -98,15 +180,6 @@ func main() {
 		}
 	}()
 
-	if os.Getenv("PROFILING_ENABLED") == "true" {
-		go func() {
-			util.Logger.Info().Msg("pprof listening on :" + os.Getenv("PROFILING_PORT"))
-			if err := http.ListenAndServe(os.Getenv("PROFILING_HOST")+":"+os.Getenv("PROFILING_PORT"), nil); err != nil {
-				util.Logger.Error().Err(err).Msg("pprof server failed")
-			}
-		}()
-	}
-
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
@@ -114,4 +187,5 @@ func main() {
 	database.MongoClient.Disconnect(ctx)
 	serverJobCancel()
 	util.Logger.Info().Msg("Shutting down MineTracker...")
+	<-historyDone
 }
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"MineTracker/auth"
+	"MineTracker/data"
+	"MineTracker/task"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trackedServerError maps task errors to HTTP responses.
+func trackedServerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, task.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrServerExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrJobNotRunning):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func RegisterAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/api/admin", auth.Require(auth.RoleAdmin, auth.ScopeServersWrite))
+
+	admin.GET("/servers", func(c *gin.Context) {
+		servers, err := task.LoadTrackedServers(c.Request.Context())
+		if err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, servers)
+	})
+
+	admin.POST("/servers", func(c *gin.Context) {
+		var server data.PingableServer
+		if err := c.ShouldBindJSON(&server); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		server.Source = data.ServerSourceAPI
+		if err := server.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		created, err := task.CreateTrackedServer(c.Request.Context(), server)
+		if err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+	})
+
+	// The :id parameter also accepts a current or former address.
+	admin.PUT("/servers/:id", func(c *gin.Context) {
+		id, ok := task.ResolveServerID(c.Param("id"))
+		if !ok {
+			trackedServerError(c, task.ErrServerNotFound)
+			return
+		}
+
+		var server data.PingableServer
+		if err := c.ShouldBindJSON(&server); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if server.ID != "" && server.ID != id {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Server IDs can not be changed"})
+			return
+		}
+		server.ID = id
+		if err := server.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := task.UpdateTrackedServer(c.Request.Context(), server); err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, server)
+	})
+
+	admin.DELETE("/servers/:id", func(c *gin.Context) {
+		if err := task.DeleteTrackedServer(c.Request.Context(), task.ResolveServerKey(c.Param("id"))); err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	admin.POST("/servers/:id/activate", func(c *gin.Context) {
+		id := task.ResolveServerKey(c.Param("id"))
+		if err := task.SetServerActive(c.Request.Context(), id, true); err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "active": true})
+	})
+
+	admin.POST("/servers/:id/deactivate", func(c *gin.Context) {
+		id := task.ResolveServerKey(c.Param("id"))
+		if err := task.SetServerActive(c.Request.Context(), id, false); err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "active": false})
+	})
+
+	admin.POST("/servers/:id/ping", func(c *gin.Context) {
+		server, err := task.ForcePing(task.ResolveServerKey(c.Param("id")))
+		if err != nil {
+			trackedServerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, server)
+	})
+
+	registerSubmissionReviewRoutes(admin)
+	registerGroupAdminRoutes(admin)
+}
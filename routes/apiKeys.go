@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"MineTracker/auth"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type issueKeyBody struct {
+	Name      string   `json:"name"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // Go duration, e.g. "720h"; empty = never
+}
+
+func RegisterAPIKeyRoutes(r *gin.Engine) {
+	keys := r.Group("/api/admin/keys", auth.Require(auth.RoleAdmin, auth.ScopeKeys))
+
+	keys.GET("", func(c *gin.Context) {
+		list, err := auth.ListKeys(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	keys.POST("", func(c *gin.Context) {
+		var body issueKeyBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		req := auth.IssueRequest{Name: body.Name, Role: body.Role, Scopes: body.Scopes}
+		if body.ExpiresIn != "" {
+			d, err := time.ParseDuration(body.ExpiresIn)
+			if err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires_in"})
+				return
+			}
+			req.ExpiresIn = d
+		}
+		if err := req.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		key, token, err := auth.IssueKey(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The token is only ever shown in this response.
+		c.JSON(http.StatusCreated, gin.H{
+			"key":   key,
+			"token": token,
+		})
+	})
+
+	keys.DELETE("/:id", func(c *gin.Context) {
+		err := auth.RevokeKey(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
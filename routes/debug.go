@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"MineTracker/auth"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterDebugRoutes serves pprof on the main listener, restricted to admin
+// keys with the debug scope.
+func RegisterDebugRoutes(r *gin.Engine) {
+	debug := r.Group("/debug/pprof", auth.Require(auth.RoleAdmin, auth.ScopeDebug))
+
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	debug.GET("/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+}
@@ -2,7 +2,7 @@ package routes
 
 import (
 	"MineTracker/data"
-	"fmt"
+	"MineTracker/task"
 	"net/http"
 	"strings"
 	"sync"
@@ -17,10 +17,25 @@ type serverResult struct {
 	err        error
 }
 
-func RegisterGetBulkDatedDataRoute(r *gin.Engine) {
+func RegisterGetBulkDatedDataRoute(r gin.IRouter) {
 	r.GET("/api/bulk/:servers/:time", func(c *gin.Context) {
 		serversParam := c.Param("servers")
 		time := c.Param("time")
+		field := c.DefaultQuery("field", data.FieldPlayerCount)
+
+		if !data.IsHistoryField(field) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field"})
+			return
+		}
+		aggs, ok := historyAggregates(c)
+		if !ok {
+			return
+		}
+
+		timeRange, ok := historyRange(c, time)
+		if !ok {
+			return
+		}
 
 		servers := strings.Split(serversParam, ",")
 
@@ -45,7 +60,9 @@ func RegisterGetBulkDatedDataRoute(r *gin.Engine) {
 			go func(srv string) {
 				defer wg.Done()
 
-				dataPoints, step, err := data.QueryDataPoints(srv, fmt.Sprintf("-%s", time))
+				id := task.ResolveServerKey(srv)
+				dataPoints, step, err := data.QueryDataPoints(id, timeRange, field, aggs)
+				labelDataPoints(dataPoints, id)
 
 				resultChan <- serverResult{
 					server:     srv,
@@ -2,8 +2,11 @@ package routes
 
 import (
 	"MineTracker/data"
+	"MineTracker/task"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,12 +25,73 @@ var (
 	cacheTTL   = 30 * time.Second
 )
 
-func RegisterGetDatedDataRoute(r *gin.Engine) {
+// labelDataPoints fills in the current address and name of a server, which
+// are not part of the stored series.
+func labelDataPoints(points []data.ServerDataPoint, id string) {
+	s, ok := task.GetServer(id)
+	if !ok {
+		return
+	}
+	for i := range points {
+		points[i].Ip = s.IP
+		points[i].Name = s.Name
+	}
+}
+
+// queryError answers 400 for ranges, steps or fields the query builder
+// rejected and 500 for everything else.
+func queryError(c *gin.Context, err error) {
+	if errors.Is(err, data.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// historyAggregates reads the agg parameter of the history endpoints, e.g.
+// ?agg=min,mean,max, answering 400 for unknown aggregates.
+func historyAggregates(c *gin.Context) ([]string, bool) {
+	aggs, err := data.ParseAggregates(c.Query("agg"))
+	if err != nil {
+		queryError(c, err)
+		return nil, false
+	}
+	return aggs, true
+}
+
+// historyRange reads the range of the history endpoints: the last
+// timeParam, e.g. "7d", or ?from=&to= as RFC 3339 or Unix seconds, which
+// replace timeParam. ?tz=Europe/Berlin aligns daily and longer windows to
+// local midnight. Invalid ranges are answered with 400.
+func historyRange(c *gin.Context, timeParam string) (data.HistoryRange, bool) {
+	r, err := data.ParseHistoryRange("-"+timeParam, c.Query("from"), c.Query("to"), c.Query("tz"))
+	if err != nil {
+		queryError(c, err)
+		return data.HistoryRange{}, false
+	}
+	return r, true
+}
+
+func RegisterGetDatedDataRoute(r gin.IRouter) {
 	r.GET("/api/:server/:time", func(c *gin.Context) {
-		server := c.Param("server")
+		server := task.ResolveServerKey(c.Param("server"))
 		timeParam := c.Param("time")
+		field := c.DefaultQuery("field", data.FieldPlayerCount)
+
+		if !data.IsHistoryField(field) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field"})
+			return
+		}
+		aggs, ok := historyAggregates(c)
+		if !ok {
+			return
+		}
+		timeRange, ok := historyRange(c, timeParam)
+		if !ok {
+			return
+		}
 
-		cacheKey := fmt.Sprintf("%s:%s", server, timeParam)
+		cacheKey := fmt.Sprintf("%s:%s:%s:%s", server, timeRange.Key(), field, strings.Join(aggs, ","))
 
 		cacheMutex.RLock()
 		if entry, found := cache[cacheKey]; found && time.Since(entry.timestamp) < cacheTTL {
@@ -40,10 +104,11 @@ func RegisterGetDatedDataRoute(r *gin.Engine) {
 		}
 		cacheMutex.RUnlock()
 
-		dataPoints, step, err := data.QueryDataPoints(server, fmt.Sprintf("-%s", timeParam))
+		dataPoints, step, err := data.QueryDataPoints(server, timeRange, field, aggs)
+		labelDataPoints(dataPoints, server)
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			queryError(c, err)
 			return
 		}
 
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"MineTracker/data"
+	"MineTracker/task"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterGetServerErrorsRoute(r gin.IRouter) {
+	r.GET("/api/errors/:server/:time", func(c *gin.Context) {
+		server := task.ResolveServerKey(c.Param("server"))
+		timeParam := c.Param("time")
+
+		breakdown, err := data.QueryFailureBreakdown(server, fmt.Sprintf("-%s", timeParam))
+		if err != nil {
+			queryError(c, err)
+			return
+		}
+
+		var total int64
+		for _, n := range breakdown {
+			total += n
+		}
+
+		response := gin.H{
+			"data":  breakdown,
+			"total": total,
+		}
+
+		if s, ok := task.GetServer(server); ok {
+			response["state"] = s.State
+			response["last_error"] = s.LastError
+			response["last_error_reason"] = s.LastErrorReason
+			response["last_error_at"] = s.LastErrorAt
+			response["failure_counts"] = s.FailureCounts
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+}
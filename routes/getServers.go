@@ -1,13 +1,35 @@
 package routes
 
 import (
+	"MineTracker/data"
 	"MineTracker/task"
+	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterGetServers(r *gin.Engine) {
+func RegisterGetServers(r gin.IRouter) {
 	r.GET("/api/servers", func(c *gin.Context) {
-		c.JSON(200, task.GetAllServers())
+		query, err := data.ParseServerListQuery(c.Request.URL.Query())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		servers, total := query.Apply(task.ListServers())
+		c.Header("X-Total-Count", strconv.Itoa(total))
+
+		if len(query.Fields) == 0 {
+			c.JSON(http.StatusOK, servers)
+			return
+		}
+
+		projected, err := query.Project(servers)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, projected)
 	})
 }
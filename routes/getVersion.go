@@ -7,7 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterGetVersionRoute(r *gin.Engine) {
+func RegisterGetVersionRoute(r gin.IRouter) {
 	r.GET("/api/version", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"version": util.CurrentVersion(),
@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"MineTracker/data"
+	"MineTracker/task"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// groupError maps task errors to HTTP responses.
+func groupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, task.ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrGroupExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrServerNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func RegisterGroupRoutes(r gin.IRouter) {
+	r.GET("/api/groups", func(c *gin.Context) {
+		groups := task.ListGroups()
+		summaries := make([]task.GroupSummary, 0, len(groups))
+		for _, g := range groups {
+			summaries = append(summaries, task.SummarizeGroup(g))
+		}
+		c.JSON(http.StatusOK, summaries)
+	})
+
+	r.GET("/api/groups/:id", func(c *gin.Context) {
+		group, ok := task.GetGroup(c.Param("id"))
+		if !ok {
+			groupError(c, task.ErrGroupNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, task.SummarizeGroup(group))
+	})
+
+	// Combined history: member series are summed per window, latency is
+	// averaged across members. With agg every member is aggregated per
+	// window first, so agg=max sums the members' peaks.
+	r.GET("/api/groups/:id/:time", func(c *gin.Context) {
+		group, ok := task.GetGroup(c.Param("id"))
+		if !ok {
+			groupError(c, task.ErrGroupNotFound)
+			return
+		}
+		timeParam := c.Param("time")
+		field := c.DefaultQuery("field", data.FieldPlayerCount)
+
+		if !data.IsHistoryField(field) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field"})
+			return
+		}
+		aggs, ok := historyAggregates(c)
+		if !ok {
+			return
+		}
+		timeRange, ok := historyRange(c, timeParam)
+		if !ok {
+			return
+		}
+
+		// Members are part of the key so edits to the group are not hidden
+		// by the cache.
+		cacheKey := fmt.Sprintf("group:%s:%v:%s:%s:%s", group.ID, group.Members, timeRange.Key(), field, strings.Join(aggs, ","))
+
+		cacheMutex.RLock()
+		if entry, found := cache[cacheKey]; found && time.Since(entry.timestamp) < cacheTTL {
+			cacheMutex.RUnlock()
+			c.JSON(http.StatusOK, gin.H{
+				"data": entry.data,
+				"step": entry.step,
+			})
+			return
+		}
+		cacheMutex.RUnlock()
+
+		dataPoints, step, err := data.QueryGroupDataPoints(group.ID, group.Members, timeRange, field, aggs)
+		if err != nil {
+			queryError(c, err)
+			return
+		}
+		for i := range dataPoints {
+			dataPoints[i].Name = group.Name
+		}
+
+		if dataPoints == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"data": []interface{}{},
+				"step": step,
+			})
+			return
+		}
+
+		cacheMutex.Lock()
+		cache[cacheKey] = cacheEntry{
+			data:      dataPoints,
+			step:      step,
+			timestamp: time.Now(),
+		}
+		cacheMutex.Unlock()
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": dataPoints,
+			"step": step,
+		})
+	})
+}
+
+// registerGroupAdminRoutes adds group management to the admin group.
+func registerGroupAdminRoutes(admin *gin.RouterGroup) {
+	admin.GET("/groups", func(c *gin.Context) {
+		c.JSON(http.StatusOK, task.ListGroups())
+	})
+
+	admin.POST("/groups", func(c *gin.Context) {
+		var group data.ServerGroup
+		if err := c.ShouldBindJSON(&group); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := group.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		created, err := task.CreateGroup(c.Request.Context(), group)
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+	})
+
+	admin.PUT("/groups/:id", func(c *gin.Context) {
+		var group data.ServerGroup
+		if err := c.ShouldBindJSON(&group); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if group.ID != "" && group.ID != c.Param("id") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Group IDs can not be changed"})
+			return
+		}
+		group.ID = c.Param("id")
+		if err := group.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updated, err := task.UpdateGroup(c.Request.Context(), group)
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	})
+
+	admin.DELETE("/groups/:id", func(c *gin.Context) {
+		if err := task.DeleteGroup(c.Request.Context(), c.Param("id")); err != nil {
+			groupError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateWindow counts the requests of one client in the current window.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter allows each client IP limit requests per window. It is meant
+// for the few unauthenticated endpoints that make the backend contact other
+// hosts, so a fixed window per IP is precise enough.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*rateWindow),
+	}
+}
+
+// allow counts a request of client at now and reports whether it is within
+// the limit, or else how long until the client's window resets.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop finished windows once per window so the map only holds clients
+	// seen recently.
+	if now.Sub(l.lastPrune) >= l.window {
+		for ip, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, ip)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.clients[client] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// handler rejects clients over the limit with 429 and a Retry-After header.
+func (l *rateLimiter) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.allow(c.ClientIP(), time.Now())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("192.0.2.1", now.Add(time.Duration(i)*time.Second)); ok != want {
+			t.Fatalf("request %d: allowed %v, want %v", i+1, ok, want)
+		}
+	}
+	if ok, wait := l.allow("192.0.2.1", now.Add(30*time.Second)); ok || wait != 30*time.Second {
+		t.Errorf("over the limit: allowed %v, wait %s", ok, wait)
+	}
+
+	// Clients are counted separately.
+	if ok, _ := l.allow("192.0.2.2", now); !ok {
+		t.Error("second client limited by the first")
+	}
+
+	// The next window starts fresh and old windows are pruned.
+	if ok, _ := l.allow("192.0.2.1", now.Add(time.Minute)); !ok {
+		t.Error("client still limited in the next window")
+	}
+	if _, ok := l.clients["192.0.2.2"]; ok {
+		t.Error("finished window not pruned")
+	}
+}
+
+func TestRateLimiterHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/probe", newRateLimiter(1, time.Hour).handler(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	send := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/probe", nil)
+		req.RemoteAddr = remote
+		// No proxy is trusted, so the header must not reset the limit.
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := send("192.0.2.1:40000"); w.Code != http.StatusNoContent {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w := send("192.0.2.1:40001")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("second request: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := send("192.0.2.2:40000"); w.Code != http.StatusNoContent {
+		t.Errorf("other client: %d", w.Code)
+	}
+}
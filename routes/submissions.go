@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"MineTracker/data"
+	"MineTracker/task"
+	"MineTracker/util"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type submitServerBody struct {
+	Name     string              `json:"name"`
+	IP       string              `json:"ip"`
+	Type     string              `json:"type"`
+	Metadata data.ServerMetadata `json:"metadata"`
+}
+
+type ownerUpdateBody struct {
+	Name     string              `json:"name"`
+	Metadata data.ServerMetadata `json:"metadata"`
+}
+
+// ownerToken reads the token handed out on submission. It has its own header
+// so it never collides with API keys in Authorization.
+func ownerToken(c *gin.Context) (string, bool) {
+	token := c.GetHeader("X-Owner-Token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing X-Owner-Token header"})
+		return "", false
+	}
+	return token, true
+}
+
+// submissionError maps task errors to HTTP responses.
+func submissionError(c *gin.Context, err error) {
+	var perr *task.PingError
+	switch {
+	case errors.As(err, &perr):
+		// The reason would tell a caller whether a port is open, closed or
+		// filtered, so it only goes to the log.
+		util.Logger.Debug().Err(perr).Str("reason", perr.Reason).Msg("Submitted server did not answer")
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Server did not answer"})
+	case errors.Is(err, task.ErrAddressNotPublic):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrSubmissionNotFound), errors.Is(err, task.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrServerExists), errors.Is(err, task.ErrSubmissionExists),
+		errors.Is(err, task.ErrSubmissionReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrCodeNotInMOTD):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// submissionRateLimit reads SUBMISSION_RATE_LIMIT, the number of submissions
+// and verification attempts a client IP may make per hour.
+func submissionRateLimit() int {
+	if n, err := strconv.Atoi(os.Getenv("SUBMISSION_RATE_LIMIT")); err == nil && n > 0 {
+		return n
+	}
+	return 10
+}
+
+func RegisterSubmissionRoutes(r gin.IRouter) {
+	// Both endpoints ping an address chosen by an anonymous caller.
+	probeLimit := newRateLimiter(submissionRateLimit(), time.Hour).handler()
+
+	r.POST("/api/submissions", probeLimit, func(c *gin.Context) {
+		var body submitServerBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Type == "" {
+			body.Type = data.ServerTypeJava
+		}
+
+		candidate := data.PingableServer{Name: body.Name, IP: body.IP, Type: body.Type, Metadata: body.Metadata}
+		if err := candidate.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		sub, token, err := task.SubmitServer(c.Request.Context(), candidate)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+
+		// The owner token is only ever shown in this response.
+		c.JSON(http.StatusCreated, gin.H{
+			"submission":  sub,
+			"owner_token": token,
+		})
+	})
+
+	r.GET("/api/submissions/:id", func(c *gin.Context) {
+		token, ok := ownerToken(c)
+		if !ok {
+			return
+		}
+		sub, err := task.GetOwnedSubmission(c.Request.Context(), c.Param("id"), token)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, sub)
+	})
+
+	r.POST("/api/submissions/:id/verify", probeLimit, func(c *gin.Context) {
+		token, ok := ownerToken(c)
+		if !ok {
+			return
+		}
+		sub, err := task.VerifySubmission(c.Request.Context(), c.Param("id"), token)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, sub)
+	})
+
+	r.PUT("/api/owner/servers/:id", func(c *gin.Context) {
+		token, ok := ownerToken(c)
+		if !ok {
+			return
+		}
+		var body ownerUpdateBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := body.Metadata.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		server, err := task.UpdateOwnedServer(c.Request.Context(), task.ResolveServerKey(c.Param("id")), token, body.Name, body.Metadata)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, server)
+	})
+}
+
+type reviewBody struct {
+	Note string `json:"note"`
+}
+
+// registerSubmissionReviewRoutes adds the review queue to the admin group.
+func registerSubmissionReviewRoutes(admin *gin.RouterGroup) {
+	admin.GET("/submissions", func(c *gin.Context) {
+		subs, err := task.ListSubmissions(c.Request.Context(), c.DefaultQuery("status", task.SubmissionPending))
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, subs)
+	})
+
+	admin.POST("/submissions/:id/approve", func(c *gin.Context) {
+		var body reviewBody
+		_ = c.ShouldBindJSON(&body)
+
+		sub, err := task.ApproveSubmission(c.Request.Context(), c.Param("id"), body.Note)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, sub)
+	})
+
+	admin.POST("/submissions/:id/reject", func(c *gin.Context) {
+		var body reviewBody
+		_ = c.ShouldBindJSON(&body)
+
+		sub, err := task.RejectSubmission(c.Request.Context(), c.Param("id"), body.Note)
+		if err != nil {
+			submissionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, sub)
+	})
+}
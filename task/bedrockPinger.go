@@ -0,0 +1,229 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// raknetMagic is the offline-message magic every unconnected RakNet packet carries.
+var raknetMagic = [16]byte{
+	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe,
+	0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
+}
+
+const (
+	raknetUnconnectedPing = 0x01
+	raknetUnconnectedPong = 0x1c
+)
+
+// bedrockPinger queries Bedrock Edition servers with a RakNet Unconnected Ping
+// over UDP. A single instance is owned by one server loop, so the GUID stays
+// stable for the lifetime of that loop like a real client would.
+type bedrockPinger struct {
+	guid uint64
+}
+
+func newBedrockPinger() *bedrockPinger {
+	return &bedrockPinger{guid: rand.Uint64()}
+}
+
+func (p *bedrockPinger) ping(opts pingOptions) (*mcPingResult, error) {
+	start := time.Now()
+
+	addrs, err := pingResolver.lookupIP(opts.Host)
+	if err != nil {
+		return nil, &PingError{Reason: ReasonDNS, Err: err}
+	}
+	if len(addrs) == 0 {
+		return nil, &PingError{Reason: ReasonDNS, Err: &net.DNSError{Err: "no addresses", Name: opts.Host, IsNotFound: true}}
+	}
+	dnsTime := time.Since(start)
+
+	result, err := p.pingDualStack(addrs, opts.Port, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	result.DNSTime = dnsTime
+	result.ResolvedAddresses = addressSet(addrs, opts.Port)
+	result.TotalTime = time.Since(start)
+	return result, nil
+}
+
+// bedrockAttempt is the outcome of pinging one address.
+type bedrockAttempt struct {
+	result *mcPingResult
+	err    error
+}
+
+// pingDualStack pings the addresses of a host the way dialDualStack dials
+// them: interleaved IPv6/IPv4, each attempt started happyEyeballsDelay after
+// the previous one or as soon as it fails, and the first pong wins. UDP has
+// no handshake, so an address without a route only shows up as a missing
+// pong.
+func (p *bedrockPinger) pingDualStack(addrs []netip.Addr, port uint16, timeout time.Duration) (*mcPingResult, error) {
+	deadline := time.Now().Add(timeout)
+	// Cancelling closes the sockets of attempts that lost the race.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ordered := interleaveFamilies(addrs)
+	results := make(chan bedrockAttempt, len(ordered))
+
+	next := 0
+	start := func() {
+		addr := netip.AddrPortFrom(ordered[next], port)
+		next++
+		go func() {
+			res, err := p.pingAddr(ctx, addr, deadline)
+			results <- bedrockAttempt{res, err}
+		}()
+	}
+
+	start()
+	pending := 1
+	var lastErr error
+	delay := time.NewTimer(happyEyeballsDelay)
+	defer delay.Stop()
+
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.result, nil
+			}
+			lastErr = res.err
+			if next < len(ordered) {
+				start()
+				pending++
+				delay.Reset(happyEyeballsDelay)
+			}
+
+		case <-delay.C:
+			if next < len(ordered) {
+				start()
+				pending++
+				delay.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// pingAddr sends one Unconnected Ping to addr and waits for the pong until
+// deadline or until ctx is cancelled.
+func (p *bedrockPinger) pingAddr(ctx context.Context, addr netip.AddrPort, deadline time.Time) (*mcPingResult, error) {
+	// UDP has no handshake, so "connecting" only binds the local socket.
+	connectStart := time.Now()
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	connectTime := time.Since(connectStart)
+	conn.SetDeadline(deadline) //nolint:errcheck
+
+	// Unconnected Ping: [ID][int64 client time][magic][uint64 client GUID]
+	var req [33]byte
+	req[0] = raknetUnconnectedPing
+	binary.BigEndian.PutUint64(req[1:9], uint64(time.Now().UnixMilli()))
+	copy(req[9:25], raknetMagic[:])
+	binary.BigEndian.PutUint64(req[25:33], p.guid)
+
+	sent := time.Now()
+	if _, err := conn.Write(req[:]); err != nil {
+		return nil, err
+	}
+
+	// The pong is a single datagram; MOTDs are short so 1500 bytes (one MTU)
+	// is plenty and keeps this on the stack.
+	var buf [1500]byte
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(sent)
+
+	result, err := parseBedrockPong(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	result.Latency = latency
+	result.ConnectTime = connectTime
+	result.ResolvedAddress = conn.RemoteAddr().String()
+	return result, nil
+}
+
+// parseBedrockPong decodes an Unconnected Pong packet:
+// [ID][int64 time][uint64 server GUID][magic][uint16 length][server ID string]
+func parseBedrockPong(pkt []byte) (*mcPingResult, error) {
+	const header = 1 + 8 + 8 + 16 + 2
+	if len(pkt) < header {
+		return nil, protocolError("raknet: pong too short")
+	}
+	if pkt[0] != raknetUnconnectedPong {
+		return nil, protocolError("raknet: unexpected packet ID")
+	}
+	if !bytes.Equal(pkt[17:33], raknetMagic[:]) {
+		return nil, protocolError("raknet: bad offline magic")
+	}
+
+	strLen := int(binary.BigEndian.Uint16(pkt[33:35]))
+	if len(pkt) < header+strLen {
+		return nil, protocolError("raknet: truncated server ID string")
+	}
+
+	return parseBedrockServerID(string(pkt[header : header+strLen]))
+}
+
+// parseBedrockServerID parses the semicolon separated MCPE status string:
+// MCPE;MOTD;protocol;version;online;max;serverGUID;subMOTD;gamemode;gamemodeID;portV4;portV6;
+// Only the first six fields are guaranteed; older servers stop there.
+func parseBedrockServerID(s string) (*mcPingResult, error) {
+	fields := strings.Split(s, ";")
+	if len(fields) < 6 {
+		return nil, protocolError("raknet: malformed server ID string")
+	}
+	if fields[0] != "MCPE" && fields[0] != "MCEE" {
+		return nil, protocolError("raknet: unknown edition " + fields[0])
+	}
+
+	protocol, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, protocolError("raknet: invalid protocol version")
+	}
+	online, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, protocolError("raknet: invalid online player count")
+	}
+	maxPlayers, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return nil, protocolError("raknet: invalid max player count")
+	}
+
+	result := &mcPingResult{
+		PlayerCount: online,
+		MaxPlayers:  maxPlayers,
+		Protocol:    protocol,
+		Version:     fields[3],
+		MOTD:        fields[1],
+	}
+	if len(fields) > 7 && fields[7] != "" {
+		result.MOTD += "\n" + fields[7]
+	}
+	if len(fields) > 8 {
+		result.GameMode = fields[8]
+	}
+
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package task
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseBedrockServerID(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    mcPingResult
+		wantErr bool
+	}{
+		{
+			in: "MCPE;Dedicated Server;622;1.20.40;3;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;",
+			want: mcPingResult{
+				PlayerCount: 3, MaxPlayers: 10, Protocol: 622, Version: "1.20.40",
+				MOTD: "Dedicated Server\nBedrock level", GameMode: "Survival",
+			},
+		},
+		{
+			// Older servers stop after the player counts.
+			in:   "MCEE;Classroom;390;1.14.60;0;30",
+			want: mcPingResult{MaxPlayers: 30, Protocol: 390, Version: "1.14.60", MOTD: "Classroom"},
+		},
+		{
+			// An empty sub-MOTD is not appended.
+			in:   "MCPE;Hub;649;1.20.60;12;100;1;;Creative",
+			want: mcPingResult{PlayerCount: 12, MaxPlayers: 100, Protocol: 649, Version: "1.20.60", MOTD: "Hub", GameMode: "Creative"},
+		},
+		{in: "MCPE;Hub;649;1.20.60;12", wantErr: true},
+		{in: "JAVA;Hub;649;1.20.60;12;100", wantErr: true},
+		{in: "MCPE;Hub;new;1.20.60;12;100", wantErr: true},
+		{in: "MCPE;Hub;649;1.20.60;many;100", wantErr: true},
+		{in: "MCPE;Hub;649;1.20.60;12;", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseBedrockServerID(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: parsed as %+v, want an error", tc.in, got)
+			} else if classifyPingError(err).Reason != ReasonProtocol {
+				t.Errorf("%q: error %v is not a protocol error", tc.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got.PlayerCount != tc.want.PlayerCount || got.MaxPlayers != tc.want.MaxPlayers ||
+			got.Protocol != tc.want.Protocol || got.Version != tc.want.Version ||
+			got.MOTD != tc.want.MOTD || got.GameMode != tc.want.GameMode {
+			t.Errorf("%q: got %+v, want %+v", tc.in, *got, tc.want)
+		}
+	}
+}
+
+// bedrockPong builds an Unconnected Pong carrying serverID.
+func bedrockPong(serverID string) []byte {
+	pkt := make([]byte, 35, 35+len(serverID))
+	pkt[0] = raknetUnconnectedPong
+	copy(pkt[17:33], raknetMagic[:])
+	binary.BigEndian.PutUint16(pkt[33:35], uint16(len(serverID)))
+	return append(pkt, serverID...)
+}
+
+func TestParseBedrockPong(t *testing.T) {
+	if _, err := parseBedrockPong(bedrockPong("MCPE;Hub;649;1.20.60;12;100")); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := bedrockPong("MCPE;Hub;649;1.20.60;12;100")
+	badMagic := bedrockPong("MCPE;Hub;649;1.20.60;12;100")
+	badMagic[18] = 0
+	badID := bedrockPong("MCPE;Hub;649;1.20.60;12;100")
+	badID[0] = raknetUnconnectedPing
+
+	for name, pkt := range map[string][]byte{
+		"short":     truncated[:20],
+		"truncated": truncated[:len(truncated)-3],
+		"magic":     badMagic,
+		"id":        badID,
+	} {
+		if _, err := parseBedrockPong(pkt); err == nil {
+			t.Errorf("%s: pong accepted", name)
+		}
+	}
+}
+
+// serveBedrock answers every Unconnected Ping on a local UDP socket.
+func serveBedrock(t *testing.T) netip.AddrPort {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		var buf [64]byte
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf[:])
+			if err != nil {
+				return
+			}
+			if n != 33 || buf[0] != raknetUnconnectedPing {
+				continue
+			}
+			_, _ = conn.WriteToUDPAddrPort(bedrockPong("MCPE;Test;649;1.20.60;1;20"), from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestBedrockPingTriesEveryAddress(t *testing.T) {
+	server := serveBedrock(t)
+
+	// Nothing answers on the IPv6 loopback, so only the IPv4 address pongs.
+	addrs := []netip.Addr{netip.MustParseAddr("::1"), server.Addr()}
+	result, err := newBedrockPinger().pingDualStack(addrs, server.Port(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MaxPlayers != 20 || result.ResolvedAddress != server.String() {
+		t.Errorf("got %+v from %s", *result, result.ResolvedAddress)
+	}
+
+	// Unreachable addresses fail within the timeout.
+	start := time.Now()
+	_, err = newBedrockPinger().pingDualStack([]netip.Addr{netip.MustParseAddr("127.0.0.1")}, closedUDPPort(t), 300*time.Millisecond)
+	if err == nil {
+		t.Error("ping to a closed port succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("failed ping took %s", elapsed)
+	}
+}
+
+// closedUDPPort returns a local UDP port nothing listens on.
+func closedUDPPort(t *testing.T) uint16 {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).AddrPort().Port()
+	_ = conn.Close()
+	return port
+}
@@ -0,0 +1,113 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// happyEyeballsDelay is how long an attempt gets before the next address is
+// tried in parallel (RFC 8305 recommends 250ms).
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// dialDualStack connects to the first reachable address of a host that may
+// have both A and AAAA records. Addresses are interleaved IPv6/IPv4 and
+// attempts are started happyEyeballsDelay apart, so a broken IPv6 route
+// costs a quarter second instead of the whole ping timeout.
+func dialDualStack(addrs []netip.Addr, port uint16, timeout time.Duration) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no addresses")}
+	}
+	if len(addrs) == 1 {
+		return net.DialTimeout("tcp", netip.AddrPortFrom(addrs[0], port).String(), timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	ordered := interleaveFamilies(addrs)
+	results := make(chan dialResult, len(ordered))
+	var d net.Dialer
+
+	next := 0
+	start := func() {
+		addr := netip.AddrPortFrom(ordered[next], port).String()
+		next++
+		go func() {
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	start()
+	pending := 1
+	var lastErr error
+	delay := time.NewTimer(happyEyeballsDelay)
+	defer delay.Stop()
+
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				// Close any connection that lost the race.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							_ = r.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			lastErr = res.err
+			// Failed fast: start the next address right away.
+			if next < len(ordered) {
+				start()
+				pending++
+				delay.Reset(happyEyeballsDelay)
+			}
+
+		case <-delay.C:
+			if next < len(ordered) {
+				start()
+				pending++
+				delay.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// interleaveFamilies orders addresses IPv6, IPv4, IPv6, ... keeping the
+// resolver's order within each family.
+func interleaveFamilies(addrs []netip.Addr) []netip.Addr {
+	var v6, v4 []netip.Addr
+	for _, a := range addrs {
+		if a.Is4() {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+
+	ordered := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,39 @@
+package task
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	addrs := func(s ...string) []netip.Addr {
+		out := make([]netip.Addr, len(s))
+		for i, a := range s {
+			out[i] = netip.MustParseAddr(a)
+		}
+		return out
+	}
+
+	cases := []struct {
+		in, want []netip.Addr
+	}{
+		{nil, []netip.Addr{}},
+		{addrs("192.0.2.1", "192.0.2.2"), addrs("192.0.2.1", "192.0.2.2")},
+		{addrs("192.0.2.1", "2001:db8::1"), addrs("2001:db8::1", "192.0.2.1")},
+		{
+			addrs("192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1"),
+			addrs("2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"),
+		},
+		{
+			addrs("2001:db8::1", "2001:db8::2", "192.0.2.1", "2001:db8::3"),
+			addrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "2001:db8::3"),
+		},
+	}
+
+	for _, tc := range cases {
+		if got := interleaveFamilies(tc.in); !slices.Equal(got, tc.want) {
+			t.Errorf("interleaveFamilies(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
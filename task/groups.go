@@ -0,0 +1,327 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"MineTracker/util"
+	"MineTracker/websocket"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+)
+
+var (
+	groupMu  sync.RWMutex
+	groupMap = make(map[string]data.ServerGroup)
+)
+
+// GroupMember is the live state of one member in a GroupSummary.
+type GroupMember struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	IP          string `json:"ip"`
+	Online      bool   `json:"online"`
+	PlayerCount int    `json:"player_count"`
+}
+
+// GroupSummary is the live combined state of a group. Only online members
+// count towards the totals.
+type GroupSummary struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	PlayerCount   int           `json:"player_count"`
+	MaxPlayers    int           `json:"max_players"`
+	OnlineMembers int           `json:"online_members"`
+	Members       []GroupMember `json:"members"`
+}
+
+func groupsCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("server_groups")
+}
+
+// EnsureGroupIndexes creates the unique index on the group ID.
+func EnsureGroupIndexes(ctx context.Context) error {
+	_, err := groupsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// LoadGroups reads every group from MongoDB into memory.
+func LoadGroups(ctx context.Context) error {
+	cursor, err := groupsCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []data.ServerGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	loaded := make(map[string]data.ServerGroup, len(groups))
+	for _, g := range groups {
+		loaded[g.ID] = g
+	}
+
+	groupMu.Lock()
+	groupMap = loaded
+	groupMu.Unlock()
+	return nil
+}
+
+// resolveMembers turns member IDs or addresses into server IDs and returns the
+// members that match no tracked server.
+func resolveMembers(ctx context.Context, members []string) ([]string, []string, error) {
+	tracked, err := LoadTrackedServers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byKey := make(map[string]string, len(tracked)*2)
+	for _, s := range tracked {
+		byKey[s.ID] = s.ID
+		byKey[data.NormalizeAlias(s.IP)] = s.ID
+	}
+
+	var ids, unknown []string
+	for _, m := range members {
+		id, ok := byKey[m]
+		if !ok {
+			id, ok = byKey[data.NormalizeAlias(m)]
+		}
+		if !ok {
+			id, ok = aliasID(m)
+		}
+		if !ok {
+			unknown = append(unknown, m)
+			continue
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, unknown, nil
+}
+
+// ImportGroups stores the groups from servers.json like ImportServers does
+// for servers: file groups are replaced, groups created through the admin API
+// win, and file groups no longer in the file are removed. Unknown members are
+// skipped with a warning.
+func ImportGroups(ctx context.Context, groups []data.ServerGroup) error {
+	collection := groupsCollection()
+
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		members, unknown, err := resolveMembers(ctx, g.Members)
+		if err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			util.Logger.Warn().Str("group", g.ID).Strs("members", unknown).
+				Msg("Skipping unknown group members in servers.json")
+		}
+
+		g.Members = members
+		g.Source = data.ServerSourceFile
+		ids = append(ids, g.ID)
+
+		_, err = collection.ReplaceOne(ctx,
+			bson.M{"id": g.ID, "source": data.ServerSourceFile},
+			g,
+			options.Replace().SetUpsert(true),
+		)
+		// A group with the same ID created through the API wins.
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	if _, err := collection.DeleteMany(ctx, bson.M{
+		"source": data.ServerSourceFile,
+		"id":     bson.M{"$nin": ids},
+	}); err != nil {
+		return err
+	}
+
+	return LoadGroups(ctx)
+}
+
+// prepareGroup validates a group from the API and resolves its members.
+func prepareGroup(ctx context.Context, group data.ServerGroup) (data.ServerGroup, error) {
+	if err := group.Validate(); err != nil {
+		return data.ServerGroup{}, err
+	}
+	members, unknown, err := resolveMembers(ctx, group.Members)
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+	if len(unknown) > 0 {
+		return data.ServerGroup{}, fmt.Errorf("%w: %s", ErrServerNotFound, strings.Join(unknown, ", "))
+	}
+	group.Members = members
+	return group, nil
+}
+
+// CreateGroup stores a new group.
+func CreateGroup(ctx context.Context, group data.ServerGroup) (data.ServerGroup, error) {
+	group, err := prepareGroup(ctx, group)
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+	group.Source = data.ServerSourceAPI
+
+	_, err = groupsCollection().InsertOne(ctx, group)
+	if mongo.IsDuplicateKeyError(err) {
+		return data.ServerGroup{}, ErrGroupExists
+	}
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+
+	groupMu.Lock()
+	groupMap[group.ID] = group
+	groupMu.Unlock()
+	return group, nil
+}
+
+// UpdateGroup replaces a group. Like servers, editing a file group through
+// the API takes it over.
+func UpdateGroup(ctx context.Context, group data.ServerGroup) (data.ServerGroup, error) {
+	group, err := prepareGroup(ctx, group)
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+	group.Source = data.ServerSourceAPI
+
+	res, err := groupsCollection().ReplaceOne(ctx, bson.M{"id": group.ID}, group)
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+	if res.MatchedCount == 0 {
+		return data.ServerGroup{}, ErrGroupNotFound
+	}
+
+	groupMu.Lock()
+	groupMap[group.ID] = group
+	groupMu.Unlock()
+	return group, nil
+}
+
+// DeleteGroup removes a group. Its members are not affected.
+func DeleteGroup(ctx context.Context, id string) error {
+	res, err := groupsCollection().DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrGroupNotFound
+	}
+
+	groupMu.Lock()
+	delete(groupMap, id)
+	groupMu.Unlock()
+	return nil
+}
+
+// GetGroup returns a group definition.
+func GetGroup(id string) (data.ServerGroup, bool) {
+	groupMu.RLock()
+	defer groupMu.RUnlock()
+	g, ok := groupMap[id]
+	return g, ok
+}
+
+// ListGroups returns every group sorted by name.
+func ListGroups() []data.ServerGroup {
+	groupMu.RLock()
+	groups := make([]data.ServerGroup, 0, len(groupMap))
+	for _, g := range groupMap {
+		groups = append(groups, g)
+	}
+	groupMu.RUnlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// SummarizeGroup sums the live state of a group's members.
+func SummarizeGroup(group data.ServerGroup) GroupSummary {
+	summary := GroupSummary{
+		ID:      group.ID,
+		Name:    group.Name,
+		Members: make([]GroupMember, 0, len(group.Members)),
+	}
+
+	serverCacheMu.RLock()
+	defer serverCacheMu.RUnlock()
+
+	for _, id := range group.Members {
+		s, ok := serverCacheMap[id]
+		if !ok {
+			summary.Members = append(summary.Members, GroupMember{ID: id})
+			continue
+		}
+
+		summary.Members = append(summary.Members, GroupMember{
+			ID:          id,
+			Name:        s.Name,
+			IP:          s.IP,
+			Online:      s.Online,
+			PlayerCount: s.PlayerCount,
+		})
+		if s.Online {
+			summary.OnlineMembers++
+			summary.PlayerCount += s.PlayerCount
+			summary.MaxPlayers += s.MaxPlayers
+		}
+	}
+	return summary
+}
+
+// publishGroupTotals sends the new totals of every group containing serverID
+// to its websocket subscribers.
+func publishGroupTotals(serverID string) {
+	groupMu.RLock()
+	var groups []data.ServerGroup
+	for _, g := range groupMap {
+		if slices.Contains(g.Members, serverID) && websocket.GlobalHub.HasGroupSubscribers(g.ID) {
+			groups = append(groups, g)
+		}
+	}
+	groupMu.RUnlock()
+
+	now := time.Now().Unix()
+	for _, g := range groups {
+		summary := SummarizeGroup(g)
+		websocket.GlobalHub.SendToGroup(g.ID, map[string]interface{}{
+			"type": "group_total",
+			"data": map[string]interface{}{
+				"id":             summary.ID,
+				"name":           summary.Name,
+				"timestamp":      now,
+				"player_count":   summary.PlayerCount,
+				"max_players":    summary.MaxPlayers,
+				"online_members": summary.OnlineMembers,
+			},
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package task
+
+import (
+	"MineTracker/data"
+	"testing"
+)
+
+func TestSummarizeGroup(t *testing.T) {
+	resetServerCache(t,
+		data.Server{ID: "a", Name: "A", IP: "a.example", Online: true, PlayerCount: 10, MaxPlayers: 100},
+		data.Server{ID: "b", Name: "B", IP: "b.example", Online: false, PlayerCount: 4, MaxPlayers: 50},
+		data.Server{ID: "c", Name: "C", IP: "c.example", Online: true, PlayerCount: 5, MaxPlayers: 20},
+	)
+
+	// d has not been pinged yet; offline members do not count.
+	summary := SummarizeGroup(data.ServerGroup{ID: "network", Name: "Network", Members: []string{"a", "b", "c", "d"}})
+	if summary.PlayerCount != 15 || summary.MaxPlayers != 120 || summary.OnlineMembers != 2 {
+		t.Errorf("totals %d/%d players, %d online, want 15/120 and 2", summary.PlayerCount, summary.MaxPlayers, summary.OnlineMembers)
+	}
+	if len(summary.Members) != 4 || summary.Members[3] != (GroupMember{ID: "d"}) || summary.Members[1].PlayerCount != 4 {
+		t.Errorf("members %+v", summary.Members)
+	}
+}
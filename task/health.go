@@ -0,0 +1,171 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/util"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// healthConfig controls how many consecutive results it takes to change a
+// server's state and how failed pings are retried before they count.
+type healthConfig struct {
+	failureThreshold int           // consecutive failures before online → offline
+	successThreshold int           // consecutive successes before offline → online
+	retries          int           // extra attempts per ping before it counts as failed
+	retryBackoff     time.Duration // delay before the first retry, doubled per retry
+}
+
+var pingHealthConfig = loadHealthConfig()
+
+func loadHealthConfig() healthConfig {
+	return healthConfig{
+		failureThreshold: envInt("PING_FAILURE_THRESHOLD", 3),
+		successThreshold: envInt("PING_SUCCESS_THRESHOLD", 2),
+		retries:          envInt("PING_RETRIES", 1),
+		retryBackoff:     envDuration("PING_RETRY_BACKOFF", 500*time.Millisecond),
+	}
+}
+
+// envInt reads a non-negative integer from the environment.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		util.Logger.Warn().Str("key", key).Str("value", v).Msg("Invalid integer, using default")
+		return def
+	}
+	return n
+}
+
+// serverHealth is the per-server state machine:
+//
+//	online ──fail──▶ suspect ──N fails──▶ offline ──ok──▶ recovering ──M oks──▶ online
+//	   ▲               │                    ▲                 │
+//	   └──────ok───────┘                    └──────fail───────┘
+type serverHealth struct {
+	state     string
+	failures  int
+	successes int
+}
+
+var (
+	healthMu  sync.Mutex
+	healthMap = make(map[string]*serverHealth, 128)
+)
+
+// healthFor returns the tracker for a server ID, seeding it from the cached document so
+// a restart does not reset a confirmed-offline server to online. A server
+// without a document has no history to confirm, so it starts in the state of
+// its first ping result instead of waiting out the thresholds.
+func healthFor(id string, cached data.Server, found bool, first string) *serverHealth {
+	h, ok := healthMap[id]
+	if ok {
+		return h
+	}
+
+	h = &serverHealth{state: cached.State}
+	if h.state == "" {
+		switch {
+		case !found:
+			h.state = first
+		case cached.Online:
+			h.state = data.ServerStateOnline
+		default:
+			h.state = data.ServerStateOffline
+		}
+	}
+	healthMap[id] = h
+	return h
+}
+
+// recordSuccess feeds a successful ping into the state machine and returns
+// the new state.
+func recordSuccess(id string, cached data.Server, found bool) string {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	h := healthFor(id, cached, found, data.ServerStateOnline)
+	h.failures = 0
+
+	switch h.state {
+	case data.ServerStateOffline, data.ServerStateRecovering:
+		h.successes++
+		if h.successes >= pingHealthConfig.successThreshold {
+			h.state = data.ServerStateOnline
+			h.successes = 0
+		} else {
+			h.state = data.ServerStateRecovering
+		}
+	default:
+		h.state = data.ServerStateOnline
+		h.successes = 0
+	}
+
+	return h.state
+}
+
+// recordFailure feeds a failed ping into the state machine and returns the
+// new state.
+func recordFailure(id string, cached data.Server, found bool) string {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	h := healthFor(id, cached, found, data.ServerStateOffline)
+	h.successes = 0
+	h.failures++
+
+	switch h.state {
+	case data.ServerStateOnline, data.ServerStateSuspect:
+		if h.failures >= pingHealthConfig.failureThreshold {
+			h.state = data.ServerStateOffline
+		} else {
+			h.state = data.ServerStateSuspect
+		}
+	default:
+		h.state = data.ServerStateOffline
+	}
+
+	return h.state
+}
+
+// healthSnapshot returns the current state and consecutive failure count of
+// a server, or an empty state if it has not been pinged yet.
+func healthSnapshot(id string) (string, int) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if h, ok := healthMap[id]; ok {
+		return h.state, h.failures
+	}
+	return "", 0
+}
+
+// isOnlineState reports whether a state counts as online for /api/servers.
+// Suspect servers stay listed until the failure threshold is reached;
+// recovering servers are only listed once they are confirmed.
+func isOnlineState(state string) bool {
+	return state == data.ServerStateOnline || state == data.ServerStateSuspect
+}
+
+// pingOnce runs a single ping while holding a pingLimit slot. Failed pings are
+// retried by the scheduler after retryDelay, so no worker sleeps between
+// attempts.
+func pingOnce(pinger serverPinger, opts pingOptions) (*mcPingResult, error) {
+	pingLimit <- struct{}{}
+	defer func() { <-pingLimit }()
+	return pinger.ping(opts)
+}
+
+// retryDelay returns how long to wait before retry number attempt (1-based):
+// the configured backoff, doubled for every further retry.
+func retryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return pingHealthConfig.retryBackoff << (attempt - 1)
+}
@@ -0,0 +1,118 @@
+package task
+
+import (
+	"MineTracker/data"
+	"testing"
+)
+
+// resetHealth gives a test a fresh state machine with fixed thresholds.
+func resetHealth(t *testing.T) {
+	t.Helper()
+
+	healthMu.Lock()
+	savedMap, savedConfig := healthMap, pingHealthConfig
+	healthMap = make(map[string]*serverHealth)
+	pingHealthConfig = healthConfig{failureThreshold: 3, successThreshold: 2}
+	healthMu.Unlock()
+
+	t.Cleanup(func() {
+		healthMu.Lock()
+		healthMap, pingHealthConfig = savedMap, savedConfig
+		healthMu.Unlock()
+	})
+}
+
+func TestHealthTransitions(t *testing.T) {
+	const (
+		ok   = true
+		fail = false
+	)
+	online := data.Server{State: data.ServerStateOnline, Online: true}
+	offline := data.Server{State: data.ServerStateOffline}
+
+	cases := []struct {
+		name   string
+		cached data.Server
+		found  bool
+		pings  []bool
+		want   []string
+	}{
+		{
+			name:   "online to offline after the failure threshold",
+			cached: online, found: true,
+			pings: []bool{fail, fail, fail, fail},
+			want:  []string{data.ServerStateSuspect, data.ServerStateSuspect, data.ServerStateOffline, data.ServerStateOffline},
+		},
+		{
+			name:   "suspect recovers on one success",
+			cached: online, found: true,
+			pings: []bool{fail, fail, ok, fail},
+			want:  []string{data.ServerStateSuspect, data.ServerStateSuspect, data.ServerStateOnline, data.ServerStateSuspect},
+		},
+		{
+			name:   "offline to online after the success threshold",
+			cached: offline, found: true,
+			pings: []bool{ok, ok, ok},
+			want:  []string{data.ServerStateRecovering, data.ServerStateOnline, data.ServerStateOnline},
+		},
+		{
+			name:   "recovering falls back on a failure",
+			cached: offline, found: true,
+			pings: []bool{ok, fail, ok, ok},
+			want:  []string{data.ServerStateRecovering, data.ServerStateOffline, data.ServerStateRecovering, data.ServerStateOnline},
+		},
+		{
+			name:   "documents from before states use the online flag",
+			cached: data.Server{Online: true}, found: true,
+			pings: []bool{fail},
+			want:  []string{data.ServerStateSuspect},
+		},
+		{
+			name:   "documents from before states that were offline",
+			cached: data.Server{}, found: true,
+			pings: []bool{ok},
+			want:  []string{data.ServerStateRecovering},
+		},
+		{
+			name:  "new server is online after its first success",
+			pings: []bool{ok, fail},
+			want:  []string{data.ServerStateOnline, data.ServerStateSuspect},
+		},
+		{
+			name:  "new server is offline after its first failure",
+			pings: []bool{fail, ok},
+			want:  []string{data.ServerStateOffline, data.ServerStateRecovering},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetHealth(t)
+
+			for i, success := range tc.pings {
+				var got string
+				if success {
+					got = recordSuccess("a", tc.cached, tc.found)
+				} else {
+					got = recordFailure("a", tc.cached, tc.found)
+				}
+				if got != tc.want[i] {
+					t.Fatalf("ping %d: state %q, want %q", i+1, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHealthSnapshot(t *testing.T) {
+	resetHealth(t)
+
+	if state, failures := healthSnapshot("a"); state != "" || failures != 0 {
+		t.Errorf("unpinged server: %q, %d", state, failures)
+	}
+	recordFailure("a", data.Server{State: data.ServerStateOnline}, true)
+	recordFailure("a", data.Server{}, true)
+	if state, failures := healthSnapshot("a"); state != data.ServerStateSuspect || failures != 2 {
+		t.Errorf("after two failures: %q, %d", state, failures)
+	}
+}
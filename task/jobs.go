@@ -2,10 +2,17 @@ package task
 
 import (
 	"MineTracker/data"
+	"sync"
 	"time"
 )
 
 type PingJob struct {
 	interval time.Duration
 	servers  []data.PingableServer
+
+	mu      sync.Mutex
+	entries map[string]*scheduledServer
+	queue   pingQueue
+	wake    chan struct{}
+	policy  IntervalPolicy
 }
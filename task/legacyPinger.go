@@ -0,0 +1,138 @@
+package task
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// slpFlavour identifies which Server List Ping dialect a server answered to.
+type slpFlavour int32
+
+const (
+	slpModern     slpFlavour = iota // 1.7+ handshake + JSON status
+	slpLegacy16                     // 1.6 0xFE 0x01 0xFA MC|PingHost
+	slpLegacyBeta                   // beta 1.8 – 1.3 bare 0xFE
+)
+
+// slpFlavours is the order in which fallbacks are attempted.
+var slpFlavours = []slpFlavour{slpModern, slpLegacy16, slpLegacyBeta}
+
+// legacyProtocol16 is the protocol number sent in the 1.6 MC|PingHost payload.
+const legacyProtocol16 = 74
+
+// legacySendPing16 writes the 1.6 style ping:
+// 0xFE 0x01 0xFA [MC|PingHost] [short len] [byte protocol] [host] [int port]
+// 1.4 and 1.5 servers ignore the plugin message and answer the 0xFE 0x01 part.
+func legacySendPing16(conn net.Conn, host string, port uint16) error {
+	channel := utf16.Encode([]rune("MC|PingHost"))
+	hostChars := utf16.Encode([]rune(host))
+
+	buf := make([]byte, 0, 3+2+len(channel)*2+2+1+2+len(hostChars)*2+4)
+	buf = append(buf, 0xFE, 0x01, 0xFA)
+	buf = appendUTF16(buf, channel)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(7+len(hostChars)*2))
+	buf = append(buf, legacyProtocol16)
+	buf = appendUTF16(buf, hostChars)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(port))
+
+	_, err := conn.Write(buf)
+	return err
+}
+
+// legacySendPingBeta writes the original single byte ping.
+func legacySendPingBeta(conn net.Conn) error {
+	_, err := conn.Write([]byte{0xFE})
+	return err
+}
+
+// appendUTF16 appends a length-prefixed UTF-16BE string.
+func appendUTF16(buf []byte, chars []uint16) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(chars)))
+	for _, c := range chars {
+		buf = binary.BigEndian.AppendUint16(buf, c)
+	}
+	return buf
+}
+
+// legacyReadResponse reads the 0xFF kick packet legacy servers answer with and
+// parses the embedded status string.
+func legacyReadResponse(r *bufio.Reader) (*mcPingResult, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if id != 0xFF {
+		return nil, protocolError("mcping: unexpected legacy packet ID")
+	}
+
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	chars := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if chars == 0 {
+		return nil, protocolError("mcping: empty legacy response")
+	}
+
+	raw := make([]byte, chars*2)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	units := make([]uint16, chars)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(raw[i*2:])
+	}
+
+	return parseLegacyKick(string(utf16.Decode(units)))
+}
+
+// parseLegacyKick parses either kick string format:
+//
+//	1.4+: §1\x00<protocol>\x00<version>\x00<motd>\x00<online>\x00<max>
+//	beta: <motd>§<online>§<max>
+func parseLegacyKick(s string) (*mcPingResult, error) {
+	if strings.HasPrefix(s, "§1\x00") {
+		fields := strings.Split(s, "\x00")
+		if len(fields) < 6 {
+			return nil, protocolError("mcping: malformed legacy response")
+		}
+		protocol, _ := strconv.Atoi(fields[1])
+		online, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return nil, protocolError("mcping: invalid legacy player count")
+		}
+		maxPlayers, _ := strconv.Atoi(fields[5])
+		return &mcPingResult{
+			PlayerCount: online,
+			MaxPlayers:  maxPlayers,
+			Protocol:    protocol,
+			Version:     fields[2],
+			MOTD:        fields[3],
+		}, nil
+	}
+
+	// The MOTD itself may contain § colour codes, so split from the right.
+	last := strings.LastIndex(s, "§")
+	if last < 0 {
+		return nil, protocolError("mcping: malformed legacy response")
+	}
+	prev := strings.LastIndex(s[:last], "§")
+	if prev < 0 {
+		return nil, protocolError("mcping: malformed legacy response")
+	}
+	online, err := strconv.Atoi(s[prev+len("§") : last])
+	if err != nil {
+		return nil, protocolError("mcping: invalid legacy player count")
+	}
+	maxPlayers, _ := strconv.Atoi(s[last+len("§"):])
+	return &mcPingResult{
+		PlayerCount: online,
+		MaxPlayers:  maxPlayers,
+		MOTD:        s[:prev],
+	}, nil
+}
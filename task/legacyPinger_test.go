@@ -0,0 +1,77 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseLegacyKick(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    mcPingResult
+		wantErr bool
+	}{
+		{
+			in:   "§1\x0061\x001.5.2\x00A §aColourful§r server\x007\x0020",
+			want: mcPingResult{PlayerCount: 7, MaxPlayers: 20, Protocol: 61, Version: "1.5.2", MOTD: "A §aColourful§r server"},
+		},
+		{
+			// Beta servers: the MOTD may contain § itself.
+			in:   "§cBeta§r world§3§16",
+			want: mcPingResult{PlayerCount: 3, MaxPlayers: 16, MOTD: "§cBeta§r world"},
+		},
+		{in: "Plain world§0§8", want: mcPingResult{MaxPlayers: 8, MOTD: "Plain world"}},
+		{in: "§1\x0061\x001.5.2\x00motd\x007", wantErr: true},
+		{in: "§1\x0061\x001.5.2\x00motd\x00many\x0020", wantErr: true},
+		{in: "no separators", wantErr: true},
+		{in: "one§20", wantErr: true},
+		{in: "motd§x§20", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseLegacyKick(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: parsed as %+v, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got.PlayerCount != tc.want.PlayerCount || got.MaxPlayers != tc.want.MaxPlayers ||
+			got.Protocol != tc.want.Protocol || got.Version != tc.want.Version || got.MOTD != tc.want.MOTD {
+			t.Errorf("%q: got %+v, want %+v", tc.in, *got, tc.want)
+		}
+	}
+}
+
+// legacyKick encodes s as the 0xFF kick packet legacy servers answer with.
+func legacyKick(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	return appendUTF16([]byte{0xFF}, units)
+}
+
+func TestLegacyReadResponse(t *testing.T) {
+	// Characters outside the BMP take two UTF-16 units.
+	res, err := legacyReadResponse(bufio.NewReader(bytes.NewReader(legacyKick("§1\x0078\x001.6.4\x00Hi 🎮\x001\x0010"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MOTD != "Hi 🎮" || res.Version != "1.6.4" || res.MaxPlayers != 10 {
+		t.Errorf("got %+v", *res)
+	}
+
+	for name, pkt := range map[string][]byte{
+		"packet id": append([]byte{0x00}, legacyKick("motd§1§2")[1:]...),
+		"empty":     {0xFF, 0x00, 0x00},
+		"truncated": legacyKick("motd§1§2")[:6],
+	} {
+		if _, err := legacyReadResponse(bufio.NewReader(bytes.NewReader(pkt))); err == nil {
+			t.Errorf("%s: response accepted", name)
+		}
+	}
+}
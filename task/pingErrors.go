@@ -0,0 +1,74 @@
+package task
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Failure reasons recorded for failed pings.
+const (
+	ReasonDNS         = "dns"
+	ReasonRefused     = "connection_refused"
+	ReasonTimeout     = "timeout"
+	ReasonUnreachable = "unreachable"
+	ReasonConnection  = "connection_closed"
+	ReasonProtocol    = "protocol"
+	ReasonJSON        = "invalid_json"
+	ReasonUnknown     = "unknown"
+)
+
+// PingError is a ping failure classified by its reason.
+type PingError struct {
+	Reason string
+	Err    error
+}
+
+func (e *PingError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// protocolError is returned when a server answers with something that does not
+// follow the protocol we spoke to it.
+func protocolError(msg string) error {
+	return &PingError{Reason: ReasonProtocol, Err: errors.New(msg)}
+}
+
+// classifyPingError maps any error returned by a pinger to a PingError.
+// Errors already typed by the pinger keep their reason; network errors are
+// classified by their cause.
+func classifyPingError(err error) *PingError {
+	var pe *PingError
+	if errors.As(err, &pe) {
+		return pe
+	}
+
+	reason := ReasonUnknown
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &dnsErr):
+		reason = ReasonDNS
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		reason = ReasonTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		reason = ReasonRefused
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, net.ErrClosed):
+		reason = ReasonConnection
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		reason = ReasonUnreachable
+	case errors.As(err, &opErr) && opErr.Op == "read":
+		// ICMP port unreachable on a UDP socket surfaces as a read error.
+		reason = ReasonUnreachable
+	}
+
+	return &PingError{Reason: reason, Err: err}
+}
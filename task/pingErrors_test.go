@@ -0,0 +1,47 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyPingError(t *testing.T) {
+	typed := &PingError{Reason: ReasonJSON, Err: errors.New("bad status")}
+
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"typed", typed, ReasonJSON},
+		{"wrapped typed", fmt.Errorf("ping: %w", typed), ReasonJSON},
+		{"protocol", protocolError("bad packet"), ReasonProtocol},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nx.example", IsNotFound: true}, ReasonDNS},
+		{"deadline", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ReasonTimeout},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}, ReasonTimeout},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ReasonRefused},
+		{"eof", io.EOF, ReasonConnection},
+		{"unexpected eof", fmt.Errorf("status: %w", io.ErrUnexpectedEOF), ReasonConnection},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ReasonConnection},
+		{"closed", &net.OpError{Op: "read", Net: "udp", Err: net.ErrClosed}, ReasonConnection},
+		{"no route", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, ReasonUnreachable},
+		{"icmp on udp", &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.EHOSTUNREACH)}, ReasonUnreachable},
+		{"other", errors.New("something else"), ReasonUnknown},
+	}
+
+	for _, tc := range cases {
+		got := classifyPingError(tc.err)
+		if got.Reason != tc.want {
+			t.Errorf("%s: reason %q, want %q", tc.name, got.Reason, tc.want)
+		}
+		if !errors.Is(tc.err, got.Err) {
+			t.Errorf("%s: classified error lost the cause %v", tc.name, tc.err)
+		}
+	}
+}
@@ -6,20 +6,18 @@ import (
 	"MineTracker/util"
 	"MineTracker/websocket"
 	"context"
-	"strconv"
-	"strings"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 const (
-	influxQueueSize  = 500
+	historyQueueSize = 500
 	bulkFlushSize    = 300
 	dbWriteQueueSize = 1000
 
@@ -43,43 +41,52 @@ var (
 	serverCacheMap = make(map[string]data.Server, 128)
 )
 
-// GetAllServers returns a snapshot of every active server's live state.
-func GetAllServers() []data.Server {
+// ListServers returns the live state of every known server, including
+// inactive and offline ones.
+func ListServers() []data.Server {
 	serverCacheMu.RLock()
 	defer serverCacheMu.RUnlock()
 	result := make([]data.Server, 0, len(serverCacheMap))
 	for _, s := range serverCacheMap {
-		if s.Active && s.Online {
-			result = append(result, s)
-		}
+		result = append(result, s)
 	}
 	return result
 }
 
+// GetServer returns the live state of a single server by ID.
+func GetServer(id string) (data.Server, bool) {
+	serverCacheMu.RLock()
+	defer serverCacheMu.RUnlock()
+	s, ok := serverCacheMap[id]
+	return s, ok
+}
+
 // isServerActive reports whether a server is marked active in the cache.
 // Servers not yet cached (first encounter) are treated as active by default.
-func isServerActive(ip string) bool {
+func isServerActive(id string) bool {
 	serverCacheMu.RLock()
 	defer serverCacheMu.RUnlock()
-	s, ok := serverCacheMap[ip]
+	s, ok := serverCacheMap[id]
 	if !ok {
 		return true
 	}
 	return s.Active
 }
 
+// parseAddress splits a server address (hostname, IPv4, [IPv6]:port or bare
+// IPv6) into its host and optional port.
 func parseAddress(addr string) (host string, port *uint16) {
-	parts := strings.Split(addr, ":")
-	host = parts[0]
-
-	if len(parts) == 2 {
-		p, err := strconv.ParseUint(parts[1], 10, 16)
-		if err == nil {
-			parsed := uint16(p)
-			port = &parsed
-		}
+	parsed, err := data.ParseAddress(addr)
+	if err != nil {
+		// LoadServers rejects invalid addresses, so only addresses that
+		// bypassed validation end up here; let the ping report the failure.
+		return addr, nil
 	}
 
+	host = parsed.Host
+	if parsed.Port != 0 {
+		port = &parsed.Port
+	}
 	return
 }
 
@@ -90,111 +97,91 @@ func portOrDefault(port *uint16, def uint16) uint16 {
 	return *port
 }
 
-func NewServerJob(interval time.Duration, servers []data.PingableServer) *PingJob {
-	return &PingJob{
-		interval: interval,
-		servers:  servers,
-	}
-}
-
-func (j *PingJob) StartServerJob(ctx context.Context) {
-	var wg sync.WaitGroup
+// defaultPingTimeout is used for servers without a timeout_ms override.
+const defaultPingTimeout = 2 * time.Second
 
-	// Spread goroutine startup evenly across one full tick interval so that
-	// pings never all fire simultaneously. Without this, all 63 goroutines
-	// wake at t=0, 1, 2, ... causing a burst of allocations that triggers
-	// continuous GC (bufio.Reader, json.Decoder, maps per ping × 63).
-	var stagger time.Duration
-	if n := len(j.servers); n > 1 {
-		stagger = time.Second / time.Duration(n)
+// pingOptionsFor applies a server's per-server overrides. The handshake always
+// carries the user-facing hostname unless handshake_host says otherwise, and a
+// connect_address bypasses SRV since it is already the exact target.
+func pingOptionsFor(server data.PingableServer) pingOptions {
+	host, port := parseAddress(server.IP)
+	opts := pingOptions{
+		Host:          host,
+		Port:          portOrDefault(port, defaultPort(server.Type)),
+		HandshakeHost: host,
+		Timeout:       defaultPingTimeout,
+		Protocol:      server.Protocol,
 	}
 
-	for i, server := range j.servers {
-		wg.Add(1)
-		go func(srv data.PingableServer, idx int) {
-			defer wg.Done()
-			if idx > 0 {
-				select {
-				case <-time.After(time.Duration(idx) * stagger):
-				case <-ctx.Done():
-					return
-				}
-			}
-			j.runServerLoop(ctx, srv)
-		}(server, i)
+	if server.ConnectAddress != "" {
+		connectHost, connectPort := parseAddress(server.ConnectAddress)
+		opts.Host = connectHost
+		opts.Port = portOrDefault(connectPort, opts.Port)
+		opts.SkipSRV = true
+	}
+	if server.HandshakeHost != "" {
+		opts.HandshakeHost = server.HandshakeHost
+	}
+	if server.TimeoutMs > 0 {
+		opts.Timeout = time.Duration(server.TimeoutMs) * time.Millisecond
 	}
 
-	<-ctx.Done()
-	util.Logger.Info().Msg("Stopped data ping job.")
-	wg.Wait()
+	return opts
 }
 
-func (j *PingJob) runServerLoop(ctx context.Context, server data.PingableServer) {
-	notifyChan := websocket.GlobalHub.RegisterServerNotify(server.IP)
-	defer websocket.GlobalHub.UnregisterServerNotify(server.IP)
-
-	pinger := newPooledPinger()
-
-	var customInterval time.Duration
-	if server.Interval > 0 {
-		customInterval = time.Duration(server.Interval) * time.Second
+// queryTarget returns where the GameSpy4 query of a server goes: the host
+// the ping connected to after connect_address and SRV, on query_port or else
+// the port the ping used.
+func queryTarget(server data.PingableServer, resolvedAddress string) (string, uint16) {
+	opts := pingOptionsFor(server)
+	host, port := opts.Host, opts.Port
+	if resolvedAddress != "" {
+		resolvedHost, resolvedPort := parseAddress(resolvedAddress)
+		host, port = resolvedHost, portOrDefault(resolvedPort, port)
 	}
-
-	getCurrentInterval := func() time.Duration {
-		if customInterval > 0 {
-			return customInterval
-		}
-
-		if websocket.GlobalHub.IsSubscribed(server.IP) {
-			return 1 * time.Second
-		}
-		return 10 * time.Second
+	if server.QueryPort > 0 {
+		port = uint16(server.QueryPort)
 	}
+	return host, port
+}
 
-	ticker := time.NewTicker(getCurrentInterval())
-	defer ticker.Stop()
+// durationMillis converts a duration to fractional milliseconds for the history.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
 
-	if isServerActive(server.IP) {
-		j.pingServer(server, pinger)
+// NewServerJob creates the job and queues the initial servers right away, so
+// that AddServer calls from the servers file watcher, which may run before
+// StartServerJob, update these entries instead of queueing them twice.
+func NewServerJob(interval time.Duration, servers []data.PingableServer) *PingJob {
+	j := &PingJob{
+		interval: interval,
+		servers:  servers,
+		entries:  make(map[string]*scheduledServer, len(servers)),
+		wake:     make(chan struct{}, 1),
+		policy:   loadIntervalPolicy(),
 	}
 
-	lastInterval := getCurrentInterval()
-
-	for {
-		select {
-		case <-ticker.C:
-			if isServerActive(server.IP) {
-				j.pingServer(server, pinger)
-			}
-
-			newInterval := getCurrentInterval()
-			if newInterval != lastInterval {
-				ticker.Reset(newInterval)
-				lastInterval = newInterval
-			}
-
-		case _, ok := <-notifyChan:
-			if !ok {
-				return
-			}
-			newInterval := getCurrentInterval()
-			if newInterval != lastInterval {
-				ticker.Reset(newInterval)
-				lastInterval = newInterval
-
-				if newInterval < lastInterval && isServerActive(server.IP) {
-					j.pingServer(server, pinger)
-				}
-			}
-
-		case <-ctx.Done():
-			return
+	// Spread the first pings evenly across one second so they never all
+	// fire simultaneously, which caused a burst of allocations and
+	// continuous GC with one goroutine per server.
+	now := time.Now()
+	var stagger time.Duration
+	if n := len(servers); n > 1 {
+		stagger = time.Second / time.Duration(n)
+	}
+	for i, server := range servers {
+		if _, ok := j.entries[server.ID]; ok {
+			j.updateLocked(server)
+			continue
 		}
+		j.addLocked(server, now.Add(time.Duration(i)*stagger))
 	}
+	return j
 }
 
-var influxQueue = make(chan *write.Point, influxQueueSize)
-var droppedInfluxPoints uint64
+var historyQueue = make(chan data.SeriesPoint, historyQueueSize)
+var droppedHistoryPoints uint64
 
 func LoadServerCache(ctx context.Context) error {
 	collection := database.MongoClient.
@@ -222,7 +209,11 @@ func LoadServerCache(ctx context.Context) error {
 	serverCacheMu.Lock()
 	defer serverCacheMu.Unlock()
 	for _, server := range servers {
-		serverCacheMap[server.IP] = server
+		// Documents of servers removed before IDs existed are never migrated.
+		if server.ID == "" {
+			continue
+		}
+		serverCacheMap[server.ID] = server
 	}
 
 	return nil
@@ -251,14 +242,14 @@ func refreshActiveStatus(ctx context.Context) {
 		Collection("servers")
 
 	type activeEntry struct {
-		IP     string `bson:"ip"`
+		ID     string `bson:"id"`
 		Active bool   `bson:"active"`
 	}
 
 	cursor, err := collection.Find(
 		ctx,
 		bson.M{},
-		options.Find().SetProjection(bson.M{"ip": 1, "active": 1}),
+		options.Find().SetProjection(bson.M{"id": 1, "active": 1}),
 	)
 	if err != nil {
 		util.Logger.Warn().Err(err).Msg("Failed to refresh active status from MongoDB")
@@ -272,39 +263,60 @@ func refreshActiveStatus(ctx context.Context) {
 		return
 	}
 
+	var changed []activeEntry
 	serverCacheMu.Lock()
-	defer serverCacheMu.Unlock()
 	for _, e := range entries {
-		if s, ok := serverCacheMap[e.IP]; ok {
+		if s, ok := serverCacheMap[e.ID]; ok {
+			if s.Active != e.Active {
+				changed = append(changed, e)
+			}
 			s.Active = e.Active
-			serverCacheMap[e.IP] = s
+			serverCacheMap[e.ID] = s
+		}
+	}
+	serverCacheMu.Unlock()
+
+	// Suspended servers are not in the scheduler queue, so reactivated
+	// servers have to be resumed explicitly.
+	if j := runningJob.Load(); j != nil {
+		for _, e := range changed {
+			if e.Active {
+				j.Resume(e.ID)
+			}
 		}
 	}
 }
 
-func StartInfluxWriter(ctx context.Context) {
-	writeApi := database.InfluxClient.
-		WriteAPI(database.GetInfluxOrg(), database.GetInfluxBucket())
+// StartHistoryWriter drains the history queue into data.TimeSeries. When ctx
+// ends it closes the store and then the returned channel.
+func StartHistoryWriter(ctx context.Context) <-chan struct{} {
+	store := data.TimeSeries
+	done := make(chan struct{})
 
 	go func() {
-		defer writeApi.Flush()
+		defer close(done)
+		defer func() {
+			if err := store.Close(); err != nil {
+				util.Logger.Error().Err(err).Msg("Failed to flush time-series store")
+			}
+		}()
 
 		for {
 			select {
-			case point, ok := <-influxQueue:
+			case point, ok := <-historyQueue:
 				if !ok {
 					return
 				}
-				writeApi.WritePoint(point)
+				store.Write(point)
 
 			case <-ctx.Done():
 				for {
 					select {
-					case point, ok := <-influxQueue:
+					case point, ok := <-historyQueue:
 						if !ok {
 							return
 						}
-						writeApi.WritePoint(point)
+						store.Write(point)
 					default:
 						return
 					}
@@ -312,6 +324,8 @@ func StartInfluxWriter(ctx context.Context) {
 			}
 		}
 	}()
+
+	return done
 }
 
 func StartDBWriter(ctx context.Context) {
@@ -344,7 +358,7 @@ func StartDBWriter(ctx context.Context) {
 					return
 				}
 				model := mongo.NewUpdateOneModel().
-					SetFilter(bson.M{"ip": op.server.IP}).
+					SetFilter(bson.M{"id": op.server.ID}).
 					SetUpdate(bson.M{"$set": op.server}).
 					SetUpsert(true)
 				bulkOps = append(bulkOps, model)
@@ -365,7 +379,7 @@ func StartDBWriter(ctx context.Context) {
 							return
 						}
 						model := mongo.NewUpdateOneModel().
-							SetFilter(bson.M{"ip": op.server.IP}).
+							SetFilter(bson.M{"id": op.server.ID}).
 							SetUpdate(bson.M{"$set": op.server}).
 							SetUpsert(true)
 						bulkOps = append(bulkOps, model)
@@ -382,57 +396,102 @@ func StartDBWriter(ctx context.Context) {
 	}()
 }
 
-func (j *PingJob) pingServer(server data.PingableServer, pinger serverPinger) {
-	host, port := parseAddress(server.IP)
-
-	// Acquire concurrency slot before opening a TCP connection.
+// pingServer pings a server once and records the result. When canRetry is
+// set a failure is not recorded; pingServer reports true instead so that the
+// caller can schedule the retry.
+func (j *PingJob) pingServer(server data.PingableServer, pinger serverPinger, canRetry bool) bool {
+	// pingOnce acquires a concurrency slot before opening a connection.
 	// This prevents all 63 goroutines from hammering the allocator simultaneously.
-	pingLimit <- struct{}{}
-	resp, err := pinger.ping(
-		host,
-		portOrDefault(port, 25565),
-		2*time.Second,
-	)
-	<-pingLimit
+	resp, err := pingOnce(pinger, pingOptionsFor(server))
 
 	if err != nil {
+		if canRetry {
+			return true
+		}
+
+		perr := classifyPingError(err)
+		now := time.Now()
+
+		// A single failure only makes the server suspect; it is reported
+		// offline once the failure threshold is reached.
 		serverCacheMu.Lock()
-		existing, ok := serverCacheMap[server.IP]
+		existing, ok := serverCacheMap[server.ID]
 		if ok {
-			existing.Online = false
-			serverCacheMap[server.IP] = existing
+			existing.State = recordFailure(server.ID, existing, ok)
+			existing.Online = isOnlineState(existing.State)
+			existing.LastError = perr.Error()
+			existing.LastErrorReason = perr.Reason
+			existing.LastErrorAt = now.Unix()
+
+			// Copy on write: the previous map may still be referenced by a
+			// document waiting in dbWriteQueue.
+			counts := make(map[string]int64, len(existing.FailureCounts)+1)
+			for reason, n := range existing.FailureCounts {
+				counts[reason] = n
+			}
+			counts[perr.Reason]++
+			existing.FailureCounts = counts
+
+			serverCacheMap[server.ID] = existing
 		}
 		serverCacheMu.Unlock()
 
+		failurePoint := data.SeriesPoint{
+			Measurement: "ping_failure",
+			Tags: map[string]string{
+				"id":     server.ID,
+				"type":   server.Type,
+				"reason": perr.Reason,
+			},
+			Fields: map[string]interface{}{
+				"count":   1,
+				"message": perr.Err.Error(),
+			},
+			Time: now,
+		}
+
+		select {
+		case historyQueue <- failurePoint:
+		default:
+			atomic.AddUint64(&droppedHistoryPoints, 1)
+		}
+
 		if ok {
 			select {
 			case dbWriteQueue <- dbWriteOp{server: existing}:
 			default:
 			}
+			publishGroupTotals(server.ID)
 		}
-		return
+		return false
 	}
 
 	pc := resp.PlayerCount
+	latency := int(resp.Latency.Milliseconds())
 
-	websocket.GlobalHub.SendToServer(server.IP, map[string]interface{}{
+	websocket.GlobalHub.SendToServer(server.ID, map[string]interface{}{
 		"type": "data_point_rt",
 		"data": data.ServerDataPoint{
+			ID:          server.ID,
 			Timestamp:   time.Now().Unix(),
 			PlayerCount: pc,
+			Latency:     latency,
 			Ip:          server.IP,
 			Name:        server.Name,
 		},
 	})
 
 	serverCacheMu.RLock()
-	existing, found := serverCacheMap[server.IP]
+	existing, found := serverCacheMap[server.ID]
 	serverCacheMu.RUnlock()
 
+	existing.ID = server.ID
 	existing.Name = server.Name
 	existing.IP = server.IP
 	existing.Type = server.Type
-	existing.Online = true
+	existing.Metadata = server.Metadata
+	existing.State = recordSuccess(server.ID, existing, found)
+	existing.Online = isOnlineState(existing.State)
 	if !found {
 		existing.Active = true
 	}
@@ -446,32 +505,83 @@ func (j *PingJob) pingServer(server data.PingableServer, pinger serverPinger) {
 	if resp.Favicon != "" {
 		existing.Icon = resp.Favicon
 	}
+	existing.MaxPlayers = resp.MaxPlayers
+	existing.Version = resp.Version
+	existing.Protocol = resp.Protocol
+	existing.MOTD = resp.MOTD
+	existing.GameMode = resp.GameMode
+	existing.Sample = resp.Sample
+	existing.EnforcesSecureChat = resp.EnforcesSecureChat
+	existing.ModLoader = resp.ModLoader
+	existing.Mods = resp.Mods
+	existing.Latency = latency
+
+	// Dual-stack dials may land on either family, so only a change in what
+	// DNS returned is worth logging.
+	if !slices.Equal(resp.ResolvedAddresses, existing.ResolvedAddresses) {
+		if len(existing.ResolvedAddresses) > 0 {
+			util.Logger.Info().
+				Str("server", server.Name).
+				Strs("from", existing.ResolvedAddresses).
+				Strs("to", resp.ResolvedAddresses).
+				Msg("Resolved address changed")
+		}
+		existing.ResolvedAddresses = resp.ResolvedAddresses
+	}
+	existing.ResolvedAddress = resp.ResolvedAddress
+
+	// Query is best effort: SLP already proved the server is online, so a
+	// firewalled or disabled query port only leaves the previous values.
+	if server.Query {
+		host, queryPort := queryTarget(server, resp.ResolvedAddress)
+
+		pingLimit <- struct{}{}
+		qr, qerr := newQueryClient().query(host, queryPort, 2*time.Second)
+		<-pingLimit
+
+		if qerr != nil {
+			util.Logger.Debug().Err(qerr).Str("ip", server.IP).Msg("Query failed")
+		} else {
+			existing.Players = qr.Players
+			existing.Plugins = qr.Plugins
+			existing.Map = qr.Map
+			existing.Software = qr.Software
+		}
+	}
 
 	serverCacheMu.Lock()
-	serverCacheMap[server.IP] = existing
+	serverCacheMap[server.ID] = existing
 	serverCacheMu.Unlock()
 
+	publishGroupTotals(server.ID)
+
 	select {
 	case dbWriteQueue <- dbWriteOp{server: existing}:
 	default:
 	}
 
-	point := write.NewPoint(
-		"server_data",
-		map[string]string{
-			"ip":   server.IP,
+	point := data.SeriesPoint{
+		Measurement: "server_data",
+		// Only the stable ID identifies the series; address and name live in
+		// MongoDB so renames and moves do not split the history.
+		Tags: map[string]string{
+			"id":   server.ID,
 			"type": server.Type,
-			"name": server.Name,
 		},
-		map[string]interface{}{
+		Fields: map[string]interface{}{
 			"player_count": existing.PlayerCount,
+			"latency":      durationMillis(resp.Latency),
+			"dns_time":     durationMillis(resp.DNSTime),
+			"connect_time": durationMillis(resp.ConnectTime),
+			"total_time":   durationMillis(resp.TotalTime),
 		},
-		time.Now(),
-	)
+		Time: time.Now(),
+	}
 
 	select {
-	case influxQueue <- point:
+	case historyQueue <- point:
 	default:
-		atomic.AddUint64(&droppedInfluxPoints, 1)
+		atomic.AddUint64(&droppedHistoryPoints, 1)
 	}
+	return false
 }
@@ -1,78 +1,267 @@
 package task
 
 import (
+	"MineTracker/data"
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"io"
 	"net"
-	"strconv"
-	"strings"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// mcPingResult holds only the fields we use from a Minecraft SLP response.
+// mcPingResult holds the fields we keep from a Minecraft status response.
+// Legacy SLP and the Bedrock pong only fill the player counts, version and
+// MOTD; Favicon, Sample, EnforcesSecureChat and the mod fields are modern SLP
+// only, and GameMode is Bedrock only.
 type mcPingResult struct {
-	PlayerCount int
-	Favicon     string
+	PlayerCount        int
+	MaxPlayers         int
+	Favicon            string
+	Protocol           int
+	Version            string
+	MOTD               string
+	GameMode           string
+	Sample             []data.PlayerSample
+	EnforcesSecureChat bool
+	ModLoader          string
+	Mods               []data.ModInfo
+
+	// Timings of the ping itself. Latency is the Ping/Pong (or, if the server
+	// does not answer it, the status) round trip.
+	Latency     time.Duration
+	DNSTime     time.Duration
+	ConnectTime time.Duration
+	TotalTime   time.Duration
+
+	// ResolvedAddress is the ip:port the ping actually connected to after
+	// SRV and A/AAAA resolution; ResolvedAddresses is every candidate.
+	ResolvedAddress   string
+	ResolvedAddresses []string
+}
+
+// pingOptions describes a single ping. Host and Port are where the ping
+// connects to; HandshakeHost is the address sent inside the handshake, which
+// proxies such as TCPShield route on and therefore must stay the user-facing
+// hostname even when SRV points elsewhere.
+type pingOptions struct {
+	Host          string
+	Port          uint16
+	HandshakeHost string
+	SkipSRV       bool
+	Timeout       time.Duration
+	Protocol      int
 }
 
-// serverPinger is satisfied by pooledPinger (and by test fakes).
+// defaultProtocol is sent in the handshake when a server has no override.
+// 47 (1.8) is accepted by all modern servers for status requests.
+const defaultProtocol = 47
+
+// serverPinger is satisfied by pooledPinger, bedrockPinger (and by test fakes).
 type serverPinger interface {
-	ping(host string, port uint16, timeout time.Duration) (*mcPingResult, error)
+	ping(opts pingOptions) (*mcPingResult, error)
+}
+
+// newServerPinger returns the pinger implementation matching a server's type.
+func newServerPinger(serverType string) serverPinger {
+	if data.IsBedrock(serverType) {
+		return newBedrockPinger()
+	}
+	return newPooledPinger()
+}
+
+// defaultPort returns the port used when a server address has none.
+func defaultPort(serverType string) uint16 {
+	return data.DefaultPort(serverType)
 }
 
-// pooledPinger performs Minecraft Server List Ping (SLP) using a sync.Pool of
-// bufio.Readers. The pool reuses the 4KB internal read buffer instead of
-// allocating a fresh one on every call — which was the dominant source of GC
-// pressure in the previous go-mcping-based implementation.
+// readerPool holds the bufio.Readers used by every pooledPinger. The pool
+// reuses the 4KB internal read buffer instead of allocating a fresh one on
+// every call — which was the dominant source of GC pressure in the previous
+// go-mcping-based implementation. It is shared so that the per-server pinger
+// stays a few bytes even with thousands of servers.
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, 4096)
+	},
+}
+
+// pooledPinger performs Minecraft Server List Ping (SLP) using readerPool.
+//
+// Servers that do not understand the modern handshake are retried with the
+// legacy (pre-1.7) pings. The flavour that last succeeded is remembered so
+// subsequent pings go straight to it.
 type pooledPinger struct {
-	pool sync.Pool
+	flavour atomic.Int32
 }
 
 func newPooledPinger() *pooledPinger {
-	return &pooledPinger{
-		pool: sync.Pool{
-			New: func() interface{} {
-				return bufio.NewReaderSize(nil, 4096)
-			},
-		},
-	}
+	return &pooledPinger{}
 }
 
-func (p *pooledPinger) ping(host string, port uint16, timeout time.Duration) (*mcPingResult, error) {
+func (p *pooledPinger) ping(opts pingOptions) (*mcPingResult, error) {
+	start := time.Now()
+	host, port := opts.Host, opts.Port
+	if opts.HandshakeHost == "" {
+		opts.HandshakeHost = host
+	}
+
 	// SRV resolution: _minecraft._tcp.<host>
-	// Matches go-mcping behaviour; fails quickly (NXDOMAIN) for plain IPs.
-	if _, srvs, err := net.LookupSRV("minecraft", "tcp", host); err == nil && len(srvs) > 0 {
-		host = strings.TrimSuffix(srvs[0].Target, ".")
-		port = srvs[0].Port
+	// Matches go-mcping behaviour. Answers (including NXDOMAIN for hosts
+	// without SRV) are cached by pingResolver, so this is usually free.
+	// Only the connect address changes; the handshake keeps the original name.
+	if !opts.SkipSRV {
+		if target, srvPort, ok := pingResolver.lookupSRV(host); ok {
+			host = target
+			port = srvPort
+		}
 	}
 
-	conn, err := net.DialTimeout("tcp", host+":"+strconv.FormatUint(uint64(port), 10), timeout)
+	// Resolve up front so DNS time is measured separately from connect time.
+	addrs, err := pingResolver.lookupIP(host)
+	if err != nil {
+		return nil, &PingError{Reason: ReasonDNS, Err: err}
+	}
+	dnsTime := time.Since(start)
+
+	preferred := slpFlavour(p.flavour.Load())
+	result, err := p.pingWith(preferred, opts, addrs, port)
+	if err != nil && shouldFallback(err) {
+		for _, f := range slpFlavours {
+			if f == preferred {
+				continue
+			}
+			res, ferr := p.pingWith(f, opts, addrs, port)
+			if ferr == nil {
+				p.flavour.Store(int32(f))
+				result, err = res, nil
+				break
+			}
+			if !shouldFallback(ferr) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.DNSTime = dnsTime
+	result.ResolvedAddresses = addressSet(addrs, port)
+	result.TotalTime = time.Since(start)
+	return result, nil
+}
+
+// pingWith runs a single ping over a fresh connection using the given flavour.
+func (p *pooledPinger) pingWith(flavour slpFlavour, opts pingOptions, addrs []netip.Addr, port uint16) (*mcPingResult, error) {
+	connectStart := time.Now()
+	conn, err := dialDualStack(addrs, port, opts.Timeout)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(timeout)) //nolint:errcheck
+	connectTime := time.Since(connectStart)
+	conn.SetDeadline(time.Now().Add(opts.Timeout)) //nolint:errcheck
 
-	if err := slpSendHandshake(conn, host, port); err != nil {
+	sent := time.Now()
+	switch flavour {
+	case slpLegacy16:
+		err = legacySendPing16(conn, opts.HandshakeHost, port)
+	case slpLegacyBeta:
+		err = legacySendPingBeta(conn)
+	default:
+		protocol := opts.Protocol
+		if protocol <= 0 {
+			protocol = defaultProtocol
+		}
+		err = slpSendHandshake(conn, opts.HandshakeHost, port, protocol)
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	br := p.pool.Get().(*bufio.Reader)
+	br := readerPool.Get().(*bufio.Reader)
 	br.Reset(conn)
 	// pool.Put happens after slpReadResponse returns so that the zero-copy
 	// Peek path inside slpReadResponse can safely borrow br's internal buffer.
-	result, err := slpReadResponse(br)
-	p.pool.Put(br)
+	var result *mcPingResult
+	if flavour == slpModern {
+		result, err = slpReadResponse(br)
+	} else {
+		result, err = legacyReadResponse(br)
+	}
+	if err == nil {
+		// Until the Pong arrives, the status round trip is the best estimate.
+		result.Latency = time.Since(sent)
+		if flavour == slpModern {
+			if rtt, perr := slpPingPong(conn, br); perr == nil {
+				result.Latency = rtt
+			}
+		}
+		result.ConnectTime = connectTime
+		result.ResolvedAddress = conn.RemoteAddr().String()
+	}
+	readerPool.Put(br)
 	return result, err
 }
 
+// slpPingPong sends the status Ping (0x01) packet and times the matching Pong.
+// Some servers close the connection right after the status response, so a
+// failure here is not treated as a failed ping.
+func slpPingPong(conn net.Conn, r *bufio.Reader) (time.Duration, error) {
+	var pkt [10]byte
+	pkt[0] = 0x09 // length: ID + int64 payload
+	pkt[1] = 0x01
+	payload := uint64(time.Now().UnixNano())
+	binary.BigEndian.PutUint64(pkt[2:], payload)
+
+	sent := time.Now()
+	if _, err := conn.Write(pkt[:]); err != nil {
+		return 0, err
+	}
+
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return 0, err
+	}
+	id, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if id != 0x01 {
+		return 0, protocolError("mcping: unexpected pong packet ID")
+	}
+	var echo [8]byte
+	if _, err := io.ReadFull(r, echo[:]); err != nil {
+		return 0, err
+	}
+	rtt := time.Since(sent)
+	if binary.BigEndian.Uint64(echo[:]) != payload {
+		return 0, protocolError("mcping: pong payload mismatch")
+	}
+	return rtt, nil
+}
+
+// shouldFallback reports whether a failed ping is worth retrying with another
+// protocol flavour. DNS failures, refused dials and timeouts mean the host is
+// unreachable, so trying a different handshake would only waste time.
+func shouldFallback(err error) bool {
+	switch classifyPingError(err).Reason {
+	case ReasonDNS, ReasonRefused, ReasonTimeout, ReasonUnreachable:
+		return false
+	}
+	return true
+}
+
 // slpSendHandshake writes the SLP handshake + status-request packets in a
 // single conn.Write to avoid two separate syscalls.
-func slpSendHandshake(conn net.Conn, host string, port uint16) error {
+func slpSendHandshake(conn net.Conn, host string, port uint16, protocol int) error {
+	if len(host) > 255 {
+		return protocolError("mcping: handshake host too long")
+	}
+
 	// Maximum: 1(len) + 1(ID) + 5(proto) + 1(hostLen) + 255(host) + 2(port) + 1(state) + 2(req)
 	var buf [512]byte
 	n := 0
@@ -80,9 +269,9 @@ func slpSendHandshake(conn net.Conn, host string, port uint16) error {
 	// Build the packet body in a temporary buffer, then prefix with its varint length.
 	var body [300]byte
 	bn := 0
-	bn += putMCVarint(body[bn:], 0x00) // packet ID: handshake
-	bn += putMCVarint(body[bn:], 47)   // protocol 47 (1.8) — accepted by all modern servers
-	bn += putMCString(body[bn:], host) // server address
+	bn += putMCVarint(body[bn:], 0x00)             // packet ID: handshake
+	bn += putMCVarint(body[bn:], uint32(protocol)) // protocol version
+	bn += putMCString(body[bn:], host)             // server address
 	body[bn], body[bn+1] = byte(port>>8), byte(port)
 	bn += 2                         // server port (big-endian uint16)
 	bn += putMCVarint(body[bn:], 1) // next state: status
@@ -113,7 +302,7 @@ func slpReadResponse(r *bufio.Reader) (*mcPingResult, error) {
 		return nil, err
 	}
 	if id != 0x00 {
-		return nil, errors.New("mcping: unexpected packet ID")
+		return nil, protocolError("mcping: unexpected packet ID")
 	}
 
 	// JSON payload length.
@@ -122,7 +311,7 @@ func slpReadResponse(r *bufio.Reader) (*mcPingResult, error) {
 		return nil, err
 	}
 	if jsonLen < 2 || jsonLen > 1<<20 {
-		return nil, errors.New("mcping: invalid JSON length")
+		return nil, protocolError("mcping: invalid JSON length")
 	}
 
 	// Read JSON bytes.
@@ -144,16 +333,13 @@ func slpReadResponse(r *bufio.Reader) (*mcPingResult, error) {
 	// Unmarshal directly into a typed struct.
 	// The old go-mcping used json.NewDecoder → map[string]interface{} → jsonq,
 	// which boxed every JSON value as interface{} and allocated heavily.
-	var resp struct {
-		Players struct {
-			Online int `json:"online"`
-		} `json:"players"`
-		Favicon string `json:"favicon"`
-	}
+	// The description is kept raw because it is either a plain string or a
+	// chat component; it is flattened separately by chatText.
+	var resp slpStatus
 	if err := json.Unmarshal(jsonBytes, &resp); err != nil {
-		return nil, err
+		return nil, &PingError{Reason: ReasonJSON, Err: err}
 	}
-	return &mcPingResult{PlayerCount: resp.Players.Online, Favicon: resp.Favicon}, nil
+	return resp.result(), nil
 }
 
 // ---- Minecraft varint / string encoding helpers ----------------------------
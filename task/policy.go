@@ -0,0 +1,138 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/util"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"time"
+)
+
+// policyInput is everything an IntervalPolicy may base its decision on.
+type policyInput struct {
+	Server     data.PingableServer
+	Active     bool
+	Subscribed bool
+	State      string // health state, see data.ServerState*
+	Failures   int    // consecutive failed pings
+}
+
+// IntervalPolicy decides how long the scheduler waits before pinging a server
+// again. Returning ok=false suspends the server until it is resumed.
+type IntervalPolicy interface {
+	NextInterval(in policyInput) (interval time.Duration, ok bool)
+}
+
+// policyDuration is a time.Duration that unmarshals from "10s" style strings.
+type policyDuration time.Duration
+
+func (d *policyDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if parsed <= 0 {
+		return errors.New("duration must be positive")
+	}
+	*d = policyDuration(parsed)
+	return nil
+}
+
+// backoffPolicy is the default IntervalPolicy:
+//   - inactive servers are suspended,
+//   - the base interval is the per-server interval, else its tier's interval,
+//     else DefaultInterval,
+//   - confirmed-offline servers back off exponentially from the base up to
+//     MaxBackoff,
+//   - subscribed servers are pinged every SubscribedInterval unless they
+//     set their own interval,
+//   - everything else uses the base interval.
+type backoffPolicy struct {
+	DefaultInterval    policyDuration            `json:"default_interval"`
+	SubscribedInterval policyDuration            `json:"subscribed_interval"`
+	BackoffFactor      float64                   `json:"backoff_factor"`
+	MaxBackoff         policyDuration            `json:"max_backoff"`
+	Tiers              map[string]policyDuration `json:"tiers"`
+}
+
+func defaultBackoffPolicy() *backoffPolicy {
+	return &backoffPolicy{
+		DefaultInterval:    policyDuration(defaultInterval),
+		SubscribedInterval: policyDuration(subscribedInterval),
+		BackoffFactor:      2,
+		MaxBackoff:         policyDuration(5 * time.Minute),
+		Tiers:              map[string]policyDuration{},
+	}
+}
+
+// loadIntervalPolicy reads the policy from PING_POLICY_FILE (default
+// ping_policy.json). A missing file keeps the built-in defaults; fields left
+// out of the file keep their default value.
+func loadIntervalPolicy() IntervalPolicy {
+	policy := defaultBackoffPolicy()
+
+	path := os.Getenv("PING_POLICY_FILE")
+	if path == "" {
+		path = "ping_policy.json"
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			util.Logger.Warn().Err(err).Str("path", path).Msg("Failed to read ping policy, using defaults")
+		}
+		return policy
+	}
+
+	if err := json.Unmarshal(raw, policy); err != nil {
+		util.Logger.Warn().Err(err).Str("path", path).Msg("Invalid ping policy, using defaults")
+		return defaultBackoffPolicy()
+	}
+	if policy.BackoffFactor < 1 {
+		policy.BackoffFactor = 1
+	}
+
+	util.Logger.Info().Str("path", path).Int("tiers", len(policy.Tiers)).Msg("Loaded ping policy")
+	return policy
+}
+
+func (p *backoffPolicy) NextInterval(in policyInput) (time.Duration, bool) {
+	if !in.Active {
+		return 0, false
+	}
+
+	base := time.Duration(p.DefaultInterval)
+	if tier, ok := p.Tiers[in.Server.Tier]; ok && in.Server.Tier != "" {
+		base = time.Duration(tier)
+	}
+	explicit := in.Server.Interval > 0
+	if explicit {
+		base = time.Duration(in.Server.Interval) * time.Second
+	}
+
+	if in.State == data.ServerStateOffline {
+		// Failures counted past the offline threshold drive the exponent, so
+		// the first ping after going offline still happens at the base rate.
+		exp := in.Failures - pingHealthConfig.failureThreshold
+		if exp < 0 {
+			exp = 0
+		}
+		backoff := float64(base) * math.Pow(p.BackoffFactor, float64(exp))
+		limit := math.Max(float64(p.MaxBackoff), float64(base))
+		if backoff > limit {
+			backoff = limit
+		}
+		return time.Duration(backoff), true
+	}
+
+	if in.Subscribed && !explicit && time.Duration(p.SubscribedInterval) < base {
+		return time.Duration(p.SubscribedInterval), true
+	}
+	return base, true
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"MineTracker/data"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyNextInterval(t *testing.T) {
+	saved := pingHealthConfig
+	pingHealthConfig.failureThreshold = 3
+	t.Cleanup(func() { pingHealthConfig = saved })
+
+	p := &backoffPolicy{
+		DefaultInterval:    policyDuration(10 * time.Second),
+		SubscribedInterval: policyDuration(time.Second),
+		BackoffFactor:      2,
+		MaxBackoff:         policyDuration(time.Minute),
+		Tiers: map[string]policyDuration{
+			"featured": policyDuration(5 * time.Second),
+			"archive":  policyDuration(5 * time.Minute),
+		},
+	}
+	offline := func(failures int) policyInput {
+		return policyInput{Active: true, State: data.ServerStateOffline, Failures: failures}
+	}
+	withServer := func(in policyInput, s data.PingableServer) policyInput {
+		in.Server = s
+		return in
+	}
+
+	cases := []struct {
+		name string
+		in   policyInput
+		want time.Duration // 0 means suspended
+	}{
+		{"inactive", policyInput{State: data.ServerStateOnline}, 0},
+		{"inactive with interval", policyInput{Server: data.PingableServer{Interval: 30}}, 0},
+		{"default", policyInput{Active: true, State: data.ServerStateOnline}, 10 * time.Second},
+		{"never pinged", policyInput{Active: true}, 10 * time.Second},
+		{"tier", policyInput{Active: true, Server: data.PingableServer{Tier: "featured"}}, 5 * time.Second},
+		{"unknown tier", policyInput{Active: true, Server: data.PingableServer{Tier: "gold"}}, 10 * time.Second},
+		{"interval beats tier", policyInput{Active: true, Server: data.PingableServer{Tier: "featured", Interval: 30}}, 30 * time.Second},
+		{"subscribed", policyInput{Active: true, Subscribed: true}, time.Second},
+		{"subscribed keeps its interval", policyInput{Active: true, Subscribed: true, Server: data.PingableServer{Interval: 30}}, 30 * time.Second},
+		{"suspect is not backed off", policyInput{Active: true, State: data.ServerStateSuspect, Failures: 2}, 10 * time.Second},
+		{"offline at threshold", offline(3), 10 * time.Second},
+		{"offline backs off", offline(5), 40 * time.Second},
+		{"offline capped", offline(10), time.Minute},
+		{"offline subscribed still backs off", func() policyInput { in := offline(4); in.Subscribed = true; return in }(), 20 * time.Second},
+		{"offline with interval backs off", withServer(offline(4), data.PingableServer{Interval: 20}), 40 * time.Second},
+		{"offline with interval capped", withServer(offline(8), data.PingableServer{Interval: 20}), time.Minute},
+		{"offline base above cap", withServer(offline(6), data.PingableServer{Interval: 120}), 2 * time.Minute},
+		{"offline slow tier above cap", withServer(offline(6), data.PingableServer{Tier: "archive"}), 5 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		got, ok := p.NextInterval(tc.in)
+		if tc.want == 0 {
+			if ok {
+				t.Errorf("%s: got %s, want suspended", tc.name, got)
+			}
+			continue
+		}
+		if !ok || got != tc.want {
+			t.Errorf("%s: got %s (ok=%v), want %s", tc.name, got, ok, tc.want)
+		}
+	}
+}
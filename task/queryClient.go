@@ -0,0 +1,155 @@
+package task
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryResult holds the fields we keep from a GameSpy4 full stat response.
+type queryResult struct {
+	Players  []string
+	Plugins  []string
+	Map      string
+	Software string
+}
+
+const (
+	queryTypeHandshake = 0x09
+	queryTypeStat      = 0x00
+)
+
+// queryClient implements the GameSpy4 UDP Query protocol servers expose when
+// `enable-query` is set. Unlike SLP it returns the complete player list and
+// the plugin list, but it is optional and many hosts firewall it.
+type queryClient struct{}
+
+func newQueryClient() *queryClient {
+	return &queryClient{}
+}
+
+func (q *queryClient) query(host string, port uint16, timeout time.Duration) (*queryResult, error) {
+	addrs, err := pingResolver.lookupIP(host)
+	if err != nil {
+		return nil, &PingError{Reason: ReasonDNS, Err: err}
+	}
+	if len(addrs) == 0 {
+		return nil, &PingError{Reason: ReasonDNS, Err: &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}}
+	}
+
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrs[0], port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout)) //nolint:errcheck
+
+	// Only the lower 4 bits of each byte are used by vanilla servers.
+	session := rand.Uint32() & 0x0F0F0F0F
+
+	var buf [4096]byte
+
+	// Handshake: FE FD 09 [session] -> 09 [session] [challenge token as ASCII]\0
+	req := make([]byte, 0, 15)
+	req = append(req, 0xFE, 0xFD, queryTypeHandshake)
+	req = binary.BigEndian.AppendUint32(req, session)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+	if n < 6 || buf[0] != queryTypeHandshake || binary.BigEndian.Uint32(buf[1:5]) != session {
+		return nil, protocolError("query: invalid handshake response")
+	}
+	token, err := strconv.ParseInt(string(bytes.TrimRight(buf[5:n], "\x00")), 10, 32)
+	if err != nil {
+		return nil, protocolError("query: invalid challenge token")
+	}
+
+	// Full stat: FE FD 00 [session] [token] [4 byte padding]
+	req = req[:0]
+	req = append(req, 0xFE, 0xFD, queryTypeStat)
+	req = binary.BigEndian.AppendUint32(req, session)
+	req = binary.BigEndian.AppendUint32(req, uint32(int32(token)))
+	req = append(req, 0, 0, 0, 0)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	n, err = conn.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+	if n < 5 || buf[0] != queryTypeStat || binary.BigEndian.Uint32(buf[1:5]) != session {
+		return nil, protocolError("query: invalid stat response")
+	}
+
+	return parseFullStat(buf[5:n])
+}
+
+// parseFullStat parses the body of a full stat response:
+//
+//	splitnum\0\x80\0 <key\0value\0>... \0 \x01player_\0\0 <name\0>... \0
+func parseFullStat(body []byte) (*queryResult, error) {
+	const kvPadding = "splitnum\x00\x80\x00"
+	const playerPadding = "\x01player_\x00\x00"
+
+	if !bytes.HasPrefix(body, []byte(kvPadding)) {
+		return nil, protocolError("query: missing key/value section")
+	}
+	body = body[len(kvPadding):]
+
+	kvEnd := bytes.Index(body, []byte(playerPadding))
+	if kvEnd < 0 {
+		return nil, protocolError("query: missing player section")
+	}
+
+	values := make(map[string]string, 12)
+	fields := strings.Split(string(body[:kvEnd]), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "" {
+			break
+		}
+		values[fields[i]] = fields[i+1]
+	}
+
+	result := &queryResult{Map: values["map"]}
+	result.Software, result.Plugins = parseQueryPlugins(values["plugins"], values["version"])
+
+	for _, name := range strings.Split(string(body[kvEnd+len(playerPadding):]), "\x00") {
+		if name != "" {
+			result.Players = append(result.Players, name)
+		}
+	}
+
+	return result, nil
+}
+
+// parseQueryPlugins splits the Bukkit style "Software: Plugin A 1.0; Plugin B 2.0"
+// string. Vanilla servers leave it empty, so the version is used as software.
+func parseQueryPlugins(plugins, version string) (string, []string) {
+	if plugins == "" {
+		return version, nil
+	}
+
+	software, list, found := strings.Cut(plugins, ":")
+	if !found {
+		return strings.TrimSpace(plugins), nil
+	}
+
+	var result []string
+	for _, p := range strings.Split(list, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return strings.TrimSpace(software), result
+}
@@ -0,0 +1,99 @@
+package task
+
+import (
+	"MineTracker/data"
+	"slices"
+	"testing"
+)
+
+// fullStat builds a full stat body from key/value pairs and player names.
+func fullStat(kv []string, players ...string) []byte {
+	body := []byte("splitnum\x00\x80\x00")
+	for _, s := range kv {
+		body = append(body, s...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+	body = append(body, "\x01player_\x00\x00"...)
+	for _, name := range players {
+		body = append(body, name...)
+		body = append(body, 0)
+	}
+	return append(body, 0)
+}
+
+func TestParseFullStat(t *testing.T) {
+	cases := []struct {
+		name string
+		body []byte
+		want queryResult
+	}{
+		{
+			name: "vanilla",
+			body: fullStat([]string{"hostname", "A Server", "version", "1.20.4", "plugins", "", "map", "world"}, "Notch", "jeb_"),
+			want: queryResult{Players: []string{"Notch", "jeb_"}, Map: "world", Software: "1.20.4"},
+		},
+		{
+			name: "bukkit plugins",
+			body: fullStat([]string{"version", "1.20.4", "plugins", "Paper on 1.20.4: WorldEdit 7.2; Vault 1.7 ;", "map", "lobby"}),
+			want: queryResult{Plugins: []string{"WorldEdit 7.2", "Vault 1.7"}, Map: "lobby", Software: "Paper on 1.20.4"},
+		},
+		{
+			name: "software only",
+			body: fullStat([]string{"version", "1.20.4", "plugins", " CraftBukkit "}),
+			want: queryResult{Software: "CraftBukkit"},
+		},
+		{
+			// A trailing key without a value is ignored.
+			name: "odd key/value count",
+			body: fullStat([]string{"map", "world", "version"}, "Notch"),
+			want: queryResult{Players: []string{"Notch"}, Map: "world"},
+		},
+	}
+
+	for _, tc := range cases {
+		got, err := parseFullStat(tc.body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(got.Players, tc.want.Players) || !slices.Equal(got.Plugins, tc.want.Plugins) ||
+			got.Map != tc.want.Map || got.Software != tc.want.Software {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *got, tc.want)
+		}
+	}
+
+	for name, body := range map[string][]byte{
+		"empty":          nil,
+		"no padding":     []byte("hostname\x00A Server\x00\x00\x01player_\x00\x00\x00"),
+		"no player list": []byte("splitnum\x00\x80\x00hostname\x00A Server\x00\x00"),
+	} {
+		if _, err := parseFullStat(body); err == nil || classifyPingError(err).Reason != ReasonProtocol {
+			t.Errorf("%s: got %v, want a protocol error", name, err)
+		}
+	}
+}
+
+func TestQueryTarget(t *testing.T) {
+	cases := []struct {
+		name     string
+		server   data.PingableServer
+		resolved string
+		host     string
+		port     uint16
+	}{
+		{"resolved address", data.PingableServer{IP: "play.example.com", Type: data.ServerTypeJava}, "192.0.2.10:25570", "192.0.2.10", 25570},
+		{"resolved IPv6", data.PingableServer{IP: "play.example.com", Type: data.ServerTypeJava}, "[2001:db8::1]:25565", "2001:db8::1", 25565},
+		{"query port override", data.PingableServer{IP: "play.example.com", Type: data.ServerTypeJava, QueryPort: 25575}, "192.0.2.10:25570", "192.0.2.10", 25575},
+		{"connect address", data.PingableServer{IP: "play.example.com", ConnectAddress: "10.0.0.5:25566", Type: data.ServerTypeJava}, "", "10.0.0.5", 25566},
+		{"address only", data.PingableServer{IP: "play.example.com:25570", Type: data.ServerTypeJava}, "", "play.example.com", 25570},
+		{"default port", data.PingableServer{IP: "play.example.com", Type: data.ServerTypeJava}, "", "play.example.com", 25565},
+	}
+
+	for _, tc := range cases {
+		host, port := queryTarget(tc.server, tc.resolved)
+		if host != tc.host || port != tc.port {
+			t.Errorf("%s: got %s:%d, want %s:%d", tc.name, host, port, tc.host, tc.port)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/util"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serversWatchInterval is how often the servers file is checked for changes.
+// Polling the modification time keeps this dependency free and also works on
+// bind mounts and network filesystems where inotify events are unreliable.
+var serversWatchInterval = envDuration("SERVERS_WATCH_INTERVAL", 5*time.Second)
+
+// reloadSummary counts what a reload changed.
+type reloadSummary struct {
+	Added   int
+	Removed int
+	Updated int
+}
+
+// WatchServersFile re-imports path into the tracked servers collection
+// whenever it changes on disk or the process receives SIGHUP, and applies the
+// resulting difference to the running job. A file that fails to load is
+// rejected and the running server list is kept.
+func WatchServersFile(ctx context.Context, path string, j *PingJob) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	lastMod, lastSize := statFile(path)
+
+	ticker := time.NewTicker(serversWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mod, size := statFile(path)
+			if mod.Equal(lastMod) && size == lastSize {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			reloadServersFile(ctx, path, j, "file change")
+
+		case <-hup:
+			lastMod, lastSize = statFile(path)
+			reloadServersFile(ctx, path, j, "SIGHUP")
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func statFile(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
+
+func reloadServersFile(ctx context.Context, path string, j *PingJob, trigger string) {
+	file, err := data.LoadServersFile(path)
+	if err != nil {
+		util.Logger.Error().Err(err).Str("path", path).Str("trigger", trigger).
+			Msg("Rejected servers file reload, keeping previous server list")
+		return
+	}
+
+	for _, w := range file.Warnings {
+		util.Logger.Warn().Msg(path + ":" + w.String())
+	}
+
+	if err := ImportServers(ctx, file.Servers); err != nil {
+		util.Logger.Error().Err(err).Str("path", path).Msg("Failed to import servers file into MongoDB")
+		return
+	}
+	if err := ImportGroups(ctx, file.Groups); err != nil {
+		util.Logger.Error().Err(err).Str("path", path).Msg("Failed to import server groups into MongoDB")
+	}
+
+	tracked, err := LoadTrackedServers(ctx)
+	if err != nil {
+		util.Logger.Error().Err(err).Msg("Failed to load tracked servers from MongoDB")
+		return
+	}
+
+	summary := j.ApplyServers(tracked)
+	util.Logger.Info().
+		Str("path", path).
+		Str("trigger", trigger).
+		Int("added", summary.Added).
+		Int("removed", summary.Removed).
+		Int("updated", summary.Updated).
+		Int("total", len(tracked)).
+		Msg("Reloaded servers file")
+}
+
+// ApplyServers makes the running job track exactly servers: new IDs start
+// being pinged, missing ones stop, and changed entries are updated in
+// place without losing their schedule.
+func (j *PingJob) ApplyServers(servers []data.PingableServer) reloadSummary {
+	var summary reloadSummary
+
+	current := make(map[string]data.PingableServer)
+	for _, s := range j.Servers() {
+		current[s.ID] = s
+	}
+
+	wanted := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		wanted[s.ID] = true
+
+		old, ok := current[s.ID]
+		switch {
+		case !ok:
+			j.AddServer(s)
+			summary.Added++
+		case !old.Equal(s):
+			j.UpdateServer(s)
+			updateCachedServerInfo(s)
+			summary.Updated++
+		}
+	}
+
+	for id := range current {
+		if !wanted[id] {
+			j.RemoveServer(id)
+			forgetServer(id)
+			summary.Removed++
+		}
+	}
+
+	return summary
+}
+
+// updateCachedServerInfo applies name, address, type and metadata changes to
+// the live cache right away instead of waiting for the next successful ping.
+func updateCachedServerInfo(server data.PingableServer) {
+	serverCacheMu.Lock()
+	defer serverCacheMu.Unlock()
+	if s, ok := serverCacheMap[server.ID]; ok {
+		s.Name = server.Name
+		s.IP = server.IP
+		s.Type = server.Type
+		s.Metadata = server.Metadata
+		serverCacheMap[server.ID] = s
+	}
+}
+
+// forgetServer drops a no longer tracked server from the live cache so it
+// disappears from /api/servers. Its MongoDB document and history are kept.
+func forgetServer(id string) {
+	serverCacheMu.Lock()
+	delete(serverCacheMap, id)
+	serverCacheMu.Unlock()
+
+	healthMu.Lock()
+	delete(healthMap, id)
+	healthMu.Unlock()
+}
@@ -0,0 +1,114 @@
+package task
+
+import (
+	"MineTracker/data"
+	"slices"
+	"testing"
+)
+
+// serversFile parses a servers.json body that must not have issues.
+func serversFile(t *testing.T, body string) data.ServersFile {
+	t.Helper()
+	file, issues := data.CheckServersFile([]byte(body))
+	if len(issues) > 0 {
+		t.Fatalf("servers file has issues: %v", issues)
+	}
+	return file
+}
+
+// resetServerCache replaces the live cache for the duration of a test.
+func resetServerCache(t *testing.T, servers ...data.Server) {
+	t.Helper()
+
+	serverCacheMu.Lock()
+	saved := serverCacheMap
+	serverCacheMap = make(map[string]data.Server, len(servers))
+	for _, s := range servers {
+		serverCacheMap[s.ID] = s
+	}
+	serverCacheMu.Unlock()
+
+	t.Cleanup(func() {
+		serverCacheMu.Lock()
+		serverCacheMap = saved
+		serverCacheMu.Unlock()
+	})
+}
+
+func TestApplyServers(t *testing.T) {
+	resetHealth(t)
+	resetServerCache(t,
+		data.Server{ID: "alpha", Name: "Alpha", IP: "a.example", Type: "PC"},
+		data.Server{ID: "bravo", Name: "Bravo", IP: "b.example", Type: "PC"},
+		data.Server{ID: "charlie", Name: "Charlie", IP: "c.example", Type: "PC"},
+	)
+
+	before := serversFile(t, `[
+  {"id": "alpha", "name": "Alpha", "ip": "a.example", "type": "PC"},
+  {"id": "bravo", "name": "Bravo", "ip": "b.example", "type": "PC"},
+  {"id": "charlie", "name": "Charlie", "ip": "c.example", "type": "PC"},
+  {"id": "delta", "name": "Delta", "ip": "d.example", "type": "PC"}
+]`)
+	after := serversFile(t, `[
+  {"id": "alpha", "name": "Alpha", "ip": "a.example", "type": "PC"},
+  {"id": "bravo", "name": "Bravo Network", "ip": "b.example", "type": "PC"},
+  {"id": "charlie", "name": "Charlie", "ip": "c2.example", "type": "PC"},
+  {"id": "echo", "name": "Echo", "ip": "e.example", "type": "PE"}
+]`)
+
+	j := NewServerJob(0, before.Servers)
+	entries := make(map[string]scheduledServer, len(j.entries))
+	for id, e := range j.entries {
+		entries[id] = *e
+	}
+
+	summary := j.ApplyServers(after.Servers)
+	if summary != (reloadSummary{Added: 1, Removed: 1, Updated: 2}) {
+		t.Errorf("summary %+v, want 1 added, 1 removed, 2 updated", summary)
+	}
+
+	var ids []string
+	for id := range j.entries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if want := []string{"alpha", "bravo", "charlie", "echo"}; !slices.Equal(ids, want) {
+		t.Fatalf("tracked %v, want %v", ids, want)
+	}
+	if len(j.queue) != 4 {
+		t.Errorf("%d servers queued, want 4", len(j.queue))
+	}
+
+	cases := []struct {
+		id         string
+		samePinger bool
+	}{
+		{"alpha", true},    // unchanged
+		{"bravo", true},    // renamed
+		{"charlie", false}, // moved to another address
+	}
+	for _, tc := range cases {
+		old, e := entries[tc.id], j.entries[tc.id]
+		if !e.next.Equal(old.next) {
+			t.Errorf("%s: rescheduled from %s to %s", tc.id, old.next, e.next)
+		}
+		if (e.pinger == old.pinger) != tc.samePinger {
+			t.Errorf("%s: pinger kept %v, want %v", tc.id, e.pinger == old.pinger, tc.samePinger)
+		}
+	}
+	if e := j.entries["echo"]; !data.IsBedrock(e.server.Type) || e.index < 0 {
+		t.Errorf("added server %+v not queued", e.server)
+	}
+
+	serverCacheMu.Lock()
+	defer serverCacheMu.Unlock()
+	if s := serverCacheMap["bravo"]; s.Name != "Bravo Network" {
+		t.Errorf("cached name %q not updated", s.Name)
+	}
+	if s := serverCacheMap["charlie"]; s.IP != "c2.example" {
+		t.Errorf("cached address %q not updated", s.IP)
+	}
+	if _, ok := serverCacheMap["delta"]; ok {
+		t.Error("removed server still cached")
+	}
+}
@@ -0,0 +1,213 @@
+package task
+
+import (
+	"MineTracker/util"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSCacheTTL    = 5 * time.Minute
+	defaultDNSNegativeTTL = 1 * time.Minute
+	dnsLookupTimeout      = 2 * time.Second
+)
+
+// srvEntry caches one _minecraft._tcp SRV lookup. found is false for a cached
+// NXDOMAIN/empty answer, which is the common case for hosts without SRV.
+type srvEntry struct {
+	target  string
+	port    uint16
+	found   bool
+	expires time.Time
+}
+
+// ipEntry caches one A/AAAA lookup. A nil addrs with err set is a cached
+// negative answer.
+type ipEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// dnsResolver resolves SRV and A/AAAA records for the pingers and caches the
+// answers. Go's resolver does not expose record TTLs, so positive answers are
+// kept for DNS_CACHE_TTL and NXDOMAIN answers for DNS_NEGATIVE_TTL. Setting
+// DNS_SERVER (host:port) sends every query to that server instead of the
+// system resolver.
+type dnsResolver struct {
+	resolver    *net.Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu        sync.Mutex
+	srv       map[string]srvEntry
+	ips       map[string]ipEntry
+	lastPrune time.Time
+}
+
+// pingResolver is shared by every pinger so that servers on the same host
+// share cache entries.
+var pingResolver = newDNSResolver()
+
+func newDNSResolver() *dnsResolver {
+	r := &dnsResolver{
+		resolver:    net.DefaultResolver,
+		ttl:         envDuration("DNS_CACHE_TTL", defaultDNSCacheTTL),
+		negativeTTL: envDuration("DNS_NEGATIVE_TTL", defaultDNSNegativeTTL),
+		srv:         make(map[string]srvEntry),
+		ips:         make(map[string]ipEntry),
+	}
+
+	if server := os.Getenv("DNS_SERVER"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return r
+}
+
+// envDuration reads a Go duration (e.g. "30s") from the environment.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		util.Logger.Warn().Str("key", key).Str("value", v).Msg("Invalid duration, using default")
+		return def
+	}
+	return d
+}
+
+// lookupSRV returns the _minecraft._tcp target for host, if any.
+func (r *dnsResolver) lookupSRV(host string) (string, uint16, bool) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return "", 0, false
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	if e, ok := r.srv[host]; ok && now.Before(e.expires) {
+		r.mu.Unlock()
+		return e.target, e.port, e.found
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	entry := srvEntry{}
+	_, srvs, err := r.resolver.LookupSRV(ctx, "minecraft", "tcp", host)
+	switch {
+	case err == nil && len(srvs) > 0:
+		entry.target = strings.TrimSuffix(srvs[0].Target, ".")
+		entry.port = srvs[0].Port
+		entry.found = true
+		entry.expires = now.Add(r.ttl)
+	case err == nil || isNotFound(err):
+		entry.expires = now.Add(r.negativeTTL)
+	default:
+		// Transient failure (timeout, SERVFAIL): don't cache, just skip SRV.
+		return "", 0, false
+	}
+
+	r.mu.Lock()
+	r.srv[host] = entry
+	r.pruneLocked(now)
+	r.mu.Unlock()
+	return entry.target, entry.port, entry.found
+}
+
+// lookupIP returns the A and AAAA records for host. IP literals are returned
+// as-is without touching DNS.
+func (r *dnsResolver) lookupIP(host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	if e, ok := r.ips[host]; ok && now.Before(e.expires) {
+		r.mu.Unlock()
+		return e.addrs, e.err
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		if isNotFound(err) {
+			r.mu.Lock()
+			r.ips[host] = ipEntry{err: err, expires: now.Add(r.negativeTTL)}
+			r.pruneLocked(now)
+			r.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+
+	r.mu.Lock()
+	r.ips[host] = ipEntry{addrs: addrs, expires: now.Add(r.ttl)}
+	r.pruneLocked(now)
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// pruneLocked drops expired entries so hosts that are no longer pinged (removed
+// servers, changed SRV targets) do not stay in the maps forever. It sweeps at
+// most once per negative TTL, the shortest lifetime an entry can have.
+func (r *dnsResolver) pruneLocked(now time.Time) {
+	if now.Sub(r.lastPrune) < r.negativeTTL {
+		return
+	}
+	r.lastPrune = now
+
+	for host, e := range r.srv {
+		if !now.Before(e.expires) {
+			delete(r.srv, host)
+		}
+	}
+	for host, e := range r.ips {
+		if !now.Before(e.expires) {
+			delete(r.ips, host)
+		}
+	}
+}
+
+// addressSet returns the resolved addresses as sorted ip:port strings. The
+// set only changes when DNS does, unlike the address a dual-stack dial ends up
+// connected to, which flips between families from one ping to the next.
+func addressSet(addrs []netip.Addr, port uint16) []string {
+	set := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		set = append(set, netip.AddrPortFrom(addr, port).String())
+	}
+	slices.Sort(set)
+	return slices.Compact(set)
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,59 @@
+package task
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResolverPrunesExpiredEntries(t *testing.T) {
+	r := &dnsResolver{
+		ttl:         time.Minute,
+		negativeTTL: time.Second,
+		srv:         make(map[string]srvEntry),
+		ips:         make(map[string]ipEntry),
+	}
+	now := time.Now()
+	r.srv["gone.example"] = srvEntry{expires: now.Add(-time.Second)}
+	r.srv["kept.example"] = srvEntry{expires: now.Add(time.Minute)}
+	r.ips["gone.example"] = ipEntry{expires: now}
+	r.ips["kept.example"] = ipEntry{expires: now.Add(time.Minute)}
+
+	r.pruneLocked(now)
+	if _, ok := r.srv["gone.example"]; ok || len(r.srv) != 1 {
+		t.Errorf("srv cache after prune: %v", r.srv)
+	}
+	if _, ok := r.ips["gone.example"]; ok || len(r.ips) != 1 {
+		t.Errorf("ip cache after prune: %v", r.ips)
+	}
+
+	// Sweeps are rate limited to one per negative TTL.
+	r.ips["late.example"] = ipEntry{expires: now}
+	r.pruneLocked(now.Add(time.Second / 2))
+	if _, ok := r.ips["late.example"]; !ok {
+		t.Error("pruned again before the negative TTL passed")
+	}
+	r.pruneLocked(now.Add(time.Second))
+	if _, ok := r.ips["late.example"]; ok {
+		t.Error("expired entry kept after the negative TTL passed")
+	}
+}
+
+func TestAddressSet(t *testing.T) {
+	a := []netip.Addr{
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.10"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+	b := []netip.Addr{a[2], a[0], a[1], a[1]}
+
+	want := []string{"192.0.2.10:25565", "192.0.2.2:25565", "[2001:db8::1]:25565"}
+	if got := addressSet(a, 25565); !slices.Equal(got, want) {
+		t.Errorf("addressSet = %v, want %v", got, want)
+	}
+	// The order DNS answers in does not matter.
+	if !slices.Equal(addressSet(a, 25565), addressSet(b, 25565)) {
+		t.Error("reordered answer produced a different set")
+	}
+}
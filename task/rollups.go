@@ -0,0 +1,257 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"MineTracker/util"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// rollupDelay leaves raw points time to reach the store before the
+	// window they belong to is rolled up.
+	rollupDelay = 30 * time.Second
+	// retentionInterval is how often expired history is removed.
+	retentionInterval = time.Hour
+)
+
+// rollupProgress is the range of windows a tier has been rolled up for,
+// stored per tier so backfills and the running tracker can extend it.
+type rollupProgress struct {
+	Tier  string
+	Since time.Time
+	Until time.Time
+}
+
+func rollupProgressCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("rollup_progress")
+}
+
+// EnsureRollupIndexes creates the unique index on the tier name.
+func EnsureRollupIndexes(ctx context.Context) error {
+	_, err := rollupProgressCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tier", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// LoadRollupProgress reads the rolled up range of every tier and hands it to
+// the query side, which only uses tiers that cover a query's range.
+func LoadRollupProgress(ctx context.Context) (map[string]data.RollupCoverage, error) {
+	cursor, err := rollupProgressCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []rollupProgress
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	coverage := make(map[string]data.RollupCoverage, len(entries))
+	for _, e := range entries {
+		c := data.RollupCoverage{Since: e.Since, Until: e.Until}
+		coverage[e.Tier] = c
+		data.SetRollupCoverage(e.Tier, c)
+	}
+	return coverage, nil
+}
+
+// extendRollupProgress widens the rolled up range of a tier by [from, to).
+// Callers only roll up ranges adjacent to the stored one, so the range
+// stays contiguous.
+func extendRollupProgress(ctx context.Context, tier string, from, to time.Time) error {
+	_, err := rollupProgressCollection().UpdateOne(ctx,
+		bson.M{"tier": tier},
+		bson.M{
+			"$min": bson.M{"since": from},
+			"$max": bson.M{"until": to},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// rollupChunk is the longest range rolled up by one call to the store.
+func rollupChunk(tier data.RollupTier) time.Duration {
+	return max(24*time.Hour, 100*tier.Every)
+}
+
+// lastCompleteWindow returns the end of the last window of tier that is
+// complete at now.
+func lastCompleteWindow(tier data.RollupTier, now time.Time) time.Time {
+	return now.Add(-rollupDelay).Truncate(tier.Every)
+}
+
+// rollupForward rolls up tier from from to to in chunks, oldest first,
+// recording the progress after every chunk.
+func rollupForward(ctx context.Context, tier data.RollupTier, from, to time.Time) error {
+	for from.Before(to) {
+		end := from.Add(rollupChunk(tier))
+		if end.After(to) {
+			end = to
+		}
+		if err := data.TimeSeries.Rollup(ctx, tier, from, end); err != nil {
+			return err
+		}
+		if err := extendRollupProgress(ctx, tier.Name, from, end); err != nil {
+			return err
+		}
+		from = end
+	}
+	return nil
+}
+
+// rollupBackward rolls up tier from to back to from in chunks, newest
+// first, recording the progress after every chunk.
+func rollupBackward(ctx context.Context, tier data.RollupTier, from, to time.Time, progress func(done, total time.Duration)) error {
+	end := to
+	for from.Before(end) {
+		start := end.Add(-rollupChunk(tier))
+		if start.Before(from) {
+			start = from
+		}
+		if err := data.TimeSeries.Rollup(ctx, tier, start, end); err != nil {
+			return err
+		}
+		if err := extendRollupProgress(ctx, tier.Name, start, end); err != nil {
+			return err
+		}
+		end = start
+		if progress != nil {
+			progress(to.Sub(end), to.Sub(from))
+		}
+	}
+	return nil
+}
+
+// StartDownsampler keeps every rollup tier up to date with the raw history
+// and applies the retention periods. A tier that was never rolled up starts
+// with the last complete window; older history needs BackfillRollups. With
+// DOWNSAMPLING_ENABLED=false only the retention is applied.
+func StartDownsampler(ctx context.Context) {
+	go func() {
+		applyRetention(ctx)
+		rollupTiers(ctx)
+
+		rollupTicker := time.NewTicker(time.Minute)
+		defer rollupTicker.Stop()
+		retentionTicker := time.NewTicker(retentionInterval)
+		defer retentionTicker.Stop()
+
+		for {
+			select {
+			case <-rollupTicker.C:
+				rollupTiers(ctx)
+			case <-retentionTicker.C:
+				applyRetention(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func applyRetention(ctx context.Context) {
+	if err := data.TimeSeries.ApplyRetention(ctx); err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to apply history retention")
+	}
+}
+
+func rollupTiers(ctx context.Context) {
+	if !data.DownsamplingEnabled() {
+		return
+	}
+
+	coverage, err := LoadRollupProgress(ctx)
+	if err != nil {
+		util.Logger.Warn().Err(err).Msg("Failed to load rollup progress from MongoDB")
+		return
+	}
+
+	now := time.Now()
+	for _, tier := range data.RollupTiers {
+		to := lastCompleteWindow(tier, now)
+		from := to.Add(-tier.Every)
+		if c, ok := coverage[tier.Name]; ok {
+			from = c.Until
+		}
+		if !from.Before(to) {
+			continue
+		}
+
+		if err := rollupForward(ctx, tier, from, to); err != nil {
+			util.Logger.Warn().Err(err).Str("tier", tier.Name).Msg("Failed to roll up history")
+			continue
+		}
+		since := from
+		if c, ok := coverage[tier.Name]; ok {
+			since = c.Since
+		}
+		data.SetRollupCoverage(tier.Name, data.RollupCoverage{Since: since, Until: to})
+	}
+}
+
+// BackfillRollups rolls up the raw history of the last since into tier,
+// or into every tier when tier is empty. Each tier is only filled back to
+// its retention. Windows already rolled up are rolled up again.
+func BackfillRollups(ctx context.Context, tier string, since time.Duration) error {
+	if err := data.TimeSeries.ApplyRetention(ctx); err != nil {
+		return fmt.Errorf("failed to apply retention: %w", err)
+	}
+	coverage, err := LoadRollupProgress(ctx)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	now := time.Now()
+	for _, t := range data.RollupTiers {
+		if tier != "" && t.Name != tier {
+			continue
+		}
+		found = true
+
+		span := since
+		if t.Retention > 0 {
+			span = min(span, t.Retention)
+		}
+		// The range ends where the tracker took over, so the rolled up
+		// range stays contiguous.
+		to := lastCompleteWindow(t, now)
+		if c, ok := coverage[t.Name]; ok {
+			to = c.Until
+		}
+		from := now.Add(-span).Truncate(t.Every)
+
+		util.Logger.Info().
+			Str("tier", t.Name).
+			Time("from", from).
+			Time("to", to).
+			Msg("Backfilling rollups")
+
+		err := rollupBackward(ctx, t, from, to, func(done, total time.Duration) {
+			util.Logger.Info().
+				Str("tier", t.Name).
+				Msg(fmt.Sprintf("Backfilled %.0f%%", 100*done.Seconds()/total.Seconds()))
+		})
+		if err != nil {
+			return fmt.Errorf("backfill of tier %s failed: %w", t.Name, err)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("unknown rollup tier %q", tier)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/util"
+	"MineTracker/websocket"
+	"container/heap"
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subscribedInterval = 1 * time.Second
+	defaultInterval    = 10 * time.Second
+
+	// jitterFraction spreads pings of servers with the same interval so that
+	// they never line up on the same tick again after startup.
+	jitterFraction = 0.1
+)
+
+// scheduledServer is one entry of the scheduler's priority queue.
+type scheduledServer struct {
+	server data.PingableServer
+	pinger serverPinger
+	next   time.Time
+
+	index     int  // position in the heap, -1 while not queued
+	attempt   int  // failed attempts of the current ping, retried via the queue
+	running   bool // handed to a worker, will be re-queued when done
+	removed   bool // removed while running, must not be re-queued
+	suspended bool // the policy suspended it, waits for Resume
+}
+
+// pingQueue is a min-heap of servers ordered by their next ping time.
+type pingQueue []*scheduledServer
+
+func (q pingQueue) Len() int           { return len(q) }
+func (q pingQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q pingQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *pingQueue) Push(x interface{}) {
+	e := x.(*scheduledServer)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *pingQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*q = old[:n-1]
+	return e
+}
+
+// pingTask is a snapshot of a due server handed to a worker, so that
+// UpdateServer can change the entry while the ping is in flight.
+type pingTask struct {
+	entry   *scheduledServer
+	server  data.PingableServer
+	pinger  serverPinger
+	attempt int
+}
+
+// pingWorkers is the size of the worker pool that executes due pings.
+var pingWorkers = envInt("PING_WORKERS", maxConcurrentPings)
+
+// runningJob is the job whose scheduler is currently running, so that other
+// parts of the task package (active status sync) can reach it.
+var runningJob atomic.Pointer[PingJob]
+
+// StartServerJob runs the scheduler until ctx is cancelled. A single goroutine
+// pops due servers from the queue and hands them to a bounded worker pool;
+// workers re-queue the server with its next interval once the ping is done.
+func (j *PingJob) StartServerJob(ctx context.Context) {
+	websocket.GlobalHub.SetSubscriptionListener(j.onSubscriptionChange)
+	defer websocket.GlobalHub.SetSubscriptionListener(nil)
+	websocket.GlobalHub.SetServerResolver(ResolveServerID)
+	defer websocket.GlobalHub.SetServerResolver(nil)
+
+	runningJob.Store(j)
+	defer runningJob.CompareAndSwap(j, nil)
+
+	workers := pingWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	work := make(chan pingTask)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				// Servers deactivated while queued are suspended without a ping.
+				if isServerActive(t.server.ID) && j.pingServer(t.server, t.pinger, t.attempt < pingHealthConfig.retries) {
+					j.retry(t.entry)
+					continue
+				}
+				j.reschedule(t.entry)
+			}
+		}()
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		j.mu.Lock()
+		now := time.Now()
+		var due []pingTask
+		for len(j.queue) > 0 && !j.queue[0].next.After(now) {
+			e := heap.Pop(&j.queue).(*scheduledServer)
+			e.running = true
+			due = append(due, pingTask{entry: e, server: e.server, pinger: e.pinger, attempt: e.attempt})
+		}
+		wait := time.Hour
+		if len(j.queue) > 0 {
+			wait = j.queue[0].next.Sub(now)
+		}
+		j.mu.Unlock()
+
+		for _, t := range due {
+			select {
+			case work <- t:
+			case <-ctx.Done():
+				close(work)
+				wg.Wait()
+				util.Logger.Info().Msg("Stopped data ping job.")
+				return
+			}
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-j.wake:
+		case <-ctx.Done():
+			close(work)
+			wg.Wait()
+			util.Logger.Info().Msg("Stopped data ping job.")
+			return
+		}
+	}
+}
+
+// SetIntervalPolicy replaces the policy used for every following reschedule.
+func (j *PingJob) SetIntervalPolicy(policy IntervalPolicy) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.policy = policy
+}
+
+// nextInterval asks the policy when a server should be pinged next.
+func (j *PingJob) nextInterval(server data.PingableServer) (time.Duration, bool) {
+	state, failures := healthSnapshot(server.ID)
+	return j.policy.NextInterval(policyInput{
+		Server:     server,
+		Active:     isServerActive(server.ID),
+		Subscribed: websocket.GlobalHub.IsSubscribed(server.ID),
+		State:      state,
+		Failures:   failures,
+	})
+}
+
+// withJitter adds up to ±jitterFraction of random jitter to d.
+func withJitter(d time.Duration) time.Duration {
+	spread := float64(d) * jitterFraction
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+func (j *PingJob) reschedule(e *scheduledServer) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e.running = false
+	e.attempt = 0
+	if e.removed {
+		return
+	}
+
+	interval, ok := j.nextInterval(e.server)
+	if !ok {
+		e.suspended = true
+		return
+	}
+	e.next = time.Now().Add(withJitter(interval))
+	heap.Push(&j.queue, e)
+	j.signal()
+}
+
+// retry re-queues a server whose ping failed before the failure counts, after
+// the retry backoff instead of its regular interval.
+func (j *PingJob) retry(e *scheduledServer) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e.running = false
+	if e.removed {
+		return
+	}
+	e.attempt++
+	e.next = time.Now().Add(retryDelay(e.attempt))
+	heap.Push(&j.queue, e)
+	j.signal()
+}
+
+// Resume puts a suspended server back into the queue and pings it right away.
+func (j *PingJob) Resume(id string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.entries[id]
+	if !ok || !e.suspended || e.running {
+		return
+	}
+	e.suspended = false
+	e.next = time.Now()
+	heap.Push(&j.queue, e)
+	j.signal()
+}
+
+// signal wakes the scheduler loop so it recomputes its timer. Must be called
+// with j.mu held.
+func (j *PingJob) signal() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// onSubscriptionChange pulls a server forward when it gains its first
+// subscriber so the faster interval applies immediately instead of after
+// the current wait, which may be a long offline backoff.
+func (j *PingJob) onSubscriptionChange(id string, subscribed bool) {
+	if !subscribed {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.entries[id]
+	if !ok || e.index < 0 || e.server.Interval > 0 {
+		return
+	}
+	if soon := time.Now(); e.next.After(soon) {
+		e.next = soon
+		heap.Fix(&j.queue, e.index)
+		j.signal()
+	}
+}
+
+func (j *PingJob) addLocked(server data.PingableServer, first time.Time) {
+	e := &scheduledServer{
+		server: server,
+		pinger: newServerPinger(server.Type),
+		next:   first,
+		index:  -1,
+	}
+	j.entries[server.ID] = e
+	heap.Push(&j.queue, e)
+}
+
+// AddServer starts pinging a server. Adding an already tracked ID updates it
+// instead.
+func (j *PingJob) AddServer(server data.PingableServer) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[server.ID]; ok {
+		j.updateLocked(server)
+		return
+	}
+
+	j.addLocked(server, time.Now())
+	j.signal()
+}
+
+// UpdateServer replaces the configuration of a tracked server in place. The
+// pinger is only recreated when the server type or address changes, so
+// protocol state such as the legacy ping flavour survives name or interval
+// changes.
+func (j *PingJob) UpdateServer(server data.PingableServer) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.updateLocked(server)
+}
+
+func (j *PingJob) updateLocked(server data.PingableServer) bool {
+	e, ok := j.entries[server.ID]
+	if !ok {
+		return false
+	}
+	if data.IsBedrock(e.server.Type) != data.IsBedrock(server.Type) || e.server.IP != server.IP {
+		e.pinger = newServerPinger(server.Type)
+	}
+	e.server = server
+	return true
+}
+
+// RemoveServer stops pinging a server. A ping already in flight finishes but
+// the server is not scheduled again.
+func (j *PingJob) RemoveServer(id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.entries[id]
+	if !ok {
+		return false
+	}
+	delete(j.entries, id)
+	e.removed = true
+	if e.index >= 0 {
+		heap.Remove(&j.queue, e.index)
+	}
+	return true
+}
+
+// Servers returns the configuration of every scheduled server.
+func (j *PingJob) Servers() []data.PingableServer {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	servers := make([]data.PingableServer, 0, len(j.entries))
+	for _, e := range j.entries {
+		servers = append(servers, e.server)
+	}
+	return servers
+}
+
+// PingNow pings a tracked server synchronously, outside of its schedule.
+func (j *PingJob) PingNow(id string) bool {
+	j.mu.Lock()
+	e, ok := j.entries[id]
+	var t pingTask
+	if ok {
+		t = pingTask{entry: e, server: e.server, pinger: e.pinger}
+	}
+	j.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	j.pingServer(t.server, t.pinger, false)
+	return true
+}
@@ -0,0 +1,71 @@
+package task
+
+import (
+	"MineTracker/data"
+	"testing"
+	"time"
+)
+
+func TestNewServerJobQueuesBeforeStart(t *testing.T) {
+	j := NewServerJob(0, []data.PingableServer{
+		{ID: "a", IP: "a.example", Type: "PC"},
+		{ID: "b", IP: "b.example", Type: "PC"},
+	})
+
+	// The servers file watcher may add servers before the scheduler starts.
+	j.AddServer(data.PingableServer{ID: "a", IP: "a.example", Name: "renamed", Type: "PC"})
+	j.AddServer(data.PingableServer{ID: "c", IP: "c.example", Type: "PC"})
+
+	if len(j.entries) != 3 || len(j.queue) != 3 {
+		t.Fatalf("%d entries, %d queued, want 3 each", len(j.entries), len(j.queue))
+	}
+	if j.entries["a"].server.Name != "renamed" {
+		t.Errorf("AddServer did not update the seeded entry")
+	}
+}
+
+func TestRetryUsesTheQueue(t *testing.T) {
+	saved := pingHealthConfig
+	pingHealthConfig.retryBackoff = time.Second
+	t.Cleanup(func() { pingHealthConfig = saved })
+
+	j := NewServerJob(0, []data.PingableServer{{ID: "a", IP: "a.example", Type: "PC"}})
+	e := j.entries["a"]
+	popDue := func() {
+		t.Helper()
+		if len(j.queue) != 1 || j.queue[0] != e {
+			t.Fatalf("server not queued")
+		}
+		j.queue = j.queue[:0]
+		e.index = -1
+		e.running = true
+	}
+
+	popDue()
+	start := time.Now()
+	j.retry(e)
+	if e.attempt != 1 || e.next.Before(start.Add(time.Second)) || e.next.After(time.Now().Add(time.Second)) {
+		t.Errorf("first retry: attempt %d at +%s", e.attempt, e.next.Sub(start))
+	}
+
+	popDue()
+	start = time.Now()
+	j.retry(e)
+	if e.attempt != 2 || e.next.Before(start.Add(2*time.Second)) {
+		t.Errorf("second retry: attempt %d at +%s, want a doubled backoff", e.attempt, e.next.Sub(start))
+	}
+
+	popDue()
+	j.reschedule(e)
+	if e.attempt != 0 || e.running || e.index < 0 {
+		t.Errorf("after reschedule: attempt %d, running %v, index %d", e.attempt, e.running, e.index)
+	}
+
+	// Removed while a retry was in flight: not queued again.
+	popDue()
+	j.RemoveServer("a")
+	j.retry(e)
+	if len(j.queue) != 0 {
+		t.Error("removed server re-queued by retry")
+	}
+}
@@ -0,0 +1,320 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"MineTracker/util"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ServerAlias maps a former address of a server to its ID, so old links and
+// clients still using addresses keep working after a move.
+type ServerAlias struct {
+	Alias     string `json:"alias"`
+	ID        string `json:"id"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+var (
+	aliasMu  sync.RWMutex
+	aliasMap = make(map[string]string)
+)
+
+func aliasesCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("server_aliases")
+}
+
+// EnsureAliasIndexes creates the unique index on alias.
+func EnsureAliasIndexes(ctx context.Context) error {
+	_, err := aliasesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "alias", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// LoadAliases reads the alias table into memory.
+func LoadAliases(ctx context.Context) error {
+	cursor, err := aliasesCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var aliases []ServerAlias
+	if err := cursor.All(ctx, &aliases); err != nil {
+		return err
+	}
+
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	for _, a := range aliases {
+		aliasMap[a.Alias] = a.ID
+	}
+	return nil
+}
+
+// recordAlias remembers that address used to belong to id.
+func recordAlias(ctx context.Context, address, id string) error {
+	alias := data.NormalizeAlias(address)
+	_, err := aliasesCollection().UpdateOne(ctx,
+		bson.M{"alias": alias},
+		bson.M{
+			"$set":         bson.M{"id": id},
+			"$setOnInsert": bson.M{"createdat": time.Now().Unix()},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	aliasMu.Lock()
+	aliasMap[alias] = id
+	aliasMu.Unlock()
+	return nil
+}
+
+// ResolveServerID turns an API or websocket key into a server ID. The key may
+// be an ID, the current address of a tracked server or one of its former
+// addresses.
+func ResolveServerID(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	address := data.NormalizeAlias(key)
+
+	serverCacheMu.RLock()
+	if _, ok := serverCacheMap[key]; ok {
+		serverCacheMu.RUnlock()
+		return key, true
+	}
+	for id, s := range serverCacheMap {
+		if data.NormalizeAlias(s.IP) == address {
+			serverCacheMu.RUnlock()
+			return id, true
+		}
+	}
+	serverCacheMu.RUnlock()
+
+	// Servers that were never pinged successfully are not cached yet.
+	if j := runningJob.Load(); j != nil {
+		for _, s := range j.Servers() {
+			if s.ID == key || data.NormalizeAlias(s.IP) == address {
+				return s.ID, true
+			}
+		}
+	}
+
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	id, ok := aliasMap[address]
+	return id, ok
+}
+
+// ResolveServerKey is ResolveServerID for callers that pass unknown keys
+// through unchanged, e.g. history queries which simply return no data.
+func ResolveServerKey(key string) string {
+	if id, ok := ResolveServerID(key); ok {
+		return id
+	}
+	return key
+}
+
+// MigrateServerIDs backfills IDs for data written before servers had one. It
+// is idempotent and runs on every start:
+//   - tracked servers without an ID get a new one,
+//   - server state documents get the ID of the tracked server with their address,
+//   - approved submissions get the ID of the server they created.
+//
+// Existing InfluxDB history is rewritten separately by MigrateInfluxHistory.
+func MigrateServerIDs(ctx context.Context) error {
+	tracked := trackedCollection()
+
+	cursor, err := tracked.Find(ctx, bson.M{"id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	var missing []data.PingableServer
+	if err := cursor.All(ctx, &missing); err != nil {
+		return err
+	}
+	for _, s := range missing {
+		id := data.NewServerID()
+		if _, err := tracked.UpdateOne(ctx,
+			bson.M{"ip": s.IP, "id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"id": id}},
+		); err != nil {
+			return err
+		}
+	}
+
+	all, err := LoadTrackedServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	states := database.MongoClient.Database("minetracker").Collection("servers")
+	var migrated int
+	for _, s := range all {
+		res, err := states.UpdateMany(ctx,
+			bson.M{"ip": s.IP, "id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"id": s.ID}},
+		)
+		if err != nil {
+			return err
+		}
+		migrated += int(res.ModifiedCount)
+
+		if _, err := submissionsCollection().UpdateMany(ctx,
+			bson.M{"server.ip": s.IP, "status": SubmissionApproved, "server.id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"server.id": s.ID}},
+		); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 || migrated > 0 {
+		util.Logger.Info().
+			Int("tracked", len(missing)).
+			Int("states", migrated).
+			Msg("Assigned server IDs, run `MineTracker migrate-ids` to move InfluxDB history onto them")
+	}
+	return nil
+}
+
+// MigrateInfluxHistory copies every point written before server IDs existed
+// (tagged with ip and name instead of id) into a series tagged with the ID of
+// the tracked server, or former address, it belongs to. Addresses are matched
+// ignoring case, since the ip tag kept the spelling of servers.json while
+// aliases are stored lower case. With deleteLegacy the copied points are
+// deleted afterwards; otherwise they are left in place and are simply no
+// longer queried.
+func MigrateInfluxHistory(ctx context.Context, deleteLegacy bool) error {
+	servers, err := LoadTrackedServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	addresses := make(map[string]string, len(servers))
+	for _, s := range servers {
+		addresses[data.NormalizeAlias(s.IP)] = s.ID
+	}
+	aliasMu.RLock()
+	for alias, id := range aliasMap {
+		if _, ok := addresses[alias]; !ok {
+			addresses[alias] = id
+		}
+	}
+	aliasMu.RUnlock()
+
+	bucket := database.GetInfluxBucket()
+	org := database.GetInfluxOrg()
+	queryAPI := database.InfluxClient.QueryAPI(org)
+	deleteAPI := database.InfluxClient.DeleteAPI()
+
+	for address, id := range addresses {
+		tags, err := legacyIPTags(ctx, queryAPI, bucket, address)
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", address, err)
+		}
+
+		for _, ip := range tags {
+			query, err := data.NewFluxQuery(bucket).
+				RangeSince(time.Unix(0, 0)).
+				Measurement("server_data", "ping_failure").
+				Where("ip", ip).
+				Missing("id").
+				Drop("ip", "name").
+				Set("id", id).
+				To(bucket, org).
+				Build()
+			if err != nil {
+				return fmt.Errorf("migrating %s: %w", ip, err)
+			}
+
+			result, err := queryAPI.Query(ctx, query)
+			if err != nil {
+				return fmt.Errorf("migrating %s: %w", ip, err)
+			}
+			for result.Next() {
+				// to() passes the written rows through; nothing to read.
+			}
+			err = result.Err()
+			_ = result.Close()
+			if err != nil {
+				return fmt.Errorf("migrating %s: %w", ip, err)
+			}
+
+			if deleteLegacy {
+				for _, measurement := range []string{"server_data", "ping_failure"} {
+					predicate, err := legacyDeletePredicate(measurement, ip)
+					if err != nil {
+						return fmt.Errorf("deleting legacy points of %s: %w", ip, err)
+					}
+					if err := deleteAPI.DeleteWithName(ctx, org, bucket, time.Unix(0, 0), time.Now(), predicate); err != nil {
+						return fmt.Errorf("deleting legacy points of %s: %w", ip, err)
+					}
+				}
+			}
+
+			util.Logger.Info().Str("address", ip).Str("id", id).Msg("Migrated InfluxDB history")
+		}
+	}
+	return nil
+}
+
+// legacyIPTags returns the ip tag values of not yet migrated points that
+// match address ignoring case.
+func legacyIPTags(ctx context.Context, queryAPI api.QueryAPI, bucket, address string) ([]string, error) {
+	query, err := data.NewFluxQuery(bucket).
+		RangeSince(time.Unix(0, 0)).
+		Measurement("server_data", "ping_failure").
+		Missing("id").
+		WhereFold("ip", address).
+		Keep("ip").
+		Group().
+		Distinct("ip").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := queryAPI.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var tags []string
+	for result.Next() {
+		if ip, ok := result.Record().Value().(string); ok {
+			tags = append(tags, ip)
+		}
+	}
+	return tags, result.Err()
+}
+
+// legacyDeletePredicate builds the delete predicate for the legacy points of
+// one address. The predicate syntax has no escapes, so values that contain a
+// quote or backslash are refused rather than risk matching other series.
+func legacyDeletePredicate(measurement, ip string) (string, error) {
+	for _, v := range []string{measurement, ip} {
+		if strings.ContainsAny(v, "\"\\") {
+			return "", fmt.Errorf("can not delete points tagged %q", v)
+		}
+	}
+	return `_measurement="` + measurement + `" AND ip="` + ip + `"`, nil
+}
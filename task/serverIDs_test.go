@@ -0,0 +1,16 @@
+package task
+
+import "testing"
+
+func TestLegacyDeletePredicate(t *testing.T) {
+	got, err := legacyDeletePredicate("server_data", "Play.Example.com:25565")
+	if err != nil || got != `_measurement="server_data" AND ip="Play.Example.com:25565"` {
+		t.Errorf("predicate = %q, %v", got, err)
+	}
+
+	for _, ip := range []string{`a" OR ip="b`, `a\`, `"`} {
+		if p, err := legacyDeletePredicate("server_data", ip); err == nil {
+			t.Errorf("%q: built %s", ip, p)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package task
+
+import (
+	"MineTracker/data"
+	"encoding/json"
+	"strings"
+)
+
+// slpStatus mirrors the subset of the modern SLP status JSON we persist.
+// Fields we do not use are simply not declared so encoding/json skips them
+// without allocating.
+type slpStatus struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int                 `json:"max"`
+		Online int                 `json:"online"`
+		Sample []data.PlayerSample `json:"sample"`
+	} `json:"players"`
+	Description        json.RawMessage `json:"description"`
+	Favicon            string          `json:"favicon"`
+	EnforcesSecureChat bool            `json:"enforcesSecureChat"`
+
+	// Forge 1.7 – 1.12 advertises its mod list in "modinfo".
+	ModInfo *struct {
+		Type    string `json:"type"`
+		ModList []struct {
+			ModID   string `json:"modid"`
+			Version string `json:"version"`
+		} `json:"modList"`
+	} `json:"modinfo"`
+
+	// Forge 1.13+ uses "forgeData". Newer versions may only send the
+	// compressed "d" field, in which case only the loader is recorded.
+	ForgeData *struct {
+		Mods []struct {
+			ModID     string `json:"modId"`
+			ModMarker string `json:"modmarker"`
+		} `json:"mods"`
+	} `json:"forgeData"`
+}
+
+func (s *slpStatus) result() *mcPingResult {
+	res := &mcPingResult{
+		PlayerCount:        s.Players.Online,
+		MaxPlayers:         s.Players.Max,
+		Favicon:            s.Favicon,
+		Protocol:           s.Version.Protocol,
+		Version:            s.Version.Name,
+		MOTD:               chatText(s.Description),
+		Sample:             s.Players.Sample,
+		EnforcesSecureChat: s.EnforcesSecureChat,
+	}
+
+	switch {
+	case s.ForgeData != nil:
+		res.ModLoader = "forge"
+		if len(s.ForgeData.Mods) > 0 {
+			res.Mods = make([]data.ModInfo, len(s.ForgeData.Mods))
+			for i, m := range s.ForgeData.Mods {
+				res.Mods[i] = data.ModInfo{ID: m.ModID, Version: m.ModMarker}
+			}
+		}
+	case s.ModInfo != nil:
+		res.ModLoader = strings.ToLower(s.ModInfo.Type)
+		if res.ModLoader == "fml" {
+			res.ModLoader = "forge"
+		}
+		if len(s.ModInfo.ModList) > 0 {
+			res.Mods = make([]data.ModInfo, len(s.ModInfo.ModList))
+			for i, m := range s.ModInfo.ModList {
+				res.Mods[i] = data.ModInfo{ID: m.ModID, Version: m.Version}
+			}
+		}
+	}
+
+	return res
+}
+
+// chatComponent is the JSON text format used by the SLP description.
+type chatComponent struct {
+	Text      string            `json:"text"`
+	Translate string            `json:"translate"`
+	Extra     []json.RawMessage `json:"extra"`
+}
+
+// chatText flattens a description that is either a plain string, a chat
+// component or an array of components into its plain text.
+func chatText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	appendChatText(&sb, raw, 0)
+	return sb.String()
+}
+
+// maxChatDepth guards against maliciously deep component trees.
+const maxChatDepth = 16
+
+func appendChatText(sb *strings.Builder, raw json.RawMessage, depth int) {
+	if depth > maxChatDepth || len(raw) == 0 {
+		return
+	}
+
+	switch raw[0] {
+	case '"':
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			sb.WriteString(s)
+		}
+	case '[':
+		var parts []json.RawMessage
+		if json.Unmarshal(raw, &parts) == nil {
+			for _, p := range parts {
+				appendChatText(sb, p, depth+1)
+			}
+		}
+	case '{':
+		var c chatComponent
+		if json.Unmarshal(raw, &c) != nil {
+			return
+		}
+		if c.Text != "" {
+			sb.WriteString(c.Text)
+		} else {
+			sb.WriteString(c.Translate)
+		}
+		for _, e := range c.Extra {
+			appendChatText(sb, e, depth+1)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestChatText(t *testing.T) {
+	deep := strings.Repeat(`{"text":"x","extra":[`, 40) + `"y"` + strings.Repeat(`]}`, 40)
+
+	cases := []struct {
+		in, want string
+	}{
+		{``, ""},
+		{`"A Minecraft Server"`, "A Minecraft Server"},
+		{`"§aGreen\nsecond line"`, "§aGreen\nsecond line"},
+		{`{"text":"Hello "}`, "Hello "},
+		{`{"text":"Hello ","extra":[{"text":"world","bold":true},"!"]}`, "Hello world!"},
+		{`{"translate":"multiplayer.title"}`, "multiplayer.title"},
+		{`[{"text":"a"},"b",{"text":"c","extra":["d"]}]`, "abcd"},
+		{`{"text":"","extra":[{"text":"only extra"}]}`, "only extra"},
+		{`42`, ""},
+		{`{"text":`, ""},
+		// Trees deeper than maxChatDepth are cut off instead of recursing.
+		{deep, strings.Repeat("x", maxChatDepth+1)},
+	}
+
+	for _, tc := range cases {
+		if got := chatText(json.RawMessage(tc.in)); got != tc.want {
+			t.Errorf("chatText(%.40q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// slpResponse frames a status JSON as a status-response packet.
+func slpResponse(status string) []byte {
+	var body [5]byte
+	payload := append([]byte{0x00}, body[:putMCVarint(body[:], uint32(len(status)))]...)
+	payload = append(payload, status...)
+
+	var length [5]byte
+	return append(length[:putMCVarint(length[:], uint32(len(payload)))], payload...)
+}
+
+func TestSLPReadResponse(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    string
+		check     func(*mcPingResult) bool
+		wantError string
+	}{
+		{
+			name:   "vanilla",
+			status: `{"version":{"name":"1.20.4","protocol":765},"players":{"max":100,"online":2,"sample":[{"name":"Notch","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"}]},"description":{"text":"Hi"},"enforcesSecureChat":true}`,
+			check: func(r *mcPingResult) bool {
+				return r.Version == "1.20.4" && r.Protocol == 765 && r.MaxPlayers == 100 && r.PlayerCount == 2 &&
+					r.MOTD == "Hi" && r.EnforcesSecureChat && len(r.Sample) == 1 && r.ModLoader == ""
+			},
+		},
+		{
+			name:   "forge modinfo",
+			status: `{"version":{"name":"1.12.2","protocol":340},"players":{"max":20,"online":0},"description":"Modded","modinfo":{"type":"FML","modList":[{"modid":"minecraft","version":"1.12.2"},{"modid":"jei","version":"4.16"}]}}`,
+			check: func(r *mcPingResult) bool {
+				return r.ModLoader == "forge" && len(r.Mods) == 2 && r.Mods[1].ID == "jei" && r.Mods[1].Version == "4.16"
+			},
+		},
+		{
+			name:   "forge data",
+			status: `{"version":{"name":"1.20.1","protocol":763},"players":{"max":20,"online":0},"description":"","forgeData":{"mods":[{"modId":"create","modmarker":"0.5.1"}]}}`,
+			check: func(r *mcPingResult) bool {
+				return r.ModLoader == "forge" && len(r.Mods) == 1 && r.Mods[0].ID == "create"
+			},
+		},
+		{
+			name:   "compressed forge data",
+			status: `{"version":{"name":"1.20.4","protocol":765},"players":{"max":20,"online":0},"description":"","forgeData":{"d":"abc"}}`,
+			check:  func(r *mcPingResult) bool { return r.ModLoader == "forge" && r.Mods == nil },
+		},
+		{name: "invalid json", status: `{"version":`, wantError: ReasonJSON},
+	}
+
+	for _, tc := range cases {
+		res, err := slpReadResponse(bufio.NewReader(bytes.NewReader(slpResponse(tc.status))))
+		if tc.wantError != "" {
+			if err == nil || classifyPingError(err).Reason != tc.wantError {
+				t.Errorf("%s: got %v, want a %s error", tc.name, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !tc.check(res) {
+			t.Errorf("%s: unexpected result %+v", tc.name, *res)
+		}
+	}
+
+	wrongID := slpResponse(`{}`)
+	wrongID[1] = 0x01
+	if _, err := slpReadResponse(bufio.NewReader(bytes.NewReader(wrongID))); err == nil {
+		t.Error("packet with a wrong ID accepted")
+	}
+}
@@ -0,0 +1,483 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Submission review states.
+const (
+	SubmissionPending  = "pending"
+	SubmissionApproved = "approved"
+	SubmissionRejected = "rejected"
+)
+
+var (
+	ErrSubmissionNotFound = errors.New("submission not found")
+	ErrSubmissionExists   = errors.New("a submission for this server is already pending")
+	ErrSubmissionReviewed = errors.New("submission was already reviewed")
+	ErrNotOwner           = errors.New("owner token does not match a verified owner of this server")
+	ErrCodeNotInMOTD      = errors.New("verification code not found in the server's MOTD")
+	ErrAddressNotPublic   = errors.New("server address must resolve to public IP addresses only")
+)
+
+// Submission is a publicly submitted server waiting for, or past, review.
+// The owner token is only stored as a hash; the verification code is shown to
+// the owner to place in their MOTD.
+type Submission struct {
+	ID               string              `json:"id"`
+	Server           data.PingableServer `json:"server"`
+	Status           string              `json:"status"`
+	VerificationCode string              `json:"verification_code"`
+	OwnerHash        string              `json:"-"`
+	Verified         bool                `json:"verified"`
+	VerifiedAt       int64               `json:"verified_at,omitempty"`
+	CreatedAt        int64               `json:"created_at"`
+	ReviewedAt       int64               `json:"reviewed_at,omitempty"`
+	ReviewNote       string              `json:"review_note,omitempty"`
+
+	// Result of the reachability ping made on submission.
+	Version     string `json:"version,omitempty"`
+	MOTD        string `json:"motd,omitempty"`
+	PlayerCount int    `json:"player_count"`
+	MaxPlayers  int    `json:"max_players"`
+}
+
+func submissionsCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("submissions")
+}
+
+// EnsureSubmissionIndexes creates the unique index on the submission ID and
+// a unique index on the address of pending submissions, so that two
+// concurrent submissions of one server can not both pass SubmitServer's check.
+func EnsureSubmissionIndexes(ctx context.Context) error {
+	_, err := submissionsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "server.ip", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": SubmissionPending}),
+		},
+	})
+	return err
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// probeServer pings a server once with the regular pinger, outside the
+// scheduler, sharing the global concurrency limit.
+func probeServer(server data.PingableServer) (*mcPingResult, error) {
+	pingLimit <- struct{}{}
+	defer func() { <-pingLimit }()
+	return newServerPinger(server.Type).ping(pingOptionsFor(server))
+}
+
+// publicPrefixExceptions are ranges that netip does not classify as private
+// but that are not reachable on the public internet either.
+var publicPrefixExceptions = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, includes broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"), // discard
+}
+
+// isPublicAddr reports whether addr is a globally routable unicast address.
+// Loopback, private, link-local (which includes the 169.254.169.254 cloud
+// metadata endpoint) and multicast addresses are not.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range publicPrefixExceptions {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicTarget resolves a submitted server the way the pinger will and
+// rejects it unless every address is public, so the unauthenticated
+// submission endpoints can not be used to reach the internal network. The
+// answers stay in pingResolver's cache, so the probe that follows connects
+// to the addresses checked here.
+func checkPublicTarget(server data.PingableServer) error {
+	opts := pingOptionsFor(server)
+	host := opts.Host
+	if !opts.SkipSRV && !data.IsBedrock(server.Type) {
+		if target, _, ok := pingResolver.lookupSRV(host); ok {
+			host = target
+		}
+	}
+
+	addrs, err := pingResolver.lookupIP(host)
+	if err != nil {
+		return &PingError{Reason: ReasonDNS, Err: err}
+	}
+	if len(addrs) == 0 {
+		return &PingError{Reason: ReasonDNS, Err: &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}}
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return ErrAddressNotPublic
+		}
+	}
+	return nil
+}
+
+// probeSubmission is probeServer for servers submitted by the public.
+func probeSubmission(server data.PingableServer) (*mcPingResult, error) {
+	if err := checkPublicTarget(server); err != nil {
+		return nil, err
+	}
+	return probeServer(server)
+}
+
+// normalizeSubmissionAddress brings an address into one form per server:
+// lower case, canonical IPv6 and without the edition's default port.
+func normalizeSubmissionAddress(address, serverType string) (string, error) {
+	addr, err := data.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if addr.Port == data.DefaultPort(serverType) {
+		addr.Port = 0
+	}
+	return data.NormalizeAlias(addr.String()), nil
+}
+
+// ProbeServers pings every server once, concurrently within the global
+// limit, and returns the classified failure of each one (nil when it
+// answered) in the order of servers.
+func ProbeServers(servers []data.PingableServer) []*PingError {
+	results := make([]*PingError, len(servers))
+
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := probeServer(s); err != nil {
+				results[i] = classifyPingError(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// stripFormatting removes § colour and style codes so a verification code
+// split by formatting still matches.
+func stripFormatting(s string) string {
+	var b strings.Builder
+	skip := false
+	for _, r := range s {
+		if skip {
+			skip = false
+			continue
+		}
+		if r == '§' {
+			skip = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SubmitServer checks that a candidate is not tracked yet and answers a ping,
+// then stores it for review. Only the name, address, type and metadata of
+// candidate are kept. It returns the submission and the owner token, which is
+// needed to verify ownership and is not stored in plain text.
+func SubmitServer(ctx context.Context, candidate data.PingableServer) (Submission, string, error) {
+	server := data.PingableServer{
+		Name:     candidate.Name,
+		IP:       candidate.IP,
+		Type:     candidate.Type,
+		Source:   data.ServerSourceSubmission,
+		Metadata: candidate.Metadata,
+	}
+	if err := server.Validate(); err != nil {
+		return Submission{}, "", err
+	}
+	address, err := normalizeSubmissionAddress(server.IP, server.Type)
+	if err != nil {
+		return Submission{}, "", err
+	}
+	server.IP = address
+
+	// Tracked addresses keep the case they were imported with, and may spell
+	// out the default port.
+	if _, ok := ResolveServerID(address); ok {
+		return Submission{}, "", ErrServerExists
+	}
+	addr, _ := data.ParseAddress(address)
+	addr.Port = data.DefaultPort(server.Type)
+	err = trackedCollection().FindOne(ctx,
+		bson.M{"ip": bson.M{"$in": bson.A{address, addr.String()}}},
+		options.FindOne().SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	).Err()
+	if err == nil {
+		return Submission{}, "", ErrServerExists
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return Submission{}, "", err
+	}
+
+	err = submissionsCollection().FindOne(ctx, bson.M{
+		"server.ip": server.IP,
+		"status":    SubmissionPending,
+	}).Err()
+	if err == nil {
+		return Submission{}, "", ErrSubmissionExists
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return Submission{}, "", err
+	}
+
+	resp, err := probeSubmission(server)
+	if errors.Is(err, ErrAddressNotPublic) {
+		return Submission{}, "", err
+	}
+	if err != nil {
+		return Submission{}, "", classifyPingError(err)
+	}
+
+	id, err := randomToken(9)
+	if err != nil {
+		return Submission{}, "", err
+	}
+	code, err := randomToken(6)
+	if err != nil {
+		return Submission{}, "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return Submission{}, "", err
+	}
+	ownerToken := "mto_" + secret
+
+	sub := Submission{
+		ID:               id,
+		Server:           server,
+		Status:           SubmissionPending,
+		VerificationCode: "minetracker-" + code,
+		OwnerHash:        hashOwnerToken(ownerToken),
+		CreatedAt:        time.Now().Unix(),
+		Version:          resp.Version,
+		MOTD:             resp.MOTD,
+		PlayerCount:      resp.PlayerCount,
+		MaxPlayers:       resp.MaxPlayers,
+	}
+
+	_, err = submissionsCollection().InsertOne(ctx, sub)
+	if mongo.IsDuplicateKeyError(err) {
+		return Submission{}, "", ErrSubmissionExists
+	}
+	if err != nil {
+		return Submission{}, "", err
+	}
+	return sub, ownerToken, nil
+}
+
+// GetSubmission returns a submission by ID.
+func GetSubmission(ctx context.Context, id string) (Submission, error) {
+	var sub Submission
+	err := submissionsCollection().FindOne(ctx, bson.M{"id": id}).Decode(&sub)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Submission{}, ErrSubmissionNotFound
+	}
+	return sub, err
+}
+
+// GetOwnedSubmission returns a submission if ownerToken belongs to it.
+func GetOwnedSubmission(ctx context.Context, id, ownerToken string) (Submission, error) {
+	var sub Submission
+	err := submissionsCollection().FindOne(ctx, bson.M{
+		"id":        id,
+		"ownerhash": hashOwnerToken(ownerToken),
+	}).Decode(&sub)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Submission{}, ErrSubmissionNotFound
+	}
+	return sub, err
+}
+
+// ListSubmissions returns submissions in the given status, or all of them
+// when status is empty, oldest first.
+func ListSubmissions(ctx context.Context, status string) ([]Submission, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := submissionsCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	subs := []Submission{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// VerifySubmission pings the submitted server and marks the owner as
+// verified when the verification code appears in its MOTD. The code can be
+// removed from the MOTD afterwards.
+func VerifySubmission(ctx context.Context, id, ownerToken string) (Submission, error) {
+	sub, err := GetOwnedSubmission(ctx, id, ownerToken)
+	if err != nil {
+		return Submission{}, err
+	}
+	if sub.Verified {
+		return sub, nil
+	}
+	if sub.Status == SubmissionRejected {
+		return Submission{}, ErrSubmissionReviewed
+	}
+
+	resp, err := probeSubmission(sub.Server)
+	if errors.Is(err, ErrAddressNotPublic) {
+		return Submission{}, err
+	}
+	if err != nil {
+		return Submission{}, classifyPingError(err)
+	}
+	motd := strings.ToLower(stripFormatting(resp.MOTD))
+	if !strings.Contains(motd, strings.ToLower(sub.VerificationCode)) {
+		return Submission{}, ErrCodeNotInMOTD
+	}
+
+	sub.Verified = true
+	sub.VerifiedAt = time.Now().Unix()
+	_, err = submissionsCollection().UpdateOne(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"verified": true, "verifiedat": sub.VerifiedAt}},
+	)
+	return sub, err
+}
+
+// ApproveSubmission starts tracking a pending submission and links it to the
+// ID of the created server.
+func ApproveSubmission(ctx context.Context, id, note string) (Submission, error) {
+	sub, err := reviewSubmission(ctx, id, SubmissionApproved, note)
+	if err != nil {
+		return Submission{}, err
+	}
+
+	server, err := CreateTrackedServer(ctx, sub.Server)
+	if err != nil {
+		// Put the submission back so the approval can be retried.
+		_, _ = submissionsCollection().UpdateOne(ctx,
+			bson.M{"id": id},
+			bson.M{"$set": bson.M{"status": SubmissionPending, "reviewedat": 0}},
+		)
+		return Submission{}, err
+	}
+
+	sub.Server = server
+	_, err = submissionsCollection().UpdateOne(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"server.id": server.ID}},
+	)
+	return sub, err
+}
+
+// RejectSubmission closes a pending submission without tracking it.
+func RejectSubmission(ctx context.Context, id, note string) (Submission, error) {
+	return reviewSubmission(ctx, id, SubmissionRejected, note)
+}
+
+func reviewSubmission(ctx context.Context, id, status, note string) (Submission, error) {
+	var sub Submission
+	err := submissionsCollection().FindOneAndUpdate(ctx,
+		bson.M{"id": id, "status": SubmissionPending},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"reviewedat": time.Now().Unix(),
+			"reviewnote": note,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&sub)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, getErr := GetSubmission(ctx, id); getErr == nil {
+			return Submission{}, ErrSubmissionReviewed
+		}
+		return Submission{}, ErrSubmissionNotFound
+	}
+	return sub, err
+}
+
+// UpdateOwnedServer lets a verified owner of an approved submission change the
+// display name and metadata of their server.
+func UpdateOwnedServer(ctx context.Context, serverID, ownerToken, name string, metadata data.ServerMetadata) (data.PingableServer, error) {
+	err := submissionsCollection().FindOne(ctx, bson.M{
+		"server.id": serverID,
+		"status":    SubmissionApproved,
+		"verified":  true,
+		"ownerhash": hashOwnerToken(ownerToken),
+	}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return data.PingableServer{}, ErrNotOwner
+	}
+	if err != nil {
+		return data.PingableServer{}, err
+	}
+
+	server, err := GetTrackedServer(ctx, serverID)
+	if err != nil {
+		return data.PingableServer{}, err
+	}
+
+	if name = strings.TrimSpace(name); name != "" {
+		server.Name = name
+	}
+	server.Metadata = metadata
+	if err := server.Validate(); err != nil {
+		return data.PingableServer{}, err
+	}
+
+	if err := UpdateTrackedServer(ctx, server); err != nil {
+		return data.PingableServer{}, err
+	}
+	return server, nil
+}
@@ -0,0 +1,86 @@
+package task
+
+import (
+	"MineTracker/data"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	cases := map[string]bool{
+		"1.1.1.1":                true,
+		"203.0.114.1":            true,
+		"2606:4700::1111":        true,
+		"::ffff:8.8.8.8":         true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.10":           false,
+		"169.254.169.254":        false, // cloud metadata
+		"fd00:ec2::254":          false, // cloud metadata over IPv6
+		"fe80::1":                false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+		"0.0.0.0":                false,
+		"0.1.2.3":                false,
+		"::":                     false,
+		"100.64.0.1":             false,
+		"224.0.0.1":              false,
+		"ff02::1":                false,
+		"255.255.255.255":        false,
+		"198.18.0.1":             false,
+	}
+
+	for in, want := range cases {
+		if got := isPublicAddr(netip.MustParseAddr(in)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestCheckPublicTargetLiterals(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1:25565", "[::1]", "169.254.169.254:80", "10.0.0.1"} {
+		err := checkPublicTarget(data.PingableServer{IP: ip, Type: data.ServerTypeJava})
+		if !errors.Is(err, ErrAddressNotPublic) {
+			t.Errorf("%s: got %v, want ErrAddressNotPublic", ip, err)
+		}
+	}
+
+	// A public address is accepted without resolving anything.
+	if err := checkPublicTarget(data.PingableServer{IP: "1.1.1.1", Type: data.ServerTypeJava}); err != nil {
+		t.Errorf("public address rejected: %v", err)
+	}
+
+	// connect_address is where the ping goes, so it is what gets checked.
+	err := checkPublicTarget(data.PingableServer{IP: "1.1.1.1", ConnectAddress: "127.0.0.1", Type: data.ServerTypeJava})
+	if !errors.Is(err, ErrAddressNotPublic) {
+		t.Errorf("private connect address: got %v", err)
+	}
+}
+
+func TestNormalizeSubmissionAddress(t *testing.T) {
+	cases := []struct {
+		in, serverType, want string
+	}{
+		{"Play.Example.COM", data.ServerTypeJava, "play.example.com"},
+		{" play.example.com:25565 ", data.ServerTypeJava, "play.example.com"},
+		{"play.example.com:25566", data.ServerTypeJava, "play.example.com:25566"},
+		{"play.example.com:19132", data.ServerTypeJava, "play.example.com:19132"},
+		{"play.example.com:19132", data.ServerTypeBedrock, "play.example.com"},
+		{"[2001:DB8:0::1]:25565", data.ServerTypeJava, "[2001:db8::1]"},
+		{"2001:db8::1", data.ServerTypeJava, "[2001:db8::1]"},
+	}
+
+	for _, tc := range cases {
+		got, err := normalizeSubmissionAddress(tc.in, tc.serverType)
+		if err != nil || got != tc.want {
+			t.Errorf("normalizeSubmissionAddress(%q, %s) = %q, %v, want %q", tc.in, tc.serverType, got, err, tc.want)
+		}
+	}
+
+	if _, err := normalizeSubmissionAddress("example.com:0", data.ServerTypeJava); err == nil {
+		t.Error("invalid address accepted")
+	}
+}
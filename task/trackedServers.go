@@ -0,0 +1,294 @@
+package task
+
+import (
+	"MineTracker/data"
+	"MineTracker/database"
+	"MineTracker/util"
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrServerNotFound = errors.New("server not found")
+	ErrServerExists   = errors.New("server already exists")
+	ErrJobNotRunning  = errors.New("ping job is not running")
+)
+
+// trackedCollection holds the definitions of every pinged server. It is the
+// source of truth; servers.json is only imported into it.
+func trackedCollection() *mongo.Collection {
+	return database.MongoClient.
+		Database("minetracker").
+		Collection("tracked_servers")
+}
+
+// LoadTrackedServers returns every tracked server definition.
+func LoadTrackedServers(ctx context.Context) ([]data.PingableServer, error) {
+	cursor, err := trackedCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var servers []data.PingableServer
+	if err := cursor.All(ctx, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// ImportServers upserts servers from servers.json into the tracked collection
+// and removes file entries that are no longer in the file. Servers created
+// through the admin API are never touched by an import.
+//
+// Entries are matched by their id, or by address when the file leaves the id
+// out, so existing servers keep their ID. Giving an entry an explicit id is
+// what allows it to move to a new address without losing its history.
+func ImportServers(ctx context.Context, servers []data.PingableServer) error {
+	collection := trackedCollection()
+
+	existing, err := LoadTrackedServers(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]data.PingableServer, len(existing))
+	byIP := make(map[string]data.PingableServer, len(existing))
+	for _, s := range existing {
+		byID[s.ID] = s
+		byIP[data.NormalizeAlias(s.IP)] = s
+	}
+
+	ids := make([]string, 0, len(servers))
+	models := make([]mongo.WriteModel, 0, len(servers))
+	for _, s := range servers {
+		s.Source = data.ServerSourceFile
+		sameAddress, addressTracked := byIP[data.NormalizeAlias(s.IP)]
+
+		switch {
+		case s.ID != "":
+			if addressTracked && sameAddress.ID != s.ID {
+				util.Logger.Warn().Str("ip", s.IP).Str("id", s.ID).Str("tracked_id", sameAddress.ID).
+					Msg("Ignoring servers.json id, the address is already tracked under another ID")
+				s.ID = sameAddress.ID
+			}
+		case addressTracked:
+			s.ID = sameAddress.ID
+		default:
+			if id, ok := aliasID(s.IP); ok && byID[id].ID == "" {
+				// A former address of a server that is no longer tracked.
+				s.ID = id
+			} else {
+				s.ID = data.NewServerID()
+			}
+		}
+
+		if old, ok := byID[s.ID]; ok {
+			if old.Source != data.ServerSourceFile {
+				// API entries win over the file.
+				ids = append(ids, s.ID)
+				continue
+			}
+			if data.NormalizeAlias(old.IP) != data.NormalizeAlias(s.IP) {
+				if err := recordAlias(ctx, old.IP, s.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		ids = append(ids, s.ID)
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"id": s.ID, "source": data.ServerSourceFile}).
+			SetReplacement(s).
+			SetUpsert(true))
+	}
+
+	if len(models) > 0 {
+		// An API-created server with the same address makes the upsert
+		// collide on the unique ip index; the API entry wins.
+		_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{
+		"source": data.ServerSourceFile,
+		"id":     bson.M{"$nin": ids},
+	})
+	return err
+}
+
+// aliasID looks up a former address in the alias table.
+func aliasID(address string) (string, bool) {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	id, ok := aliasMap[data.NormalizeAlias(address)]
+	return id, ok
+}
+
+// EnsureTrackedIndexes creates the unique indexes on id and ip. The id index
+// is partial so documents from before IDs existed do not collide until
+// MigrateServerIDs has filled them in.
+func EnsureTrackedIndexes(ctx context.Context) error {
+	_, err := trackedCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "ip", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"id": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+// CreateTrackedServer stores a new server, assigning an ID when it has none,
+// and starts pinging it. It returns the stored definition.
+func CreateTrackedServer(ctx context.Context, server data.PingableServer) (data.PingableServer, error) {
+	if server.Source == "" {
+		server.Source = data.ServerSourceAPI
+	}
+	if server.ID == "" {
+		server.ID = data.NewServerID()
+	}
+
+	_, err := trackedCollection().InsertOne(ctx, server)
+	if mongo.IsDuplicateKeyError(err) {
+		return data.PingableServer{}, ErrServerExists
+	}
+	if err != nil {
+		return data.PingableServer{}, err
+	}
+
+	if j := runningJob.Load(); j != nil {
+		j.AddServer(server)
+	}
+	return server, nil
+}
+
+// UpdateTrackedServer replaces the definition of the server with server.ID
+// and applies it to the running job in place. When the address changes the
+// old one is kept as an alias.
+func UpdateTrackedServer(ctx context.Context, server data.PingableServer) error {
+	collection := trackedCollection()
+
+	var existing data.PingableServer
+	if err := collection.FindOne(ctx, bson.M{"id": server.ID}).Decode(&existing); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrServerNotFound
+		}
+		return err
+	}
+	// Editing a file entry through the API takes it over, so the next
+	// servers.json import does not revert the change.
+	server.Source = existing.Source
+	if server.Source == data.ServerSourceFile || server.Source == "" {
+		server.Source = data.ServerSourceAPI
+	}
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"id": server.ID}, server)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrServerExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if data.NormalizeAlias(existing.IP) != data.NormalizeAlias(server.IP) {
+		if err := recordAlias(ctx, existing.IP, server.ID); err != nil {
+			return err
+		}
+	}
+
+	if j := runningJob.Load(); j != nil {
+		j.UpdateServer(server)
+	}
+	updateCachedServerInfo(server)
+	return nil
+}
+
+// GetTrackedServer returns the definition of a tracked server.
+func GetTrackedServer(ctx context.Context, id string) (data.PingableServer, error) {
+	var server data.PingableServer
+	err := trackedCollection().FindOne(ctx, bson.M{"id": id}).Decode(&server)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return data.PingableServer{}, ErrServerNotFound
+	}
+	return server, err
+}
+
+// DeleteTrackedServer stops tracking a server. Its state document, aliases and
+// history are kept.
+func DeleteTrackedServer(ctx context.Context, id string) error {
+	res, err := trackedCollection().DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrServerNotFound
+	}
+
+	if j := runningJob.Load(); j != nil {
+		j.RemoveServer(id)
+	}
+	forgetServer(id)
+	return nil
+}
+
+// SetServerActive changes a server's active flag in MongoDB and applies it to
+// the ping job immediately instead of waiting for the next active status sync.
+func SetServerActive(ctx context.Context, id string, active bool) error {
+	tracked, err := GetTrackedServer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.MongoClient.
+		Database("minetracker").
+		Collection("servers").
+		UpdateOne(ctx,
+			bson.M{"id": id},
+			bson.M{"$set": bson.M{"active": active, "ip": tracked.IP}},
+			options.UpdateOne().SetUpsert(true),
+		)
+	if err != nil {
+		return err
+	}
+
+	serverCacheMu.Lock()
+	s, ok := serverCacheMap[id]
+	if !ok {
+		s = data.Server{ID: tracked.ID, Name: tracked.Name, IP: tracked.IP, Type: tracked.Type, Metadata: tracked.Metadata}
+	}
+	s.Active = active
+	serverCacheMap[id] = s
+	serverCacheMu.Unlock()
+
+	if active {
+		if j := runningJob.Load(); j != nil {
+			j.Resume(id)
+		}
+	}
+	return nil
+}
+
+// ForcePing pings a tracked server right away, regardless of its schedule or
+// active flag, and returns its updated state.
+func ForcePing(id string) (data.Server, error) {
+	j := runningJob.Load()
+	if j == nil {
+		return data.Server{}, ErrJobNotRunning
+	}
+	if !j.PingNow(id) {
+		return data.Server{}, ErrServerNotFound
+	}
+
+	s, _ := GetServer(id)
+	return s, nil
+}
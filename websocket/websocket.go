@@ -23,8 +23,10 @@ var upgrader = websocket.Upgrader{
 type Hub struct {
 	clients       map[*websocket.Conn]bool
 	writeMu       map[*websocket.Conn]*sync.Mutex
-	subscriptions map[string]map[*websocket.Conn]bool
-	subNotify     map[string]chan bool
+	subscriptions map[string]map[*websocket.Conn]bool // keyed by server ID
+	groupSubs     map[string]map[*websocket.Conn]bool // keyed by group ID
+	listener      func(id string, subscribed bool)
+	resolver      func(key string) (string, bool)
 	mu            sync.RWMutex
 }
 
@@ -32,7 +34,48 @@ var GlobalHub = &Hub{
 	clients:       make(map[*websocket.Conn]bool),
 	writeMu:       make(map[*websocket.Conn]*sync.Mutex),
 	subscriptions: make(map[string]map[*websocket.Conn]bool),
-	subNotify:     make(map[string]chan bool),
+	groupSubs:     make(map[string]map[*websocket.Conn]bool),
+}
+
+// SetSubscriptionListener registers fn to be called whenever a server gains
+// its first subscriber (true) or loses its last one (false). fn is called
+// without the hub lock held.
+func (h *Hub) SetSubscriptionListener(fn func(id string, subscribed bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listener = fn
+}
+
+// SetServerResolver registers fn to turn the key a client subscribes with
+// (an ID or, for older clients, an address) into a server ID.
+func (h *Hub) SetServerResolver(fn func(key string) (string, bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.resolver = fn
+}
+
+// resolve maps a subscription key to a server ID, leaving it unchanged when
+// no resolver is set or the key is unknown.
+func (h *Hub) resolve(key string) string {
+	h.mu.RLock()
+	resolver := h.resolver
+	h.mu.RUnlock()
+
+	if resolver != nil {
+		if id, ok := resolver(key); ok {
+			return id
+		}
+	}
+	return key
+}
+
+func (h *Hub) notify(listener func(string, bool), ids []string, subscribed bool) {
+	if listener == nil {
+		return
+	}
+	for _, id := range ids {
+		listener(id, subscribed)
+	}
 }
 
 func (h *Hub) Register(conn *websocket.Conn) {
@@ -44,103 +87,121 @@ func (h *Hub) Register(conn *websocket.Conn) {
 
 func (h *Hub) Unregister(conn *websocket.Conn) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
 	delete(h.clients, conn)
 	delete(h.writeMu, conn)
 
-	for ip, subs := range h.subscriptions {
+	var emptied []string
+	for id, subs := range h.subscriptions {
 		if subs[conn] {
 			delete(subs, conn)
 
 			if len(subs) == 0 {
-				delete(h.subscriptions, ip)
-				if h.subNotify[ip] != nil {
-					select {
-					case h.subNotify[ip] <- false:
-					default:
-					}
-				}
+				delete(h.subscriptions, id)
+				emptied = append(emptied, id)
 			}
 		}
 	}
+
+	for id, subs := range h.groupSubs {
+		delete(subs, conn)
+		if len(subs) == 0 {
+			delete(h.groupSubs, id)
+		}
+	}
+
+	listener := h.listener
+	h.mu.Unlock()
+
+	h.notify(listener, emptied, false)
 }
 
-func (h *Hub) Subscribe(conn *websocket.Conn, ip string) {
+func (h *Hub) Subscribe(conn *websocket.Conn, id string) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	if h.subscriptions[ip] == nil {
-		h.subscriptions[ip] = make(map[*websocket.Conn]bool)
+	if h.subscriptions[id] == nil {
+		h.subscriptions[id] = make(map[*websocket.Conn]bool)
 	}
 
-	wasEmpty := len(h.subscriptions[ip]) == 0
-	h.subscriptions[ip][conn] = true
+	wasEmpty := len(h.subscriptions[id]) == 0
+	h.subscriptions[id][conn] = true
 
-	if wasEmpty && h.subNotify[ip] != nil {
-		select {
-		case h.subNotify[ip] <- true:
-		default:
-		}
+	listener := h.listener
+	h.mu.Unlock()
+
+	if wasEmpty {
+		h.notify(listener, []string{id}, true)
 	}
 }
 
-func (h *Hub) Unsubscribe(conn *websocket.Conn, ip string) {
+func (h *Hub) Unsubscribe(conn *websocket.Conn, id string) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	if subs, ok := h.subscriptions[ip]; ok {
+	emptied := false
+	if subs, ok := h.subscriptions[id]; ok {
 		delete(subs, conn)
 
 		if len(subs) == 0 {
-			delete(h.subscriptions, ip)
-			if h.subNotify[ip] != nil {
-				select {
-				case h.subNotify[ip] <- false:
-				default:
-				}
-			}
+			delete(h.subscriptions, id)
+			emptied = true
+		}
+	}
+
+	listener := h.listener
+	h.mu.Unlock()
+
+	if emptied {
+		h.notify(listener, []string{id}, false)
+	}
+}
+
+func (h *Hub) IsSubscribed(id string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscriptions[id]) > 0
+}
+
+func (h *Hub) GetSubscribedIDs() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ids := make([]string, 0, len(h.subscriptions))
+	for id, conns := range h.subscriptions {
+		if len(conns) > 0 {
+			ids = append(ids, id)
 		}
 	}
+	return ids
 }
 
-func (h *Hub) RegisterServerNotify(ip string) chan bool {
+// SubscribeGroup subscribes conn to the live totals of a group. Groups do not
+// affect ping scheduling, so the subscription listener is not notified.
+func (h *Hub) SubscribeGroup(conn *websocket.Conn, id string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if h.subNotify[ip] == nil {
-		h.subNotify[ip] = make(chan bool, 10)
+	if h.groupSubs[id] == nil {
+		h.groupSubs[id] = make(map[*websocket.Conn]bool)
 	}
-	return h.subNotify[ip]
+	h.groupSubs[id][conn] = true
 }
 
-func (h *Hub) UnregisterServerNotify(ip string) {
+func (h *Hub) UnsubscribeGroup(conn *websocket.Conn, id string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if ch, ok := h.subNotify[ip]; ok {
-		close(ch)
-		delete(h.subNotify, ip)
+	if subs, ok := h.groupSubs[id]; ok {
+		delete(subs, conn)
+		if len(subs) == 0 {
+			delete(h.groupSubs, id)
+		}
 	}
 }
 
-func (h *Hub) IsSubscribed(ip string) bool {
+func (h *Hub) HasGroupSubscribers(id string) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.subscriptions[ip]) > 0
-}
-
-func (h *Hub) GetSubscribedIPs() []string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	ips := make([]string, 0, len(h.subscriptions))
-	for ip, conns := range h.subscriptions {
-		if len(conns) > 0 {
-			ips = append(ips, ip)
-		}
-	}
-	return ips
+	return len(h.groupSubs[id]) > 0
 }
 
 func (h *Hub) writeJSONLocked(conn *websocket.Conn, v interface{}) error {
@@ -153,9 +214,17 @@ func (h *Hub) writeJSONLocked(conn *websocket.Conn, v interface{}) error {
 	return conn.WriteJSON(v)
 }
 
-func (h *Hub) SendToServer(ip string, message interface{}) {
+func (h *Hub) SendToServer(id string, message interface{}) {
+	h.sendTo(h.subscriptions, id, message)
+}
+
+func (h *Hub) SendToGroup(id string, message interface{}) {
+	h.sendTo(h.groupSubs, id, message)
+}
+
+func (h *Hub) sendTo(subscriptions map[string]map[*websocket.Conn]bool, id string, message interface{}) {
 	h.mu.RLock()
-	subs := h.subscriptions[ip]
+	subs := subscriptions[id]
 	conns := make([]*websocket.Conn, 0, len(subs))
 	for conn := range subs {
 		conns = append(conns, conn)
@@ -188,7 +257,16 @@ func (h *Hub) Broadcast(message interface{}) {
 
 type WSMessage struct {
 	Type string `json:"type"`
-	IP   string `json:"ip,omitempty"`
+	ID   string `json:"id,omitempty"`
+	IP   string `json:"ip,omitempty"` // accepted instead of id for older clients
+}
+
+// serverKey returns the server a message refers to.
+func (m WSMessage) serverKey() string {
+	if m.ID != "" {
+		return GlobalHub.resolve(m.ID)
+	}
+	return GlobalHub.resolve(m.IP)
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -217,10 +295,16 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		switch msg.Type {
 		case "subscribe_server":
-			GlobalHub.Subscribe(conn, msg.IP)
+			GlobalHub.Subscribe(conn, msg.serverKey())
 
 		case "unsubscribe_server":
-			GlobalHub.Unsubscribe(conn, msg.IP)
+			GlobalHub.Unsubscribe(conn, msg.serverKey())
+
+		case "subscribe_group":
+			GlobalHub.SubscribeGroup(conn, msg.ID)
+
+		case "unsubscribe_group":
+			GlobalHub.UnsubscribeGroup(conn, msg.ID)
 		}
 	}
 }